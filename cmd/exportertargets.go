@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/dachad/tcpgoon/promexp"
+
+	"github.com/spf13/cobra"
+)
+
+type exporterTargetsParams struct {
+	targetsFile string
+	exporter    string
+	configFile  string
+	output      string
+	connections int
+	sleep       int
+	skipInvalid bool
+}
+
+var exporterTargetsParameters exporterTargetsParams
+
+var exporterTargetsCmd = &cobra.Command{
+	Use:   "exporter-targets [flags] <targets file>",
+	Short: "Generate Prometheus file_sd / http_sd targets to probe through the exporter",
+	Long: `Reads a target per line, formatted as "host:port [module]", and generates the Prometheus
+service discovery JSON to scrape them through the exporter, with the __param_* labels each probe needs`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if err := validateExporterTargetsArgs(&exporterTargetsParameters, args); err != nil {
+			cmd.Println(err)
+			cmd.Println(cmd.UsageString())
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		runExporterTargets(exporterTargetsParameters)
+	},
+}
+
+func init() {
+	exporterTargetsCmd.Flags().StringVarP(&exporterTargetsParameters.exporter, "exporter", "e", "", "Address (host:port) Prometheus reaches the exporter at, as started by 'tcpgoon prometheus <port>' (required)")
+	exporterTargetsCmd.Flags().StringVarP(&exporterTargetsParameters.configFile, "config", "c", "", "Exporter YAML config file, required to validate targets using modules")
+	exporterTargetsCmd.Flags().StringVarP(&exporterTargetsParameters.output, "output", "o", "", "File to write the targets to, replacing it atomically. Standard output by default")
+	exporterTargetsCmd.Flags().IntVar(&exporterTargetsParameters.connections, "connections", 100, "Connections param of the targets without a module")
+	exporterTargetsCmd.Flags().IntVar(&exporterTargetsParameters.sleep, "sleep", 10, "Sleep param, in ms, of the targets without a module")
+	exporterTargetsCmd.Flags().BoolVar(&exporterTargetsParameters.skipInvalid, "skip-invalid", false, "Leave invalid targets out instead of failing")
+}
+
+func validateExporterTargetsArgs(params *exporterTargetsParams, args []string) error {
+	if len(args) != 1 {
+		return errors.New("Number of required parameters doesn't match")
+	}
+	params.targetsFile = args[0]
+
+	if params.exporter == "" {
+		return errors.New("Exporter address should be provided with --exporter")
+	}
+
+	if params.connections <= 0 || params.sleep < 0 {
+		return errors.New("Connections and sleep should be positive numbers")
+	}
+
+	return nil
+}
+
+func runExporterTargets(params exporterTargetsParams) {
+	file, err := os.Open(params.targetsFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not read the targets:", err)
+		os.Exit(1)
+	}
+	targets, err := promexp.ParseSDTargets(file)
+	file.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not parse the targets:", err)
+		os.Exit(1)
+	}
+
+	config := &promexp.SafeConfig{C: &promexp.Config{}}
+	if params.configFile != "" {
+		if err := config.ReloadConfig(params.configFile); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not load the exporter config:", err)
+			os.Exit(1)
+		}
+	}
+
+	groups, errs := promexp.NewTargetGroups(targets, params.exporter,
+		promexp.SDDefaults{Connections: params.connections, Sleep: params.sleep}, config)
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, "Invalid target", err)
+	}
+	if len(errs) > 0 && !params.skipInvalid {
+		os.Exit(1)
+	}
+
+	content, _ := json.MarshalIndent(groups, "", "  ")
+	content = append(content, '\n')
+	if params.output == "" {
+		os.Stdout.Write(content)
+		return
+	}
+	if err := writeFileAtomically(params.output, content); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not write the targets:", err)
+		os.Exit(1)
+	}
+}
+
+// writeFileAtomically replaces file with content, so Prometheus never reads it half written
+func writeFileAtomically(file string, content []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dachad/tcpgoon/cmdutil"
+	"github.com/dachad/tcpgoon/debugging"
+	"github.com/dachad/tcpgoon/mtcpclient"
+	"github.com/dachad/tcpgoon/tcpclient"
+
+	"github.com/spf13/cobra"
+)
+
+type findLimitParams struct {
+	target          string
+	port            int
+	start           int
+	max             int
+	precision       int
+	threshold       float64
+	delay           int
+	connDialTimeout int
+	hold            int
+	protocol        string
+	assumeyes       bool
+	debug           bool
+}
+
+var findLimitParameters findLimitParams
+
+var findLimitCmd = &cobra.Command{
+	Use:   "find-limit [flags] <host> <port>",
+	Short: "Search for the highest number of concurrent connections a server sustains",
+	Long: `Runs tcpgoon repeatedly, doubling the connections of each run until errors or connections closed by the
+peer exceed the threshold, and then binary searching between the last two runs`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if err := validateFindLimitArgs(&findLimitParameters, args); err != nil {
+			cmd.Println(err)
+			cmd.Println(cmd.UsageString())
+			os.Exit(1)
+		}
+		if findLimitParameters.debug {
+			debugging.EnableDebug()
+		}
+		if !(findLimitParameters.assumeyes || cmdutil.AskForUserConfirmation(findLimitParameters.target,
+			findLimitParameters.port, findLimitParameters.max)) {
+			fmt.Fprintln(debugging.DebugOut, "Execution not approved by the user")
+			cmdutil.CloseAbruptly()
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		runFindLimit(findLimitParameters)
+	},
+}
+
+func init() {
+	findLimitCmd.Flags().IntVar(&findLimitParameters.start, "start", 10, "Number of connections of the first run")
+	findLimitCmd.Flags().IntVarP(&findLimitParameters.max, "max-connections", "m", 10000, "Number of connections no run goes beyond")
+	findLimitCmd.Flags().IntVar(&findLimitParameters.precision, "precision", 10, "Stop the binary search once the number of connections is known within this margin")
+	findLimitCmd.Flags().Float64Var(&findLimitParameters.threshold, "threshold", 1, "Percentage of connections failing or closed by the peer a run sustains")
+	findLimitCmd.Flags().IntVarP(&findLimitParameters.delay, "sleep", "s", 10, "Time you want to sleep between connections, in ms")
+	findLimitCmd.Flags().IntVarP(&findLimitParameters.connDialTimeout, "dial-timeout", "t", 5000, "Connection dialing timeout, in ms")
+	findLimitCmd.Flags().IntVar(&findLimitParameters.hold, "hold", 1, "Time connections are held once none of them is pending in each run, in seconds")
+	findLimitCmd.Flags().StringVar(&findLimitParameters.protocol, "protocol", tcpclient.ProtocolTCP, "Application protocol to probe each connection with before considering it established: "+strings.Join(tcpclient.ProtocolNames(), ", "))
+	findLimitCmd.Flags().BoolVarP(&findLimitParameters.assumeyes, "assume-yes", "y", false, "Force execution without asking for confirmation")
+	findLimitCmd.Flags().BoolVarP(&findLimitParameters.debug, "debug", "d", false, "Print debugging information to the standard error")
+}
+
+func validateFindLimitArgs(params *findLimitParams, args []string) error {
+	if len(args) != 2 {
+		return errors.New("Number of required parameters doesn't match")
+	}
+	params.target = args[0]
+	port, err := strconv.Atoi(args[1])
+	if err != nil || port <= 0 || port > 65535 {
+		return errors.New("Port argument is not a valid port number")
+	}
+	params.port = port
+
+	if params.start <= 0 || params.max < params.start {
+		return errors.New("Start should be a positive number of connections, not greater than the max one")
+	}
+	if params.precision <= 0 {
+		return errors.New("Precision should be a positive number of connections")
+	}
+	if params.threshold < 0 || params.threshold >= 100 {
+		return errors.New("Threshold should be a percentage from 0 to 100")
+	}
+	if params.delay < 0 || params.connDialTimeout <= 0 || params.hold < 0 {
+		return errors.New("Sleep, dial timeout and hold should be positive numbers")
+	}
+	return tcpclient.ValidateProtocol(params.protocol)
+}
+
+func runFindLimit(params findLimitParams) {
+	opts := tcpclient.DefaultConnectOptions()
+	opts.DialTimeout = time.Duration(params.connDialTimeout) * time.Millisecond
+	opts.Protocol = params.protocol
+	search := mtcpclient.LimitSearch{
+		Start:     params.start,
+		Max:       params.max,
+		Precision: params.precision,
+		Threshold: params.threshold / 100,
+	}
+
+	ctx, interrupt := context.WithCancel(context.Background())
+	defer interrupt()
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, os.Interrupt)
+	go func() {
+		<-signalChannel
+		fmt.Println()
+		interrupt()
+	}()
+
+	fmt.Println("Searching the connections limit of", params.target+":"+strconv.Itoa(params.port),
+		"up to", params.max, "connections - press Ctrl+C to stop and report")
+	result := mtcpclient.SearchLimit(search, func(connections int) mtcpclient.LimitIteration {
+		fmt.Println("Running with", connections, "connections")
+		return mtcpclient.RunLimitIteration(ctx, connections, params.delay, []string{params.target}, params.port, opts,
+			time.Duration(params.hold)*time.Second, search.Threshold)
+	})
+	fmt.Print(result.CliReport())
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dachad/tcpgoon/debugging"
+	"github.com/dachad/tcpgoon/mtcpclient"
+	"github.com/dachad/tcpgoon/tcpclient"
+
+	"github.com/spf13/cobra"
+)
+
+type idleTimeoutParams struct {
+	target          string
+	port            int
+	connections     int
+	delay           int
+	connDialTimeout int
+	firstInterval   int
+	maxInterval     int
+	growth          float64
+	protocol        string
+	debug           bool
+}
+
+var idleTimeoutParameters idleTimeoutParams
+
+var idleTimeoutCmd = &cobra.Command{
+	Use:   "idle-timeout [flags] <host> <port>",
+	Short: "Estimate how long idle connections survive through firewalls, NATs and load balancers",
+	Long: `Keeps connections idle for growing periods of time, without TCP keepalives, checking after each
+of them whether they still work with the requests of a protocol probe (i.e. against tcpgoon server --echo).
+Connections interleave their idle times, so together they bound the idle timeout more tightly`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if err := validateIdleTimeoutArgs(&idleTimeoutParameters, args); err != nil {
+			cmd.Println(err)
+			cmd.Println(cmd.UsageString())
+			os.Exit(1)
+		}
+		if idleTimeoutParameters.debug {
+			debugging.EnableDebug()
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		runIdleTimeout(idleTimeoutParameters)
+	},
+}
+
+func init() {
+	idleTimeoutCmd.Flags().IntVarP(&idleTimeoutParameters.connections, "connections", "c", 4, "Number of connections kept idle in parallel")
+	idleTimeoutCmd.Flags().IntVarP(&idleTimeoutParameters.delay, "sleep", "s", 10, "Time you want to sleep between connections, in ms")
+	idleTimeoutCmd.Flags().IntVarP(&idleTimeoutParameters.connDialTimeout, "dial-timeout", "t", 5000, "Connection dialing timeout, in ms")
+	idleTimeoutCmd.Flags().IntVar(&idleTimeoutParameters.firstInterval, "first-interval", 10, "First idle time to check, in seconds")
+	idleTimeoutCmd.Flags().IntVar(&idleTimeoutParameters.maxInterval, "max-interval", 3600, "Longest idle time to check, in seconds")
+	idleTimeoutCmd.Flags().Float64Var(&idleTimeoutParameters.growth, "growth", 2, "Factor each idle time of a connection grows by")
+	idleTimeoutCmd.Flags().StringVar(&idleTimeoutParameters.protocol, "protocol", "echo", "Application protocol which requests check connections still work after being idle: "+strings.Join(tcpclient.RepeatableProtocolNames(), ", "))
+	idleTimeoutCmd.Flags().BoolVarP(&idleTimeoutParameters.debug, "debug", "d", false, "Print debugging information to the standard error")
+}
+
+func validateIdleTimeoutArgs(params *idleTimeoutParams, args []string) error {
+	if len(args) != 2 {
+		return errors.New("Number of required parameters doesn't match")
+	}
+	params.target = args[0]
+	port, err := strconv.Atoi(args[1])
+	if err != nil || port <= 0 || port > 65535 {
+		return errors.New("Port argument is not a valid port number")
+	}
+	params.port = port
+
+	if params.connections <= 0 || params.delay < 0 || params.connDialTimeout <= 0 {
+		return errors.New("Connections, sleep and dial timeout should be positive numbers")
+	}
+	if params.firstInterval <= 0 || params.maxInterval < params.firstInterval {
+		return errors.New("First interval should be positive, and not longer than the max one")
+	}
+	if params.growth <= 1 {
+		return errors.New("Growth should be greater than 1")
+	}
+	return tcpclient.ValidateRepeatableProtocol(params.protocol)
+}
+
+func runIdleTimeout(params idleTimeoutParams) {
+	opts := tcpclient.DefaultConnectOptions()
+	opts.DialTimeout = time.Duration(params.connDialTimeout) * time.Millisecond
+	opts.Protocol = params.protocol
+	opts.KeepAlive = -1
+	schedules := mtcpclient.IdleTimeoutSchedules(params.connections, time.Duration(params.firstInterval)*time.Second,
+		time.Duration(params.maxInterval)*time.Second, params.growth)
+
+	closeRequest := make(chan bool)
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, os.Interrupt)
+	go func() {
+		<-signalChannel
+		fmt.Println()
+		close(closeRequest)
+	}()
+
+	fmt.Println("Keeping", params.connections, "connections to", params.target+":"+strconv.Itoa(params.port),
+		"idle up to", time.Duration(params.maxInterval)*time.Second, "- press Ctrl+C to stop and report")
+	estimate := mtcpclient.DiscoverIdleTimeout(params.target, params.port, opts, schedules, params.delay, closeRequest)
+	fmt.Print(estimate.CliReport())
+}
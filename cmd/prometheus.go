@@ -1,9 +1,16 @@
 package cmd
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/dachad/tcpgoon/debugging"
 	"github.com/dachad/tcpgoon/promexp"
@@ -12,9 +19,25 @@ import (
 )
 
 type prometheusParams struct {
-	port            int
-	connDialTimeout int
-	debug           bool
+	port             int
+	connDialTimeout  int
+	debug            bool
+	configFile       string
+	timeoutOffset    float64
+	maxProbes        int
+	maxInFlightCons  int
+	maxQueued        int
+	maxConnections   int
+	maxSleep         int
+	allowTargets     []string
+	allowPorts       string
+	authUser         string
+	authPasswordFile string
+	authTokenFile    string
+	tlsCert          string
+	tlsKey           string
+	allowlist        *promexp.TargetAllowlist
+	credentials      promexp.Credentials
 }
 
 var prometheusparams prometheusParams
@@ -40,7 +63,21 @@ var prometheusCmd = &cobra.Command{
 
 func init() {
 	prometheusCmd.Flags().BoolVarP(&prometheusparams.debug, "debug", "d", false, "Print debugging information to the standard error")
-	prometheusCmd.Flags().IntVarP(&params.connDialTimeout, "dial-timeout", "t", 5000, "Connection dialing timeout, in ms")
+	prometheusCmd.Flags().IntVarP(&prometheusparams.connDialTimeout, "dial-timeout", "t", 5000, "Connection dialing timeout, in ms")
+	prometheusCmd.Flags().Float64Var(&prometheusparams.timeoutOffset, "timeout-offset", 0.5, "Offset, in seconds, to subtract from the Prometheus scrape timeout to bound probes")
+	prometheusCmd.Flags().IntVar(&prometheusparams.maxProbes, "max-concurrent-probes", 0, "Probes allowed to run at the same time, 0 for no limit")
+	prometheusCmd.Flags().IntVar(&prometheusparams.maxInFlightCons, "max-inflight-connections", 0, "Connections all running probes are allowed to open, 0 for no limit")
+	prometheusCmd.Flags().IntVar(&prometheusparams.maxQueued, "max-queued-probes", 10, "Probes allowed to wait for the concurrency limits before rejecting scrapes with a 503")
+	prometheusCmd.Flags().IntVar(&prometheusparams.maxConnections, "max-connections", 0, "Maximum connections param a request can ask for, 0 for no limit")
+	prometheusCmd.Flags().IntVar(&prometheusparams.maxSleep, "max-sleep", 0, "Maximum sleep param, in ms, a request can ask for, 0 for no limit")
+	prometheusCmd.Flags().StringSliceVar(&prometheusparams.allowTargets, "allow-target", nil, "Network (CIDR), IP or hostname glob (i.e. *.example.com) requests are allowed to probe. Can be repeated")
+	prometheusCmd.Flags().StringVar(&prometheusparams.allowPorts, "allow-ports", "", "Ports requests are allowed to probe, as a list and/or ranges (i.e. 8000-8010,9000)")
+	prometheusCmd.Flags().StringVar(&prometheusparams.authUser, "auth-user", "", "User callers have to present with basic auth")
+	prometheusCmd.Flags().StringVar(&prometheusparams.authPasswordFile, "auth-password-file", "", "File containing the password of the basic auth user")
+	prometheusCmd.Flags().StringVar(&prometheusparams.authTokenFile, "auth-token-file", "", "File containing the bearer token callers can present instead")
+	prometheusCmd.Flags().StringVar(&prometheusparams.tlsCert, "tls-cert", "", "PEM certificate file to serve HTTPS")
+	prometheusCmd.Flags().StringVar(&prometheusparams.tlsKey, "tls-key", "", "PEM private key file to serve HTTPS")
+	prometheusCmd.Flags().StringVarP(&prometheusparams.configFile, "config", "c", "", "YAML file describing the modules requests can refer to (reloaded on SIGHUP or POST /-/reload)")
 }
 
 func validatePrometheusArgs(params *prometheusParams, args []string) error {
@@ -53,9 +90,87 @@ func validatePrometheusArgs(params *prometheusParams, args []string) error {
 	}
 	params.port = port
 
+	if params.timeoutOffset < 0 {
+		return errors.New("Timeout offset should be a positive number")
+	}
+
+	if params.maxProbes < 0 || params.maxInFlightCons < 0 || params.maxQueued < 0 ||
+		params.maxConnections < 0 || params.maxSleep < 0 {
+		return errors.New("Limits should be positive numbers")
+	}
+
+	if len(params.allowTargets) > 0 || params.allowPorts != "" {
+		allowlist, err := promexp.NewTargetAllowlist(params.allowTargets, params.allowPorts)
+		if err != nil {
+			return err
+		}
+		params.allowlist = allowlist
+	}
+
+	if (params.authUser == "") != (params.authPasswordFile == "") {
+		return errors.New("Basic auth user and password file should be provided together")
+	}
+	params.credentials.User = params.authUser
+	if params.authPasswordFile != "" {
+		if params.credentials.Password, err = readSecretFile(params.authPasswordFile); err != nil {
+			return err
+		}
+	}
+	if params.authTokenFile != "" {
+		if params.credentials.Token, err = readSecretFile(params.authTokenFile); err != nil {
+			return err
+		}
+	}
+
+	if (params.tlsCert == "") != (params.tlsKey == "") {
+		return errors.New("TLS certificate and key should be provided together")
+	}
+
 	return nil
 }
 
 func runPrometheus(params prometheusParams) {
-	promexp.RunHTTP("0.0.0.0:"+strconv.Itoa(params.port), params.connDialTimeout)
+	// probes do not watch process signals themselves, so the exporter terminates them on SIGINT or SIGTERM
+	ctx, terminate := context.WithCancel(context.Background())
+	defer terminate()
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signalChannel
+		terminate()
+	}()
+
+	err := promexp.RunHTTP(ctx, "0.0.0.0:"+strconv.Itoa(params.port), promexp.Options{
+		ConnDialTimeout: params.connDialTimeout,
+		ConfigFile:      params.configFile,
+		TimeoutOffset:   time.Duration(params.timeoutOffset * float64(time.Second)),
+
+		MaxConcurrentProbes:    params.maxProbes,
+		MaxInFlightConnections: params.maxInFlightCons,
+		MaxQueuedProbes:        params.maxQueued,
+		MaxConnectionsPerProbe: params.maxConnections,
+		MaxSleep:               params.maxSleep,
+
+		Allowlist:   params.allowlist,
+		Credentials: params.credentials,
+		TLSCertFile: params.tlsCert,
+		TLSKeyFile:  params.tlsKey,
+	})
+	if err != nil {
+		fmt.Println("Could not run the prometheus exporter:", err)
+		os.Exit(1)
+	}
+}
+
+// readSecretFile returns the content of a file holding a secret, ignoring surrounding whitespace
+func readSecretFile(file string) (string, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(content))
+	if secret == "" {
+		return "", errors.New(file + " does not contain any secret")
+	}
+	return secret, nil
 }
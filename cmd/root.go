@@ -28,4 +28,7 @@ func AddCommands() {
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(serverCmd)
 	rootCmd.AddCommand(prometheusCmd)
+	rootCmd.AddCommand(exporterTargetsCmd)
+	rootCmd.AddCommand(idleTimeoutCmd)
+	rootCmd.AddCommand(findLimitCmd)
 }
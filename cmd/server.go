@@ -1,22 +1,38 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"sort"
 	"strconv"
 	"sync"
+	"time"
 
+	"github.com/dachad/tcpgoon/cmdutil"
 	"github.com/dachad/tcpgoon/tcpserver"
 
 	"github.com/spf13/cobra"
 )
 
 type TCPServerParams struct {
-	port           int
-	maxconnections int
-	duration       int
+	port                 int
+	portsSpec            string
+	ports                []int
+	maxconnections       int
+	duration             int
+	gracePeriod          int
+	listenersPerPort     int
+	backlog              int
+	proxyProtocol        bool
+	tls                  bool
+	tlsCert              string
+	tlsKey               string
+	tlsClientCA          string
+	tlsRequireClientCert bool
+	echo                 bool
 }
 
 var tcpserverparams TCPServerParams
@@ -39,13 +55,34 @@ var serverCmd = &cobra.Command{
 
 func init() {
 	serverCmd.Flags().IntVarP(&tcpserverparams.port, "port", "p", 54321, "TCP listening port, from 1024 to 65535")
+	serverCmd.Flags().StringVarP(&tcpserverparams.portsSpec, "ports", "P", "", "TCP listening ports, as a list and/or ranges (i.e. 8000-8010,9000). Overrides --port")
 	serverCmd.Flags().IntVarP(&tcpserverparams.maxconnections, "maxconnections", "m", 10, "How many total connections we will accept")
 	serverCmd.Flags().IntVarP(&tcpserverparams.duration, "duration", "d", 30, "Running time before dropping")
+	serverCmd.Flags().IntVarP(&tcpserverparams.gracePeriod, "grace-period", "g", 0, "Seconds to wait for active connections to finish when stopping")
+	serverCmd.Flags().IntVarP(&tcpserverparams.listenersPerPort, "listeners", "l", 1, "Listeners per port, sharing it with SO_REUSEPORT, each one with its own accept loop (linux only)")
+	serverCmd.Flags().IntVarP(&tcpserverparams.backlog, "backlog", "b", 0, "Listen backlog of the sockets, 0 for the system default (linux only)")
+	serverCmd.Flags().BoolVar(&tcpserverparams.proxyProtocol, "proxy-protocol", false, "Expect a PROXY protocol header (v1 or v2) on every connection")
+	serverCmd.Flags().BoolVar(&tcpserverparams.echo, "echo", false, "Send back every line received, without TCP keepalives (i.e. as the target of tcpgoon idle-timeout)")
+	serverCmd.Flags().BoolVar(&tcpserverparams.tls, "tls", false, "Negotiate TLS on accepted connections (self-signed certificate unless --tls-cert/--tls-key are set)")
+	serverCmd.Flags().StringVar(&tcpserverparams.tlsCert, "tls-cert", "", "PEM certificate file for the TLS listener")
+	serverCmd.Flags().StringVar(&tcpserverparams.tlsKey, "tls-key", "", "PEM private key file for the TLS listener")
+	serverCmd.Flags().StringVar(&tcpserverparams.tlsClientCA, "tls-client-ca", "", "PEM CA bundle used to verify client certificates")
+	serverCmd.Flags().BoolVar(&tcpserverparams.tlsRequireClientCert, "tls-require-client-cert", false, "Reject TLS clients not presenting a valid certificate")
 }
 
 func validateTCPServerArgs(params *TCPServerParams) error {
-	if params.port < 1024 || params.port > 65535 {
-		return errors.New(strconv.Itoa(params.port) + " is not a valid TCP port number for the server")
+	params.ports = []int{params.port}
+	if params.portsSpec != "" {
+		ports, err := cmdutil.ParsePorts(params.portsSpec)
+		if err != nil {
+			return err
+		}
+		params.ports = ports
+	}
+	for _, port := range params.ports {
+		if port < 1024 || port > 65535 {
+			return errors.New(strconv.Itoa(port) + " is not a valid TCP port number for the server")
+		}
 	}
 
 	if params.maxconnections < 0 {
@@ -56,14 +93,38 @@ func validateTCPServerArgs(params *TCPServerParams) error {
 		return errors.New("Duration argument should be a positive integer")
 	}
 
+	if params.gracePeriod < 0 {
+		return errors.New("Grace period argument should be a positive integer")
+	}
+
+	if params.listenersPerPort < 1 {
+		return errors.New("Listeners argument should be at least 1")
+	}
+
+	if params.backlog < 0 {
+		return errors.New("Backlog argument should be a positive integer")
+	}
+
+	if !params.tls && (params.tlsCert != "" || params.tlsKey != "" || params.tlsClientCA != "" || params.tlsRequireClientCert) {
+		return errors.New("TLS related arguments require the --tls flag")
+	}
+
+	if (params.tlsCert == "") != (params.tlsKey == "") {
+		return errors.New("TLS certificate and key should be provided together")
+	}
+
+	if params.tlsRequireClientCert && params.tlsClientCA == "" {
+		return errors.New("Requiring client certificates needs a client CA file")
+	}
+
 	return nil
 }
 
 func runTcpgoonServer(params TCPServerParams) {
 	if params.maxconnections == 0 && params.duration == 0 {
-		fmt.Println("Running the simple TCP server in port", params.port, "forever")
+		fmt.Println("Running the simple TCP server in ports", params.ports, "forever")
 	} else {
-		fmt.Println("Running the simple TCP server in port", params.port, "up to", params.maxconnections, "connections or", params.duration, "seconds, what happens first")
+		fmt.Println("Running the simple TCP server in ports", params.ports, "up to", params.maxconnections, "connections or", params.duration, "seconds, what happens first")
 	}
 
 	dispatcher := &tcpserver.Dispatcher{
@@ -71,24 +132,96 @@ func runTcpgoonServer(params TCPServerParams) {
 		Lock:     sync.RWMutex{},
 	}
 
-	var endWaiter sync.WaitGroup
-	endWaiter.Add(1)
+	if params.tls {
+		tlsConfig, err := tcpserver.NewTLSConfig(tcpserver.TLSOptions{
+			CertFile:          params.tlsCert,
+			KeyFile:           params.tlsKey,
+			ClientCAFile:      params.tlsClientCA,
+			RequireClientCert: params.tlsRequireClientCert,
+		})
+		if err != nil {
+			fmt.Println("Could not set up TLS:", err)
+			os.Exit(1)
+		}
+		if params.tlsCert == "" {
+			fmt.Println("Using an in-memory self-signed TLS certificate")
+		}
+		dispatcher.TLSConfig = tlsConfig
+	}
+
+	dispatcher.MaxConnections = params.maxconnections
+	dispatcher.ListenersPerPort = params.listenersPerPort
+	dispatcher.Backlog = params.backlog
+	dispatcher.ProxyProtocol = params.proxyProtocol
+	dispatcher.Echo = params.echo
+
+	ctx, stopServing := context.WithCancel(context.Background())
+	if params.duration != 0 {
+		ctx, stopServing = context.WithTimeout(context.Background(), time.Duration(params.duration)*time.Second)
+	}
+	defer stopServing()
+	drainCtx, abortDraining := context.WithCancel(context.Background())
+	defer abortDraining()
+
+	fmt.Println("Starting TCP server")
+	if err := dispatcher.Start(ctx, params.ports); err != nil {
+		fmt.Println("Could not start the TCP server", err)
+		os.Exit(1)
+	}
 
-	runTCPServer := func() {
-		fmt.Println("Starting TCP server")
-		if err := dispatcher.ListenHandlersComplete(params.port, params.maxconnections, params.duration, &endWaiter); err != nil {
-			fmt.Println("Could not start the TCP server", err)
-			return
+	waitForCtrlC(stopServing, abortDraining)
+
+	<-dispatcher.Done()
+	if ctx.Err() == context.DeadlineExceeded {
+		fmt.Println("Reached max duration:", params.duration, "seconds")
+	}
+
+	if params.gracePeriod > 0 {
+		fmt.Println("Waiting up to", params.gracePeriod, "seconds for active connections to finish")
+	}
+	graceCtx, graceCancel := context.WithTimeout(drainCtx, time.Duration(params.gracePeriod)*time.Second)
+	defer graceCancel()
+	report := dispatcher.Shutdown(graceCtx)
+	fmt.Println("Connections finished during shutdown:", report.Drained)
+	if len(report.StillOpen) > 0 {
+		fmt.Println("Connections closed by the shutdown:", len(report.StillOpen))
+		for _, addr := range report.StillOpen {
+			fmt.Println("\t", addr)
 		}
 	}
-	go runTCPServer()
+	printServerStats(dispatcher)
+}
 
-	waitForCtrlC(&endWaiter)
+func printServerStats(dispatcher *tcpserver.Dispatcher) {
+	portStats := dispatcher.PortStats()
+	if len(portStats) > 1 {
+		ports := make([]int, 0, len(portStats))
+		for port := range portStats {
+			ports = append(ports, port)
+		}
+		sort.Ints(ports)
+		for _, port := range ports {
+			fmt.Println("Port", port, "stats:", portStats[port])
+		}
+	}
+	fmt.Println("Server stats:", dispatcher.Stats())
 
-	endWaiter.Wait()
+	originalClients := dispatcher.OriginalClients()
+	if len(originalClients) > 0 {
+		ips := make([]string, 0, len(originalClients))
+		for ip := range originalClients {
+			ips = append(ips, ip)
+		}
+		sort.Strings(ips)
+		fmt.Println("Original clients, as reported by PROXY protocol headers:")
+		for _, ip := range ips {
+			fmt.Println("\t", ip, originalClients[ip])
+		}
+	}
 }
 
-func waitForCtrlC(endWaiter *sync.WaitGroup) {
+// waitForCtrlC stops serving on the first interruption, and aborts draining connections on the second one
+func waitForCtrlC(stopServing context.CancelFunc, abortDraining context.CancelFunc) {
 	signal_channel := make(chan os.Signal, 1)
 	fmt.Printf("Press Ctrl+C to end\n")
 	signal.Notify(signal_channel, os.Interrupt)
@@ -96,6 +229,9 @@ func waitForCtrlC(endWaiter *sync.WaitGroup) {
 	go func() {
 		<-signal_channel
 		fmt.Println()
-		endWaiter.Done()
+		stopServing()
+		<-signal_channel
+		fmt.Println()
+		abortDraining()
 	}()
 }
@@ -1,15 +1,21 @@
 package cmd
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dachad/tcpgoon/cmdutil"
 	"github.com/dachad/tcpgoon/debugging"
 	"github.com/dachad/tcpgoon/mtcpclient"
+	"github.com/dachad/tcpgoon/promexp"
 	"github.com/dachad/tcpgoon/tcpclient"
 	"github.com/spf13/cobra"
 )
@@ -24,6 +30,27 @@ type tcpgoonParams struct {
 	debug             bool
 	reportingInterval int
 	assumeyes         bool
+	proxyProtocol     int
+	proxySource       string
+	tls               bool
+	tlsInsecure       bool
+	tlsServerName     string
+	pushURL           string
+	pushJob           string
+	pushGrouping      map[string]string
+	remoteWriteURL    string
+	protocol          string
+	repeatInterval    int
+	httpMethod        string
+	httpPath          string
+	httpHeaders       []string
+	httpBody          string
+	httpStatusCodes   []int
+	httpBodyMatch     string
+	bannerMatch       string
+	grpcService       string
+	backendFrom       string
+	imbalance         float64
 }
 
 var params tcpgoonParams
@@ -53,6 +80,27 @@ func init() {
 	runCmd.Flags().BoolVarP(&params.debug, "debug", "d", false, "Print debugging information to the standard error")
 	runCmd.Flags().IntVarP(&params.reportingInterval, "interval", "i", 1, "Interval, in seconds, between stats updates")
 	runCmd.Flags().BoolVarP(&params.assumeyes, "assume-yes", "y", false, "Force execution without asking for confirmation")
+	runCmd.Flags().IntVar(&params.proxyProtocol, "proxy-protocol", 0, "Send a PROXY protocol header of this version (1 or 2) on each connection, 0 to disable")
+	runCmd.Flags().StringVar(&params.proxySource, "proxy-source", "", "Source address announced in the PROXY protocol header: an IP, or a network (CIDR) to pick random ones from")
+	runCmd.Flags().BoolVar(&params.tls, "tls", false, "Negotiate TLS on each connection, verifying the certificate of the target")
+	runCmd.Flags().BoolVar(&params.tlsInsecure, "tls-insecure", false, "Skip the verification of the TLS certificate of the target")
+	runCmd.Flags().StringVar(&params.tlsServerName, "tls-server-name", "", "Server name sent via SNI and verified against the TLS certificate, instead of the target host")
+	runCmd.Flags().StringVar(&params.protocol, "protocol", tcpclient.ProtocolTCP, "Application protocol to probe each connection with before considering it established: "+strings.Join(tcpclient.ProtocolNames(), ", "))
+	runCmd.Flags().IntVar(&params.repeatInterval, "repeat-interval", 0, "Repeat the protocol requests on each held connection with this interval, in ms (i.e. HTTP keep-alive, or WebSocket pings). 0 to disable. Supported protocols: "+strings.Join(tcpclient.RepeatableProtocolNames(), ", "))
+	runCmd.Flags().StringVar(&params.httpMethod, "http-method", http.MethodGet, "Method of the requests of the http protocol")
+	runCmd.Flags().StringVar(&params.httpPath, "http-path", "/", "Path of the requests of the http protocol, and of the upgrade requests of the websocket one")
+	runCmd.Flags().StringArrayVar(&params.httpHeaders, "http-header", nil, "Header of the requests of the http protocol, and of the upgrade requests of the websocket one, as \"Name: value\". Can be repeated")
+	runCmd.Flags().StringVar(&params.httpBody, "http-body", "", "Body of the requests of the http protocol")
+	runCmd.Flags().IntSliceVar(&params.httpStatusCodes, "http-status", nil, "Response status codes the http protocol accepts (i.e. 200,204). Any 2xx or 3xx by default")
+	runCmd.Flags().StringVar(&params.httpBodyMatch, "http-body-match", "", "Regular expression the response bodies of the http protocol have to match")
+	runCmd.Flags().StringVar(&params.bannerMatch, "banner-match", "", "Regular expression the banners of the banner protocol (i.e. SSH, SMTP or FTP servers) have to match")
+	runCmd.Flags().StringVar(&params.grpcService, "grpc-service", "", "Service the grpc protocol checks the health of with grpc.health.v1.Health/Check. Empty checks the whole server")
+	runCmd.Flags().StringVar(&params.backendFrom, "backend-from", "", "Identify the backend serving each connection, to report how they were spread: first-line or banner:<regular expression> (banner protocol), or header:<name> (HTTP based protocols)")
+	runCmd.Flags().Float64Var(&params.imbalance, "imbalance-threshold", 20, "Flag backends which share of connections deviates more than this percentage from an even spread")
+	runCmd.Flags().StringVar(&params.pushURL, "push-url", "", "Pushgateway URL to push the results to once finished")
+	runCmd.Flags().StringVar(&params.pushJob, "push-job", "tcpgoon", "Job name the results are pushed under")
+	runCmd.Flags().StringToStringVar(&params.pushGrouping, "push-grouping", nil, "Grouping labels of the pushed results (i.e. env=ci,pipeline=nightly)")
+	runCmd.Flags().StringVar(&params.remoteWriteURL, "remote-write-url", "", "Prometheus remote write URL to send the results to once finished")
 }
 
 func validateRequiredArgs(params *tcpgoonParams, args []string) error {
@@ -74,9 +122,94 @@ func validateRequiredArgs(params *tcpgoonParams, args []string) error {
 	}
 	params.port = port
 
+	if params.proxyProtocol < 0 || params.proxyProtocol > 2 {
+		return errors.New("PROXY protocol version should be 1 or 2")
+	}
+	if params.proxySource != "" {
+		if params.proxyProtocol == 0 {
+			return errors.New("PROXY protocol source requires a PROXY protocol version")
+		}
+		if _, err := cmdutil.ParseIPOrNetwork(params.proxySource); err != nil {
+			return err
+		}
+	}
+
+	if !params.tls && (params.tlsInsecure || params.tlsServerName != "") {
+		return errors.New("TLS related arguments require the --tls flag")
+	}
+
+	if err := tcpclient.ValidateProtocol(params.protocol); err != nil {
+		return err
+	}
+	if params.repeatInterval < 0 {
+		return errors.New("Repeat interval should be a positive number")
+	}
+	if params.repeatInterval > 0 {
+		if err := tcpclient.ValidateRepeatableProtocol(params.protocol); err != nil {
+			return err
+		}
+	}
+	if _, err := httpProbeConfig(*params); err != nil {
+		return err
+	}
+	if _, err := regexp.Compile(params.bannerMatch); err != nil {
+		return err
+	}
+	backendExtractor, err := tcpclient.ParseBackendExtractor(params.backendFrom)
+	if err != nil {
+		return err
+	}
+	if err := backendExtractor.ValidateProtocol(params.protocol); err != nil {
+		return err
+	}
+	if params.imbalance <= 0 {
+		return errors.New("Imbalance threshold should be a positive percentage")
+	}
+
+	if params.pushJob == "" && (params.pushURL != "" || params.remoteWriteURL != "") {
+		return errors.New("Pushing results requires a job name")
+	}
+	if err := promexp.CheckPushGrouping(params.pushGrouping); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// httpProbeConfig builds the configuration of the http protocol out of its flags
+func httpProbeConfig(params tcpgoonParams) (tcpclient.HTTPProbeConfig, error) {
+	config := tcpclient.HTTPProbeConfig{
+		Method:      params.httpMethod,
+		Path:        params.httpPath,
+		Headers:     make(http.Header),
+		Body:        []byte(params.httpBody),
+		StatusCodes: params.httpStatusCodes,
+	}
+	if !strings.HasPrefix(config.Path, "/") {
+		return config, errors.New("HTTP path should start with /")
+	}
+	for _, header := range params.httpHeaders {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return config, errors.New("HTTP header " + header + " should be formatted as \"Name: value\"")
+		}
+		config.Headers.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	for _, code := range config.StatusCodes {
+		if code < 100 || code > 599 {
+			return config, errors.New("HTTP status " + strconv.Itoa(code) + " is not valid")
+		}
+	}
+	if params.httpBodyMatch != "" {
+		bodyMatch, err := regexp.Compile(params.httpBodyMatch)
+		if err != nil {
+			return config, err
+		}
+		config.BodyMatch = bodyMatch
+	}
+	return config, nil
+}
+
 func enableDebuggingIfFlagSet(params tcpgoonParams) {
 	if params.debug {
 		debugging.EnableDebug()
@@ -90,16 +223,71 @@ func autorunValidation(params tcpgoonParams) {
 	}
 }
 
+// connectOptions describes how each connection is opened and probed, out of the already validated flags
+func connectOptions(params tcpgoonParams) tcpclient.ConnectOptions {
+	opts := tcpclient.DefaultConnectOptions()
+	opts.DialTimeout = time.Duration(params.connDialTimeout) * time.Millisecond
+	opts.ProxyProtocol.Version = params.proxyProtocol
+	if params.proxySource != "" {
+		opts.ProxyProtocol.SourceNetwork, _ = cmdutil.ParseIPOrNetwork(params.proxySource)
+	}
+	if params.tls {
+		opts.TLSConfig = &tls.Config{InsecureSkipVerify: params.tlsInsecure, ServerName: params.tlsServerName}
+	}
+	opts.Protocol = params.protocol
+	opts.RepeatInterval = time.Duration(params.repeatInterval) * time.Millisecond
+	opts.HTTP, _ = httpProbeConfig(params)
+	if params.bannerMatch != "" {
+		opts.BannerMatch = regexp.MustCompile(params.bannerMatch)
+	}
+	opts.GRPCHealthService = params.grpcService
+	opts.Backend, _ = tcpclient.ParseBackendExtractor(params.backendFrom)
+	return opts
+}
+
 func run(params tcpgoonParams) {
-	tcpclient.DefaultDialTimeoutInMs = params.connDialTimeout
+	opts := connectOptions(params)
 
 	// TODO: we should decouple the caller from the mtcpclient package (too many structures being moved from
 	//  one side to the other.. everything in a single structure, or applying something like the builder pattern,
 	//  may help
 	connStatusCh, connStatusTracker := mtcpclient.StartBackgroundReporting(params.numberConnections, params.reportingInterval)
 	closureCh := mtcpclient.StartBackgroundClosureTrigger(*connStatusTracker)
-	mtcpclient.MultiTCPConnect(params.numberConnections, params.delay, params.target, params.port, connStatusCh, closureCh)
+	start := time.Now()
+	mtcpclient.MultiTCPConnectWithOptions(params.numberConnections, params.delay, params.target, params.port, opts,
+		connStatusCh, closureCh)
+	duration := time.Since(start)
 	fmt.Fprintln(debugging.DebugOut, "Tests execution completed")
 
-	cmdutil.CloseNicely(params.targetip, params.target, params.port, *connStatusTracker)
+	cmdutil.CloseNicelyAfter(params.targetip, params.target, params.port, *connStatusTracker, params.imbalance/100, func() {
+		pushResults(params, *connStatusTracker, duration)
+	})
+}
+
+// pushResults sends the results of the execution to the Pushgateway and/or remote write receiver, if requested
+func pushResults(params tcpgoonParams, gc mtcpclient.GroupOfConnections, duration time.Duration) {
+	if params.pushURL == "" && params.remoteWriteURL == "" {
+		return
+	}
+	results := promexp.RunResults{
+		TargetIP:             params.targetip,
+		TargetPort:           params.port,
+		Sleep:                params.delay,
+		DialTimeout:          params.connDialTimeout,
+		AttemptedConnections: params.numberConnections,
+		Success:              !gc.PendingConnections() && !gc.AtLeastOneConnectionInError(),
+		Duration:             duration,
+		Report:               mtcpclient.NewFinalMetricsReport(gc),
+	}
+	err := promexp.PushRunResults(results, promexp.PushOptions{
+		PushgatewayURL: params.pushURL,
+		Job:            params.pushJob,
+		Grouping:       params.pushGrouping,
+		RemoteWriteURL: params.remoteWriteURL,
+	})
+	if err != nil {
+		fmt.Println("Could not push the results:", err)
+		return
+	}
+	fmt.Fprintln(debugging.DebugOut, "Results pushed")
 }
@@ -15,7 +15,17 @@ const (
 )
 
 func CloseNicely(ip, host string, port int, gc mtcpclient.GroupOfConnections) {
-	printClosureReport(ip, host, port, gc)
+	CloseNicelyAfter(ip, host, port, gc, mtcpclient.DefaultImbalanceThreshold, nil)
+}
+
+// CloseNicelyAfter behaves as CloseNicely, flagging backends imbalanced beyond imbalanceThreshold in the
+// closure report, and running beforeExit, if set, once it is printed
+func CloseNicelyAfter(ip, host string, port int, gc mtcpclient.GroupOfConnections, imbalanceThreshold float64,
+	beforeExit func()) {
+	printClosureReport(ip, host, port, gc, imbalanceThreshold)
+	if beforeExit != nil {
+		beforeExit()
+	}
 	if gc.PendingConnections() {
 		fmt.Fprintln(debugging.DebugOut, "We detected some connections did not complete")
 		os.Exit(incompleteExecutionExitStatus)
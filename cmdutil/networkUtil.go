@@ -0,0 +1,27 @@
+package cmdutil
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// ParseIPOrNetwork accepts either a CIDR or a single IP, which is returned as a network of a single host
+func ParseIPOrNetwork(spec string) (*net.IPNet, error) {
+	if strings.Contains(spec, "/") {
+		_, network, err := net.ParseCIDR(spec)
+		if err != nil {
+			return nil, errors.New(spec + " is not a valid network")
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(spec)
+	if ip == nil {
+		return nil, errors.New(spec + " is not a valid IP address")
+	}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return &net.IPNet{IP: ipv4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
@@ -0,0 +1,47 @@
+package cmdutil
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParsePorts expands a comma separated list of ports and port ranges (i.e. "8000-8010,9000")
+// into a sorted list of unique ports, all of them from 1 to 65535
+func ParsePorts(spec string) ([]int, error) {
+	unique := make(map[int]bool)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		bounds := strings.SplitN(item, "-", 2)
+		first, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, errors.New(item + " is not a valid port or port range")
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil || last < first {
+				return nil, errors.New(item + " is not a valid port range")
+			}
+		}
+		if first < 1 || last > 65535 {
+			return nil, errors.New(item + " is out of the range of valid ports, 1-65535")
+		}
+		for port := first; port <= last; port++ {
+			unique[port] = true
+		}
+	}
+	if len(unique) == 0 {
+		return nil, errors.New("No ports provided")
+	}
+
+	ports := make([]int, 0, len(unique))
+	for port := range unique {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
@@ -0,0 +1,68 @@
+package cmdutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	var portsScenariosChecks = []struct {
+		scenarioDescription string
+		spec                string
+		expectedPorts       []int
+		expectedError       bool
+	}{
+		{
+			scenarioDescription: "Ports and port ranges should be expanded into a sorted list",
+			spec:                "9000, 8000-8002",
+			expectedPorts:       []int{8000, 8001, 8002, 9000},
+		},
+		{
+			scenarioDescription: "Duplicated and overlapping ports should be listed once",
+			spec:                "8001,8000-8002,8002-8003,8001",
+			expectedPorts:       []int{8000, 8001, 8002, 8003},
+		},
+		{
+			scenarioDescription: "Ranges of the valid ports bounds should be accepted",
+			spec:                "1-2,65535-65535",
+			expectedPorts:       []int{1, 2, 65535},
+		},
+		{
+			scenarioDescription: "Reversed port ranges should be rejected",
+			spec:                "8010-8000",
+			expectedError:       true,
+		},
+		{
+			scenarioDescription: "Ranges going beyond the highest port should be rejected before being expanded",
+			spec:                "1-2000000000",
+			expectedError:       true,
+		},
+		{
+			scenarioDescription: "Port 0 should be rejected",
+			spec:                "0",
+			expectedError:       true,
+		},
+		{
+			scenarioDescription: "Ports higher than 65535 should be rejected",
+			spec:                "8000,70000",
+			expectedError:       true,
+		},
+		{
+			scenarioDescription: "Ports which are not numbers should be rejected",
+			spec:                "http",
+			expectedError:       true,
+		},
+		{
+			scenarioDescription: "Specs without ports should be rejected",
+			spec:                " , ",
+			expectedError:       true,
+		},
+	}
+
+	for _, test := range portsScenariosChecks {
+		ports, err := ParsePorts(test.spec)
+		if (err != nil) != test.expectedError || !reflect.DeepEqual(ports, test.expectedPorts) {
+			t.Error(test.scenarioDescription+", and the ports are:", ports, err)
+		}
+	}
+}
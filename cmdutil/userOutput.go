@@ -11,7 +11,7 @@ import (
 	"github.com/dachad/tcpgoon/mtcpclient"
 )
 
-func printClosureReport(ip string, host string, port int, gc mtcpclient.GroupOfConnections) {
+func printClosureReport(ip string, host string, port int, gc mtcpclient.GroupOfConnections, imbalanceThreshold float64) {
 	// workaround to allow last status updates - messages in channels - to be collected properly
 	// TODO: This can be fixed with an extra channel
 	const timeToWaitForClosureReportInMs = 100
@@ -24,7 +24,9 @@ func printClosureReport(ip string, host string, port int, gc mtcpclient.GroupOfC
 
 	fmt.Println(strings.Repeat("-", 3), target+":"+strconv.Itoa(port), "tcp test statistics", strings.Repeat("-", 3))
 	mtcpclient.ReportConnectionsStatus(gc, 0)
-	fmt.Println(mtcpclient.NewFinalMetricsReport(gc).CliReport())
+	report := mtcpclient.NewFinalMetricsReport(gc)
+	report.ImbalanceThreshold = imbalanceThreshold
+	fmt.Println(report.CliReport())
 }
 
 func AskForUserConfirmation(host string, port int, connections int) bool {
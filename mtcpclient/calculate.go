@@ -35,29 +35,47 @@ func (m *metricsCollectionStats) StdDev() time.Duration    { return m.stdDev }
 func (m *metricsCollectionStats) NumberOfConnections() int { return m.numberOfConnections }
 
 func (gc GroupOfConnections) calculateMetricsReport() (mr *metricsCollectionStats) {
+	return calculateDurationsReport(gc.processingDurations())
+}
+
+func (gc GroupOfConnections) processingDurations() []time.Duration {
+	durations := make([]time.Duration, len(gc.connections))
+	for i, item := range gc.connections {
+		durations[i] = item.GetTCPProcessingDuration()
+	}
+	return durations
+}
+
+func calculateDurationsReport(durations []time.Duration) (mr *metricsCollectionStats) {
 	mr = newMetricsCollectionStats()
-	if mr.numberOfConnections = len(gc.connections); mr.numberOfConnections > 0 {
-		for _, item := range gc.connections {
-			mr.min = time.Duration(math.Min(float64(mr.min), float64(item.GetTCPProcessingDuration())))
-			mr.max = time.Duration(math.Max(float64(mr.max), float64(item.GetTCPProcessingDuration())))
-			mr.total += item.GetTCPProcessingDuration()
+	if mr.numberOfConnections = len(durations); mr.numberOfConnections > 0 {
+		// durations can be longer than the default dial timeout the minimum starts from
+		mr.min = durations[0]
+		for _, duration := range durations {
+			mr.min = time.Duration(math.Min(float64(mr.min), float64(duration)))
+			mr.max = time.Duration(math.Max(float64(mr.max), float64(duration)))
+			mr.total += duration
 		}
 		mr.avg = mr.total / time.Duration(mr.numberOfConnections)
-		mr.stdDev = gc.calculateStdDev(mr.avg)
+		mr.stdDev = durationsStdDev(durations, mr.avg)
 	}
 	return mr
 }
 
 func (gc GroupOfConnections) calculateStdDev(avg time.Duration) time.Duration {
+	return durationsStdDev(gc.processingDurations(), avg)
+}
+
+func durationsStdDev(durations []time.Duration, avg time.Duration) time.Duration {
 	var sd float64
 
-	if len(gc.connections) == 0 {
+	if len(durations) == 0 {
 		return 0
 	}
 
-	for _, item := range gc.connections {
-		sd += math.Pow(float64(item.GetTCPProcessingDuration())-float64(avg), 2)
+	for _, duration := range durations {
+		sd += math.Pow(float64(duration)-float64(avg), 2)
 	}
 
-	return time.Duration(math.Sqrt(sd / float64(len(gc.connections))))
+	return time.Duration(math.Sqrt(sd / float64(len(durations))))
 }
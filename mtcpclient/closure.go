@@ -1,6 +1,7 @@
 package mtcpclient
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
@@ -13,12 +14,20 @@ import (
 // StartBackgroundClosureTrigger creates proper channels to know when to close execution
 // and triggers a goroutine that monitors if the closure conditions are met
 func StartBackgroundClosureTrigger(gc GroupOfConnections) <-chan bool {
-	closureCh := make(chan bool)
-
 	signalsCh := make(chan os.Signal, 1)
 	registerProperSignals(signalsCh)
 
-	go closureMonitor(gc, signalsCh, closureCh)
+	closureCh := make(chan bool)
+	go closureMonitor(context.Background(), gc, 0, signalsCh, closureCh)
+	return closureCh
+}
+
+// StartBackgroundClosureTriggerWithContext behaves as StartBackgroundClosureTrigger, but keeps the
+// connections open for holdTime once none of them is pending, and closes execution when ctx is done
+// instead of on process signals, which are left to the caller (i.e. exporter probes run concurrently)
+func StartBackgroundClosureTriggerWithContext(ctx context.Context, gc GroupOfConnections, holdTime time.Duration) <-chan bool {
+	closureCh := make(chan bool)
+	go closureMonitor(ctx, gc, holdTime, nil, closureCh)
 	return closureCh
 }
 
@@ -32,9 +41,12 @@ func registerProperSignals(signalsCh chan os.Signal) {
 }
 
 // closureMonitor polls a connections slice, to see if there's connections pending
-//  to be triggered, and a signal channel, in case execution is interrupted
-func closureMonitor(gc GroupOfConnections, signalsCh chan os.Signal,
+// to be triggered, and a signal channel (if any), in case execution is interrupted
+func closureMonitor(ctx context.Context, gc GroupOfConnections, holdTime time.Duration, signalsCh chan os.Signal,
 	closureCh chan bool) {
+	if signalsCh != nil {
+		defer signal.Stop(signalsCh)
+	}
 	const pullingPeriodInMs = 500
 	for {
 		select {
@@ -42,11 +54,31 @@ func closureMonitor(gc GroupOfConnections, signalsCh chan os.Signal,
 			fmt.Fprintln(debugging.DebugOut, "We captured a closure signal:", signal)
 			close(closureCh)
 			return
+		case <-ctx.Done():
+			fmt.Fprintln(debugging.DebugOut, "Execution context is done:", ctx.Err())
+			close(closureCh)
+			return
 		case <-time.After(pullingPeriodInMs * time.Millisecond):
 			if !gc.PendingConnections() {
+				holdConnections(ctx, holdTime, signalsCh)
 				close(closureCh)
 				return
 			}
 		}
 	}
 }
+
+// holdConnections waits for holdTime before letting connections to be closed, unless execution is interrupted
+func holdConnections(ctx context.Context, holdTime time.Duration, signalsCh chan os.Signal) {
+	if holdTime <= 0 {
+		return
+	}
+	fmt.Fprintln(debugging.DebugOut, "Holding connections for", holdTime)
+	select {
+	case signal := <-signalsCh:
+		fmt.Fprintln(debugging.DebugOut, "We captured a closure signal:", signal)
+	case <-ctx.Done():
+		fmt.Fprintln(debugging.DebugOut, "Execution context is done:", ctx.Err())
+	case <-time.After(holdTime):
+	}
+}
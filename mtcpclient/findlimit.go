@@ -0,0 +1,146 @@
+package mtcpclient
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dachad/tcpgoon/debugging"
+	"github.com/dachad/tcpgoon/tcpclient"
+)
+
+// LimitSearch describes how to search for the highest number of concurrent connections a target sustains
+type LimitSearch struct {
+	// Start is the number of connections of the first iteration, doubled on each sustainable one
+	Start int
+	// Max is the number of connections the search never goes beyond
+	Max int
+	// Precision is how close the bounds of the binary search get before stopping it, at least 1
+	Precision int
+	// Threshold is the share of the connections which may fail or get closed by the peer in a sustainable iteration
+	Threshold float64
+}
+
+// LimitIteration describes how a run of the search went
+type LimitIteration struct {
+	Connections  int
+	Established  int
+	Errors       int
+	ClosedByPeer int
+	Duration     time.Duration
+	Sustainable  bool
+	// Interrupted tells the run did not complete, so it does not count towards the search
+	Interrupted bool
+}
+
+// LimitResult is the outcome of the search
+type LimitResult struct {
+	// Limit is the highest number of concurrently established connections of the sustainable iterations
+	Limit int
+	// Reached tells an iteration was not sustainable. Otherwise, the target sustained every iteration up to Max
+	Reached    bool
+	Iterations []LimitIteration
+}
+
+// SearchLimit runs iterations with exponentially growing connections until one is not sustainable, and then
+// binary searches between the last sustainable one and it, down to the precision of the search
+func SearchLimit(search LimitSearch, run func(connections int) LimitIteration) LimitResult {
+	var result LimitResult
+	lastSustainable, firstUnsustainable := 0, 0
+	try := func(connections int) bool {
+		iteration := run(connections)
+		result.Iterations = append(result.Iterations, iteration)
+		switch {
+		case iteration.Interrupted:
+			return false
+		case iteration.Sustainable:
+			lastSustainable = connections
+			if iteration.Established > result.Limit {
+				result.Limit = iteration.Established
+			}
+		default:
+			firstUnsustainable = connections
+			result.Reached = true
+		}
+		return true
+	}
+
+	for connections := search.Start; firstUnsustainable == 0; connections *= 2 {
+		if connections > search.Max {
+			connections = search.Max
+		}
+		if !try(connections) {
+			return result
+		}
+		if connections == search.Max {
+			break
+		}
+	}
+	precision := search.Precision
+	if precision < 1 {
+		precision = 1
+	}
+	for firstUnsustainable > 0 && firstUnsustainable-lastSustainable > precision {
+		if !try((lastSustainable + firstUnsustainable) / 2) {
+			return result
+		}
+	}
+	return result
+}
+
+// RunLimitIteration opens connections as MultiTCPConnectToHosts does, holding them for holdTime once none
+// is pending, and tells whether the share of them failing or closed by the peer is within threshold
+func RunLimitIteration(ctx context.Context, connections int, delay int, hosts []string, port int,
+	opts tcpclient.ConnectOptions, holdTime time.Duration, threshold float64) LimitIteration {
+	start := time.Now()
+	connStatusCh, connStatusTracker := StartBackgroundReporting(connections, 0)
+	closureCh := StartBackgroundClosureTriggerWithContext(ctx, *connStatusTracker, holdTime)
+	MultiTCPConnectToHosts(connections, delay, hosts, port, opts, connStatusCh, closureCh)
+	// as the CLI does, allow last status updates - messages in channels - to be collected properly
+	time.Sleep(100 * time.Millisecond)
+
+	report := NewFinalMetricsReport(*connStatusTracker)
+	iteration := LimitIteration{
+		Connections:  connections,
+		Established:  report.MaxConcurrentCons(),
+		ClosedByPeer: report.ClosedByPeerCons(),
+		Duration:     time.Since(start),
+		Interrupted:  ctx.Err() != nil,
+	}
+	for _, failed := range report.FailedConsByReason() {
+		iteration.Errors += failed
+	}
+	iteration.Sustainable = float64(iteration.Errors+iteration.ClosedByPeer) <= threshold*float64(connections)
+	fmt.Fprintln(debugging.DebugOut, "Limit search iteration finished:", iteration)
+	return iteration
+}
+
+// CliReport describes every iteration of the search and the limit found
+func (r LimitResult) CliReport() string {
+	var table strings.Builder
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Iteration\tConnections\tEstablished\tErrors\tClosed by peer\tDuration\tResult")
+	for i, iteration := range r.Iterations {
+		outcome := "sustainable"
+		if iteration.Interrupted {
+			outcome = "interrupted"
+		} else if !iteration.Sustainable {
+			outcome = "not sustainable"
+		}
+		fmt.Fprintln(w, strconv.Itoa(i+1)+"\t"+strconv.Itoa(iteration.Connections)+"\t"+
+			strconv.Itoa(iteration.Established)+"\t"+strconv.Itoa(iteration.Errors)+"\t"+
+			strconv.Itoa(iteration.ClosedByPeer)+"\t"+iteration.Duration.Round(time.Millisecond).String()+"\t"+outcome)
+	}
+	w.Flush()
+
+	report := "--- tcpgoon connections limit search ---\n" + table.String()
+	if r.Reached {
+		report += "Highest sustainable concurrent established connections: " + strconv.Itoa(r.Limit) + "\n"
+	} else {
+		report += "No limit reached: up to " + strconv.Itoa(r.Limit) + " concurrent established connections were sustained\n"
+	}
+	return report
+}
@@ -0,0 +1,95 @@
+package mtcpclient
+
+import (
+	"testing"
+)
+
+// sustainingUpTo simulates a target which establishes up to limit connections, failing the rest of them
+func sustainingUpTo(limit int, threshold float64) func(int) LimitIteration {
+	return func(connections int) LimitIteration {
+		iteration := LimitIteration{Connections: connections, Established: connections}
+		if connections > limit {
+			iteration.Established = limit
+			iteration.Errors = connections - limit
+		}
+		iteration.Sustainable = float64(iteration.Errors) <= threshold*float64(connections)
+		return iteration
+	}
+}
+
+func TestSearchLimit(t *testing.T) {
+	var searchScenariosChecks = []struct {
+		scenarioDescription string
+		search              LimitSearch
+		run                 func(int) LimitIteration
+		expectedLimit       int
+		expectedReached     bool
+		expectedConnections []int
+	}{
+		{
+			scenarioDescription: "Targets with a limit should be searched exponentially and then with a binary search",
+			search:              LimitSearch{Start: 10, Max: 1000, Precision: 1},
+			run:                 sustainingUpTo(37, 0),
+			expectedLimit:       37,
+			expectedReached:     true,
+			expectedConnections: []int{10, 20, 40, 30, 35, 37, 38},
+		},
+		{
+			scenarioDescription: "Binary searches should stop once bounds are as close as the precision",
+			search:              LimitSearch{Start: 10, Max: 1000, Precision: 5},
+			run:                 sustainingUpTo(37, 0),
+			expectedLimit:       35,
+			expectedReached:     true,
+			expectedConnections: []int{10, 20, 40, 30, 35},
+		},
+		{
+			scenarioDescription: "Errors within the threshold should be sustainable",
+			search:              LimitSearch{Start: 10, Max: 1000, Precision: 10, Threshold: 0.1},
+			run:                 sustainingUpTo(37, 0.1),
+			expectedLimit:       37,
+			expectedReached:     true,
+			expectedConnections: []int{10, 20, 40, 80, 60, 50},
+		},
+		{
+			scenarioDescription: "Targets sustaining every iteration should stop at the max, without reaching a limit",
+			search:              LimitSearch{Start: 10, Max: 50, Precision: 1},
+			run:                 sustainingUpTo(100, 0),
+			expectedLimit:       50,
+			expectedConnections: []int{10, 20, 40, 50},
+		},
+		{
+			scenarioDescription: "Interrupted iterations should stop the search",
+			search:              LimitSearch{Start: 10, Max: 1000, Precision: 1},
+			run: func(connections int) LimitIteration {
+				return LimitIteration{Connections: connections, Established: connections,
+					Sustainable: connections < 20, Interrupted: connections >= 20}
+			},
+			expectedLimit:       10,
+			expectedConnections: []int{10, 20},
+		},
+	}
+
+	for _, test := range searchScenariosChecks {
+		result := SearchLimit(test.search, test.run)
+		connections := make([]int, len(result.Iterations))
+		for i, iteration := range result.Iterations {
+			connections[i] = iteration.Connections
+		}
+		if result.Limit != test.expectedLimit || result.Reached != test.expectedReached ||
+			!equalInts(connections, test.expectedConnections) {
+			t.Error(test.scenarioDescription+", and the limit is:", result.Limit, result.Reached, "after", connections)
+		}
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
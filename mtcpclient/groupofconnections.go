@@ -3,6 +3,7 @@ package mtcpclient
 import (
 	"fmt"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/dachad/tcpgoon/tcpclient"
@@ -11,23 +12,48 @@ import (
 // GroupOfConnections aggregates all the running connections plus some general metrics
 type GroupOfConnections struct {
 	connections []tcpclient.Connection
-	metrics     gcMetrics
+	// events keeps the status changes each connection went through, indexed as connections
+	events  [][]ConnectionEvent
+	metrics *gcMetrics
+	// lock is shared by all the copies of the group, as they also share connections and metrics
+	lock *sync.RWMutex
+}
+
+// ConnectionEvent is a status change of a connection, and when it happened
+type ConnectionEvent struct {
+	Status tcpclient.ConnectionStatus
+	At     time.Time
 }
 
 type gcMetrics struct {
-	maxConcurrentEstablished int
+	maxConcurrentEstablished           int
+	maxConcurrentEstablishedPerAddress map[string]int
 }
 
 func newGroupOfConnections(numberConnections int) *GroupOfConnections {
 	gc := new(GroupOfConnections)
 	gc.connections = make([]tcpclient.Connection, numberConnections)
-	gc.metrics = gcMetrics{
-		maxConcurrentEstablished: 0,
+	gc.events = make([][]ConnectionEvent, numberConnections)
+	gc.metrics = &gcMetrics{
+		maxConcurrentEstablished:           0,
+		maxConcurrentEstablishedPerAddress: make(map[string]int),
 	}
+	gc.lock = new(sync.RWMutex)
 	return gc
 }
 
+// readLock protects reads of the group against the status updates being collected, returning
+// the function to release it. Groups built by filtering others are not updated, so have no lock
+func (gc GroupOfConnections) readLock() (unlock func()) {
+	if gc.lock == nil {
+		return func() {}
+	}
+	gc.lock.RLock()
+	return gc.lock.RUnlock
+}
+
 func (gc GroupOfConnections) String() string {
+	defer gc.readLock()()
 	var nDialing, nEstablished, nClosed, nNotInitiated, nError, nTotal int = 0, 0, 0, 0, 0, 0
 	for _, item := range gc.connections {
 		switch item.GetConnectionStatus() {
@@ -49,6 +75,7 @@ func (gc GroupOfConnections) String() string {
 }
 
 func (gc GroupOfConnections) containsAConnectionWithStatus(fn tcpclient.ConnectionFunc) bool {
+	defer gc.readLock()()
 	for _, connection := range gc.connections {
 		if fn(connection) {
 			return true
@@ -93,6 +120,7 @@ func (gc GroupOfConnections) pingStyleReport(typeOfReport int) (output string) {
 }
 
 func (gc GroupOfConnections) getConnectionsThatWentWell(itWentWell bool) (connectionsThatWent GroupOfConnections) {
+	defer gc.readLock()()
 	for _, connection := range gc.connections {
 		if tcpclient.WentOk(connection) == itWentWell {
 			connectionsThatWent.connections = append(connectionsThatWent.connections, connection)
@@ -102,6 +130,7 @@ func (gc GroupOfConnections) getConnectionsThatWentWell(itWentWell bool) (connec
 }
 
 func (gc GroupOfConnections) getConnectionsThatAreOk() (connectionsThatAreOk GroupOfConnections) {
+	defer gc.readLock()()
 	for _, connection := range gc.connections {
 		if tcpclient.IsOk(connection) {
 			connectionsThatAreOk.connections = append(connectionsThatAreOk.connections, connection)
@@ -110,6 +139,47 @@ func (gc GroupOfConnections) getConnectionsThatAreOk() (connectionsThatAreOk Gro
 	return connectionsThatAreOk
 }
 
+func (gc GroupOfConnections) getConnectionsWithStatus(status tcpclient.ConnectionStatus) (connectionsWithStatus GroupOfConnections) {
+	defer gc.readLock()()
+	for _, connection := range gc.connections {
+		if connection.GetConnectionStatus() == status {
+			connectionsWithStatus.connections = append(connectionsWithStatus.connections, connection)
+		}
+	}
+	return connectionsWithStatus
+}
+
+// getConnectionsByAddress splits the initiated connections by the address they were dialing
+func (gc GroupOfConnections) getConnectionsByAddress() map[string]GroupOfConnections {
+	defer gc.readLock()()
+	connectionsByAddress := make(map[string]GroupOfConnections)
+	for _, connection := range gc.connections {
+		if connection.GetAddress() == "" {
+			continue
+		}
+		group := connectionsByAddress[connection.GetAddress()]
+		group.connections = append(group.connections, connection)
+		connectionsByAddress[connection.GetAddress()] = group
+	}
+	return connectionsByAddress
+}
+
+// getConnectionsByBackend splits the initiated connections by the backend that served them. The ones
+// which backend could not be identified are grouped under the empty backend
+func (gc GroupOfConnections) getConnectionsByBackend() map[string]GroupOfConnections {
+	defer gc.readLock()()
+	connectionsByBackend := make(map[string]GroupOfConnections)
+	for _, connection := range gc.connections {
+		if connection.GetConnectionStatus() == tcpclient.ConnectionNotInitiated {
+			continue
+		}
+		group := connectionsByBackend[connection.GetBackend()]
+		group.connections = append(group.connections, connection)
+		connectionsByBackend[connection.GetBackend()] = group
+	}
+	return connectionsByBackend
+}
+
 func (mr *metricsCollectionStats) String() string {
 	return mr.min.Truncate(time.Microsecond).String() + "/" +
 		mr.avg.Truncate(time.Microsecond).String() + "/" +
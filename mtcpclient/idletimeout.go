@@ -0,0 +1,125 @@
+package mtcpclient
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dachad/tcpgoon/debugging"
+	"github.com/dachad/tcpgoon/tcpclient"
+)
+
+// IdleTimeoutSchedules returns the idle times each of the connections goes through, growing by growth from first
+// up to max. Connection i starts growth^(i/connections) later than the first one, so together they check idle
+// times growth^(1/connections) apart, rather than growth apart
+func IdleTimeoutSchedules(connections int, first, max time.Duration, growth float64) [][]time.Duration {
+	schedules := make([][]time.Duration, connections)
+	for i := range schedules {
+		for k := 0; ; k++ {
+			idleTime := time.Duration(float64(first) * math.Pow(growth, float64(k)+float64(i)/float64(connections)))
+			if idleTime > max {
+				break
+			}
+			schedules[i] = append(schedules[i], idleTime.Round(time.Millisecond))
+		}
+	}
+	return schedules
+}
+
+// DiscoverIdleTimeout keeps a connection idle following each of the schedules, all of them in parallel and
+// opened delay ms apart, and estimates the idle timeout they are subject to out of how they went
+func DiscoverIdleTimeout(host string, port int, opts tcpclient.ConnectOptions, schedules [][]time.Duration,
+	delay int, closeRequest <-chan bool) IdleTimeoutEstimate {
+	results := make([]tcpclient.IdleResult, len(schedules))
+	var wg sync.WaitGroup
+	for i, schedule := range schedules {
+		wg.Add(1)
+		go func(i int, schedule []time.Duration) {
+			defer wg.Done()
+			results[i] = tcpclient.TCPIdleConnect(host, port, opts, schedule, closeRequest)
+			fmt.Fprintln(debugging.DebugOut, "Idle connection #", i, "finished:", results[i])
+		}(i, schedule)
+		time.Sleep(time.Duration(delay) * time.Millisecond)
+	}
+	wg.Wait()
+	return NewIdleTimeoutEstimate(results)
+}
+
+// IdleTimeoutEstimate bounds the idle timeout connections are subject to, out of how the connections kept
+// idle went
+type IdleTimeoutEstimate struct {
+	// Lower is the longest idle time a connection survived
+	Lower time.Duration
+	// Upper is the shortest idle time after which a connection stopped working, 0 if none did
+	Upper   time.Duration
+	Results []tcpclient.IdleResult
+}
+
+// NewIdleTimeoutEstimate bounds the idle timeout out of the results of the connections kept idle
+func NewIdleTimeoutEstimate(results []tcpclient.IdleResult) IdleTimeoutEstimate {
+	estimate := IdleTimeoutEstimate{Results: results}
+	for _, result := range results {
+		if result.Survived > estimate.Lower {
+			estimate.Lower = result.Survived
+		}
+		if result.Failed > 0 && (estimate.Upper == 0 || result.Failed < estimate.Upper) {
+			estimate.Upper = result.Failed
+		}
+	}
+	return estimate
+}
+
+// Found returns true if some connection stopped working after being idle, bounding the idle timeout
+func (e IdleTimeoutEstimate) Found() bool {
+	return e.Upper > 0
+}
+
+// Consistent returns false if a connection survived being idle longer than another one stopped working
+// after, which means something other than an idle timeout is dropping connections
+func (e IdleTimeoutEstimate) Consistent() bool {
+	return !e.Found() || e.Lower < e.Upper
+}
+
+// Estimate returns the middle of the bounds of the idle timeout, and how far from them it is
+func (e IdleTimeoutEstimate) Estimate() (estimate time.Duration, margin time.Duration) {
+	return (e.Lower + e.Upper) / 2, (e.Upper - e.Lower) / 2
+}
+
+// CliReport describes how each connection went and the idle timeout estimated out of them
+func (e IdleTimeoutEstimate) CliReport() string {
+	report := "--- tcpgoon idle timeout discovery ---\n"
+	for i, result := range e.Results {
+		report += "Connection " + strconv.Itoa(i) + ": " + describeIdleResult(result) + "\n"
+	}
+	switch {
+	case !e.Found():
+		report += "No idle timeout found: connections survived being idle up to " + e.Lower.String() + "\n"
+	case !e.Consistent():
+		report += "Inconsistent results: a connection survived being idle " + e.Lower.String() +
+			", but another one stopped working after " + e.Upper.String() +
+			". Connections may be dropped for other reasons than being idle\n"
+	default:
+		estimate, margin := e.Estimate()
+		report += "Estimated idle timeout: " + estimate.String() + " ± " + margin.String() +
+			" (survived " + e.Lower.String() + ", stopped working after " + e.Upper.String() + ")\n"
+	}
+	return report
+}
+
+func describeIdleResult(result tcpclient.IdleResult) string {
+	if result.Survived == 0 && result.Failed == 0 && result.Err != nil {
+		return "could not be kept idle: " + result.Err.Error()
+	}
+	description := "survived being idle " + result.Survived.String()
+	switch {
+	case result.ClosedWhileIdle:
+		description += ", closed by the other end after " + result.Failed.String() + " idle (" + result.Err.Error() + ")"
+	case result.Failed > 0:
+		description += ", found not working after " + result.Failed.String() + " idle (" + result.Err.Error() + ")"
+	case result.Err != nil:
+		description += ", and then failed: " + result.Err.Error()
+	}
+	return description
+}
@@ -0,0 +1,92 @@
+package mtcpclient
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dachad/tcpgoon/tcpclient"
+)
+
+func TestIdleTimeoutSchedules(t *testing.T) {
+	var schedulesScenariosChecks = []struct {
+		scenarioDescription string
+		connections         int
+		first               time.Duration
+		max                 time.Duration
+		growth              float64
+		expectedSchedules   [][]time.Duration
+	}{
+		{
+			scenarioDescription: "A single connection should go through idle times growing up to the max",
+			connections:         1,
+			first:               time.Second,
+			max:                 10 * time.Second,
+			growth:              2,
+			expectedSchedules:   [][]time.Duration{{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}},
+		},
+		{
+			scenarioDescription: "Several connections should interleave their idle times",
+			connections:         2,
+			first:               time.Second,
+			max:                 5 * time.Second,
+			growth:              4,
+			expectedSchedules:   [][]time.Duration{{time.Second, 4 * time.Second}, {2 * time.Second}},
+		},
+	}
+
+	for _, test := range schedulesScenariosChecks {
+		schedules := IdleTimeoutSchedules(test.connections, test.first, test.max, test.growth)
+		if !reflect.DeepEqual(schedules, test.expectedSchedules) {
+			t.Error(test.scenarioDescription+", and they are:", schedules)
+		}
+	}
+}
+
+func TestIdleTimeoutEstimate(t *testing.T) {
+	var estimateScenariosChecks = []struct {
+		scenarioDescription string
+		results             []tcpclient.IdleResult
+		expectedLower       time.Duration
+		expectedUpper       time.Duration
+		expectedConsistent  bool
+	}{
+		{
+			scenarioDescription: "Connections surviving all their idle times should not find an idle timeout",
+			results:             []tcpclient.IdleResult{{Survived: 4 * time.Second}, {Survived: 8 * time.Second}},
+			expectedLower:       8 * time.Second,
+			expectedConsistent:  true,
+		},
+		{
+			scenarioDescription: "Connections stopping working should bound the idle timeout between the longest survived and shortest failed",
+			results: []tcpclient.IdleResult{
+				{Survived: 4 * time.Second, Failed: 8 * time.Second, Err: errors.New("timeout")},
+				{Survived: 5 * time.Second, Failed: 6 * time.Second, ClosedWhileIdle: true, Err: io.EOF},
+				{Err: errors.New("connection refused")},
+			},
+			expectedLower:      5 * time.Second,
+			expectedUpper:      6 * time.Second,
+			expectedConsistent: true,
+		},
+		{
+			scenarioDescription: "Connections surviving longer than others failed after should be inconsistent",
+			results: []tcpclient.IdleResult{
+				{Survived: 8 * time.Second},
+				{Survived: 2 * time.Second, Failed: 4 * time.Second, Err: errors.New("timeout")},
+			},
+			expectedLower:      8 * time.Second,
+			expectedUpper:      4 * time.Second,
+			expectedConsistent: false,
+		},
+	}
+
+	for _, test := range estimateScenariosChecks {
+		estimate := NewIdleTimeoutEstimate(test.results)
+		if estimate.Lower != test.expectedLower || estimate.Upper != test.expectedUpper ||
+			estimate.Consistent() != test.expectedConsistent {
+			t.Error(test.scenarioDescription+", and it is:", estimate.Lower, estimate.Upper, estimate.Consistent())
+		}
+	}
+}
@@ -3,7 +3,10 @@ package mtcpclient
 import (
 	"fmt"
 	"math"
+	"sort"
 	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/dachad/tcpgoon/tcpclient"
@@ -11,14 +14,28 @@ import (
 
 func collectConnectionsStatus(connectionsStatusRegistry *GroupOfConnections, statusChannel <-chan tcpclient.Connection) {
 	concurrentEstablished := 0
+	concurrentEstablishedPerAddress := make(map[string]int)
 	for {
 		newConnectionStatusReported := <-statusChannel
+		connectionsStatusRegistry.lock.Lock()
 		concurrentEstablished = updateConcurrentEstablished(concurrentEstablished, newConnectionStatusReported, connectionsStatusRegistry)
+		updateConcurrentEstablishedPerAddress(concurrentEstablishedPerAddress, newConnectionStatusReported, connectionsStatusRegistry)
+		previousStatus := connectionsStatusRegistry.connections[newConnectionStatusReported.ID].GetConnectionStatus()
 		connectionsStatusRegistry.connections[newConnectionStatusReported.ID] = newConnectionStatusReported
+		// connections repeating probe requests report again their status after each of them
+		if events := connectionsStatusRegistry.events[newConnectionStatusReported.ID]; len(events) == 0 ||
+			previousStatus != newConnectionStatusReported.GetConnectionStatus() {
+			connectionsStatusRegistry.events[newConnectionStatusReported.ID] = append(events,
+				ConnectionEvent{newConnectionStatusReported.GetConnectionStatus(), time.Now()})
+		}
+		connectionsStatusRegistry.lock.Unlock()
 	}
 }
 
 func updateConcurrentEstablished(concurrentEstablished int, newConnectionStatusReported tcpclient.Connection, connectionsStatusRegistry *GroupOfConnections) int {
+	if tcpclient.IsOk(connectionsStatusRegistry.connections[newConnectionStatusReported.ID]) == tcpclient.IsOk(newConnectionStatusReported) {
+		return concurrentEstablished
+	}
 	if tcpclient.IsOk(newConnectionStatusReported) {
 		concurrentEstablished++
 		connectionsStatusRegistry.metrics.maxConcurrentEstablished = int(math.Max(float64(concurrentEstablished),
@@ -29,6 +46,21 @@ func updateConcurrentEstablished(concurrentEstablished int, newConnectionStatusR
 	return concurrentEstablished
 }
 
+func updateConcurrentEstablishedPerAddress(concurrentEstablished map[string]int, newConnectionStatusReported tcpclient.Connection, connectionsStatusRegistry *GroupOfConnections) {
+	address := newConnectionStatusReported.GetAddress()
+	if tcpclient.IsOk(connectionsStatusRegistry.connections[newConnectionStatusReported.ID]) == tcpclient.IsOk(newConnectionStatusReported) {
+		return
+	}
+	if tcpclient.IsOk(newConnectionStatusReported) {
+		concurrentEstablished[address]++
+		if concurrentEstablished[address] > connectionsStatusRegistry.metrics.maxConcurrentEstablishedPerAddress[address] {
+			connectionsStatusRegistry.metrics.maxConcurrentEstablishedPerAddress[address] = concurrentEstablished[address]
+		}
+	} else if previous := connectionsStatusRegistry.connections[newConnectionStatusReported.ID]; tcpclient.IsOk(previous) {
+		concurrentEstablished[previous.GetAddress()]--
+	}
+}
+
 // ReportConnectionsStatus keeps printing on screen the summary of connections states
 func ReportConnectionsStatus(gc GroupOfConnections, intervalBetweenUpdates int) {
 	for {
@@ -59,26 +91,350 @@ type FinalMetricsReport struct {
 	establishedCons          int
 	maxConcurrentCons        int
 	establishedConsOnClosure int
+	closedByPeerCons         int
+	notInitiatedCons         int
+	failedConsByReason       map[tcpclient.FailureReason]int
 	allConnections           GroupOfConnections
 	connectionsOK            GroupOfConnections
 	connectionsError         GroupOfConnections
+	// ImbalanceThreshold is how much the share of connections of a backend can deviate, relative to an even
+	// spread, before the backends are reported as imbalanced. It starts as DefaultImbalanceThreshold
+	ImbalanceThreshold float64
 }
 
 func (f *FinalMetricsReport) EstablishedCons() int          { return f.establishedCons }
 func (f *FinalMetricsReport) MaxConcurrentCons() int        { return f.maxConcurrentCons }
 func (f *FinalMetricsReport) EstablishedConsOnClosure() int { return f.establishedConsOnClosure }
+func (f *FinalMetricsReport) ClosedByPeerCons() int         { return f.closedByPeerCons }
+func (f *FinalMetricsReport) NotInitiatedCons() int         { return f.notInitiatedCons }
+
+// FailedConsByReason returns how many connections failed for each of the tcpclient.FailureReasons
+func (f *FinalMetricsReport) FailedConsByReason() map[tcpclient.FailureReason]int {
+	return f.failedConsByReason
+}
 
 func NewFinalMetricsReport(gc GroupOfConnections) *FinalMetricsReport {
+	unlock := gc.readLock()
+	maxConcurrentCons := gc.metrics.maxConcurrentEstablished
+	unlock()
+	return newFinalMetricsReport(gc, maxConcurrentCons)
+}
+
+func newFinalMetricsReport(gc GroupOfConnections, maxConcurrentCons int) *FinalMetricsReport {
+	failedConsByReason := make(map[tcpclient.FailureReason]int, len(tcpclient.FailureReasons))
+	for _, reason := range tcpclient.FailureReasons {
+		failedConsByReason[reason] = 0
+	}
+	for _, connection := range gc.getConnectionsWithStatus(tcpclient.ConnectionError).connections {
+		reason := connection.GetFailureReason()
+		if reason == tcpclient.FailureNone {
+			reason = tcpclient.FailureOther
+		}
+		failedConsByReason[reason]++
+	}
+
 	return &FinalMetricsReport{
 		establishedCons:          len(gc.getConnectionsThatWentWell(true).connections),
-		maxConcurrentCons:        gc.metrics.maxConcurrentEstablished,
+		maxConcurrentCons:        maxConcurrentCons,
 		establishedConsOnClosure: len(gc.getConnectionsThatAreOk().connections),
+		closedByPeerCons:         len(gc.getConnectionsWithStatus(tcpclient.ConnectionClosed).connections),
+		notInitiatedCons:         len(gc.getConnectionsWithStatus(tcpclient.ConnectionNotInitiated).connections),
+		failedConsByReason:       failedConsByReason,
+		ImbalanceThreshold:       DefaultImbalanceThreshold,
 		allConnections:           gc,
 		connectionsOK:            gc.getConnectionsThatWentWell(true),
 		connectionsError:         gc.getConnectionsThatWentWell(false),
 	}
 }
 
+// ConnectionsByStatus returns how many connections ended up in each status
+func (fmr *FinalMetricsReport) ConnectionsByStatus() map[tcpclient.ConnectionStatus]int {
+	defer fmr.allConnections.readLock()()
+	connectionsByStatus := make(map[tcpclient.ConnectionStatus]int)
+	for _, connection := range fmr.allConnections.connections {
+		connectionsByStatus[connection.GetConnectionStatus()]++
+	}
+	return connectionsByStatus
+}
+
+// SuccessfulLatencies returns how long each of the successful connections took to get established
+func (fmr *FinalMetricsReport) SuccessfulLatencies() []time.Duration {
+	latencies := make([]time.Duration, 0, len(fmr.connectionsOK.connections))
+	for _, connection := range fmr.connectionsOK.connections {
+		latencies = append(latencies, connection.GetTCPProcessingDuration())
+	}
+	return latencies
+}
+
+// ConnectionDetail describes a connection of the execution, and the status changes it went through
+type ConnectionDetail struct {
+	tcpclient.Connection
+	Events []ConnectionEvent
+}
+
+// ConnectionDetails returns the description of every connection, ordered by ID
+func (fmr *FinalMetricsReport) ConnectionDetails() []ConnectionDetail {
+	defer fmr.allConnections.readLock()()
+	details := make([]ConnectionDetail, len(fmr.allConnections.connections))
+	for i, connection := range fmr.allConnections.connections {
+		details[i].Connection = connection
+		details[i].Connection.ID = i
+		if i < len(fmr.allConnections.events) {
+			details[i].Events = append([]ConnectionEvent{}, fmr.allConnections.events[i]...)
+		}
+	}
+	return details
+}
+
+// AddressReports splits the report by the address each connection was dialing, so targets resolving
+// to several IPs can be compared
+func (fmr *FinalMetricsReport) AddressReports() map[string]*FinalMetricsReport {
+	reports := make(map[string]*FinalMetricsReport)
+	for address, gc := range fmr.allConnections.getConnectionsByAddress() {
+		maxConcurrentCons := 0
+		if fmr.allConnections.metrics != nil {
+			unlock := fmr.allConnections.readLock()
+			maxConcurrentCons = fmr.allConnections.metrics.maxConcurrentEstablishedPerAddress[address]
+			unlock()
+		}
+		reports[address] = newFinalMetricsReport(gc, maxConcurrentCons)
+	}
+	return reports
+}
+
+// BackendReports splits the report by the backend that served each connection, so the way a load
+// balancer spreads connections can be analysed. Connections which backend could not be identified are
+// reported under the empty backend
+func (fmr *FinalMetricsReport) BackendReports() map[string]*FinalMetricsReport {
+	reports := make(map[string]*FinalMetricsReport)
+	for backend, gc := range fmr.allConnections.getConnectionsByBackend() {
+		reports[backend] = newFinalMetricsReport(gc, 0)
+	}
+	return reports
+}
+
+// DefaultImbalanceThreshold is the ImbalanceThreshold reports start with
+var DefaultImbalanceThreshold = 0.2
+
+// unidentifiedBackend names the connections which backend could not be identified in the reports
+const unidentifiedBackend = "(unidentified)"
+
+// ImbalancedBackends returns the share of the identified connections of each backend which share deviates,
+// relative to an even spread, more than ImbalanceThreshold
+func (fmr *FinalMetricsReport) ImbalancedBackends() map[string]float64 {
+	imbalanced := make(map[string]float64)
+	reports := fmr.BackendReports()
+	delete(reports, "")
+	if len(reports) < 2 {
+		return imbalanced
+	}
+	identifiedConnections := 0
+	for _, report := range reports {
+		identifiedConnections += len(report.allConnections.connections)
+	}
+	evenShare := 1 / float64(len(reports))
+	for backend, report := range reports {
+		share := float64(len(report.allConnections.connections)) / float64(identifiedConnections)
+		if math.Abs(share-evenShare)/evenShare > fmr.ImbalanceThreshold {
+			imbalanced[backend] = share
+		}
+	}
+	return imbalanced
+}
+
+// backendDistributionReport describes how connections were spread across the backends as a table, the
+// backends with more connections first, flagging the imbalanced ones
+func (fmr *FinalMetricsReport) backendDistributionReport() (output string) {
+	reports := fmr.BackendReports()
+	if _, unidentified := reports[""]; len(reports) == 0 || (len(reports) == 1 && unidentified) {
+		return ""
+	}
+	backends := make([]string, 0, len(reports))
+	for backend := range reports {
+		backends = append(backends, backend)
+	}
+	sort.Slice(backends, func(i, j int) bool {
+		if (backends[i] == "") != (backends[j] == "") {
+			return backends[j] == ""
+		}
+		ci, cj := len(reports[backends[i]].allConnections.connections), len(reports[backends[j]].allConnections.connections)
+		if ci != cj {
+			return ci > cj
+		}
+		return backends[i] < backends[j]
+	})
+
+	var table strings.Builder
+	writer := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "Backend\tConnections\tShare\tErrors\tError rate\tAvg request time")
+	initiatedConnections := len(fmr.allConnections.connections) - fmr.notInitiatedCons
+	for _, backend := range backends {
+		report := reports[backend]
+		connections := len(report.allConnections.connections)
+		failed := len(report.connectionsError.connections)
+		// backends are told apart by the protocol probes, so they are compared by the time their requests took
+		avgRequestTime := "-"
+		if stats := report.ProbeStats(); stats != nil {
+			avgRequestTime = stats.Request.Avg().Truncate(time.Microsecond).String()
+		}
+		if backend == "" {
+			backend = unidentifiedBackend
+		}
+		fmt.Fprintf(writer, "%s\t%d\t%.1f%%\t%d\t%.1f%%\t%s\n", backend, connections,
+			float64(connections)/float64(initiatedConnections)*100, failed, float64(failed)/float64(connections)*100,
+			avgRequestTime)
+	}
+	writer.Flush()
+	output += "Backend distribution:\n"
+	for _, line := range strings.Split(strings.TrimSuffix(table.String(), "\n"), "\n") {
+		output += "  " + line + "\n"
+	}
+
+	if imbalanced := fmr.ImbalancedBackends(); len(imbalanced) > 0 {
+		output += "Backends imbalanced beyond " + strconv.FormatFloat(fmr.ImbalanceThreshold*100, 'f', -1, 64) +
+			"% of an even spread:"
+		for _, backend := range backends {
+			if share, ok := imbalanced[backend]; ok {
+				output += " " + backend + " " + strconv.FormatFloat(share*100, 'f', 1, 64) + "%"
+			}
+		}
+		output += "\n"
+	}
+	return output
+}
+
+// ProbeStats aggregates the application protocol probes of the connections that completed them
+type ProbeStats struct {
+	Protocol string
+	Phases   map[tcpclient.ProbePhase]*metricsCollectionStats
+	// FirstByte is nil when the protocol does not tell when responses start arriving
+	FirstByte *metricsCollectionStats
+	// Request covers from the request start to its response being validated
+	Request *metricsCollectionStats
+	// Requests counts all the requests that got a valid response, including the repeated ones, and
+	// the average and slowest time they took
+	Requests        int
+	RequestsAvg     time.Duration
+	RequestsSlowest time.Duration
+	// SettingsExchange is nil when the protocol does not run over HTTP/2
+	SettingsExchange *metricsCollectionStats
+}
+
+// ProbeStats returns the stats of the application protocol probes, or nil if connections were not probed
+func (fmr *FinalMetricsReport) ProbeStats() *ProbeStats {
+	var stats ProbeStats
+	phases := make(map[tcpclient.ProbePhase][]time.Duration)
+	var firstBytes, requests, settingsExchanges []time.Duration
+	var requestsTotal time.Duration
+	for _, connection := range fmr.connectionsOK.connections {
+		outcome := connection.GetProbeOutcome()
+		stats.Requests += outcome.Requests()
+		total, slowest := outcome.RequestsDuration()
+		requestsTotal += total
+		if slowest > stats.RequestsSlowest {
+			stats.RequestsSlowest = slowest
+		}
+		if !outcome.Probed() {
+			continue
+		}
+		stats.Protocol = outcome.Protocol
+		for _, phase := range tcpclient.ProbePhases {
+			duration, _ := outcome.PhaseDuration(phase)
+			phases[phase] = append(phases[phase], duration)
+		}
+		if firstByte, ok := outcome.TimeToFirstByte(); ok {
+			firstBytes = append(firstBytes, firstByte)
+		}
+		requests = append(requests, outcome.RequestDuration())
+		if settingsExchange := outcome.HTTP2().SettingsExchange; settingsExchange > 0 {
+			settingsExchanges = append(settingsExchanges, settingsExchange)
+		}
+	}
+	if stats.Protocol == "" {
+		return nil
+	}
+	stats.Phases = make(map[tcpclient.ProbePhase]*metricsCollectionStats, len(phases))
+	for phase, durations := range phases {
+		stats.Phases[phase] = calculateDurationsReport(durations)
+	}
+	if len(firstBytes) > 0 {
+		stats.FirstByte = calculateDurationsReport(firstBytes)
+	}
+	stats.Request = calculateDurationsReport(requests)
+	if len(settingsExchanges) > 0 {
+		stats.SettingsExchange = calculateDurationsReport(settingsExchanges)
+	}
+	if stats.Requests > 0 {
+		stats.RequestsAvg = requestsTotal / time.Duration(stats.Requests)
+	}
+	return &stats
+}
+
+// ProbeFailuresByPhase returns how many connections failed at each phase of their probe
+func (fmr *FinalMetricsReport) ProbeFailuresByPhase() map[tcpclient.ProbePhase]int {
+	failures := make(map[tcpclient.ProbePhase]int)
+	for _, connection := range fmr.connectionsError.connections {
+		if phase, failed := connection.GetProbeOutcome().FailedPhase(); failed {
+			failures[phase]++
+		}
+	}
+	return failures
+}
+
+// HTTP2Errors returns how many streams the servers reset, and how many connections got a GOAWAY
+func (fmr *FinalMetricsReport) HTTP2Errors() (streamErrors int, goAways int) {
+	for _, connection := range fmr.allConnections.connections {
+		outcome := connection.GetProbeOutcome().HTTP2()
+		streamErrors += outcome.StreamErrors
+		if outcome.GoAway {
+			goAways++
+		}
+	}
+	return streamErrors, goAways
+}
+
+// BannerCount is how many connections got the same banner
+type BannerCount struct {
+	Banner      string
+	Connections int
+}
+
+// Banners returns the distinct banners the servers introduced themselves with, the most seen first
+func (fmr *FinalMetricsReport) Banners() []BannerCount {
+	counts := make(map[string]int)
+	for _, connection := range fmr.allConnections.connections {
+		if banner := connection.GetProbeOutcome().Banner(); banner != "" {
+			counts[banner]++
+		}
+	}
+	banners := make([]BannerCount, 0, len(counts))
+	for banner, connections := range counts {
+		banners = append(banners, BannerCount{banner, connections})
+	}
+	sort.Slice(banners, func(i, j int) bool {
+		if banners[i].Connections != banners[j].Connections {
+			return banners[i].Connections > banners[j].Connections
+		}
+		return banners[i].Banner < banners[j].Banner
+	})
+	return banners
+}
+
+// TLSHandshakeReport returns the stats of the TLS handshakes of the connections that went well, or nil if
+// none of them negotiated TLS
+func (fmr *FinalMetricsReport) TLSHandshakeReport() *metricsCollectionStats {
+	var handshakes []time.Duration
+	for _, connection := range fmr.connectionsOK.connections {
+		if handshake, ok := connection.GetTLSHandshakeDuration(); ok {
+			handshakes = append(handshakes, handshake)
+		}
+	}
+	if len(handshakes) == 0 {
+		return nil
+	}
+	return calculateDurationsReport(handshakes)
+}
+
 func (fmr *FinalMetricsReport) SuccessfulConnectionReport() *metricsCollectionStats {
 	return fmr.connectionsOK.calculateMetricsReport()
 }
@@ -101,9 +457,70 @@ func (fmr *FinalMetricsReport) CliReport() (output string) {
 	if fmr.allConnections.atLeastOneConnectionOK() {
 		output += fmr.connectionsOK.pingStyleReport(successfulExecution)
 	}
+	if handshakes := fmr.TLSHandshakeReport(); handshakes != nil {
+		output += "TLS handshake stats for " + strconv.Itoa(handshakes.NumberOfConnections()) +
+			" connections min/avg/max/dev = " + handshakes.String()
+	}
+	if stats := fmr.ProbeStats(); stats != nil {
+		output += stats.cliReport()
+	}
+	if banners := fmr.Banners(); len(banners) > 0 {
+		output += "Banners seen:\n"
+		for _, banner := range banners {
+			output += "  " + strconv.Itoa(banner.Connections) + " " + strconv.Quote(banner.Banner) + "\n"
+		}
+	}
+	output += fmr.backendDistributionReport()
 	if fmr.allConnections.AtLeastOneConnectionInError() {
 		output += fmr.connectionsError.pingStyleReport(failedExecution)
+		output += "Failed connections by reason:"
+		for _, reason := range tcpclient.FailureReasons {
+			if fmr.failedConsByReason[reason] > 0 {
+				output += " " + string(reason) + " " + strconv.Itoa(fmr.failedConsByReason[reason])
+			}
+		}
+		output += "\n"
+		if failures := fmr.ProbeFailuresByPhase(); len(failures) > 0 {
+			output += "Failed probes by phase:"
+			for _, phase := range tcpclient.ProbePhases {
+				if failures[phase] > 0 {
+					output += " " + phase.String() + " " + strconv.Itoa(failures[phase])
+				}
+			}
+			output += "\n"
+		}
+	}
+	if streamErrors, goAways := fmr.HTTP2Errors(); streamErrors > 0 || goAways > 0 {
+		output += "HTTP/2 streams reset by the server: " + strconv.Itoa(streamErrors) +
+			", connections that got a GOAWAY: " + strconv.Itoa(goAways) + "\n"
+	}
+	if fmr.closedByPeerCons > 0 {
+		output += "Connections closed by the other end: " + strconv.Itoa(fmr.closedByPeerCons) + "\n"
 	}
 
 	return output
 }
+
+func (s *ProbeStats) cliReport() (output string) {
+	statsLine := func(name string, mr *metricsCollectionStats) string {
+		return "Protocol " + s.Protocol + " " + name + " stats for " + strconv.Itoa(mr.NumberOfConnections()) +
+			" probed connections min/avg/max/dev = " + mr.String()
+	}
+	for _, phase := range tcpclient.ProbePhases {
+		output += statsLine(phase.String(), s.Phases[phase])
+	}
+	if s.FirstByte != nil {
+		output += statsLine("time to first byte", s.FirstByte)
+	}
+	output += statsLine("request total time", s.Request)
+	if s.SettingsExchange != nil {
+		output += statsLine("SETTINGS exchange", s.SettingsExchange)
+	}
+	if s.Requests > s.Request.NumberOfConnections() {
+		output += "Protocol " + s.Protocol + " time of all the " + strconv.Itoa(s.Requests) +
+			" requests with a valid response, including repeated ones, avg/max = " +
+			s.RequestsAvg.Truncate(time.Microsecond).String() + "/" +
+			s.RequestsSlowest.Truncate(time.Microsecond).String() + "\n"
+	}
+	return output
+}
@@ -2,6 +2,9 @@ package mtcpclient
 
 import (
 	"testing"
+	"time"
+
+	"github.com/dachad/tcpgoon/tcpclient"
 )
 
 func TestFinalMetricsReport(t *testing.T) {
@@ -36,7 +39,79 @@ func TestFinalMetricsReport(t *testing.T) {
 				"Max concurrent established connections: 1\n" +
 				"Number of established connections on closure: 1\n" +
 				"Response time stats for 1 successful connections min/avg/max/dev = 500ms/500ms/500ms/0s\n" +
-				"Time to error stats for 2 failed connections min/avg/max/dev = 1s/2s/3s/1s\n",
+				"Time to error stats for 2 failed connections min/avg/max/dev = 1s/2s/3s/1s\n" +
+				"Failed connections by reason: other 2\n",
+		},
+		{
+			scenarioDescription:        "Probed connections should report the stats of each phase, and the phase failed probes failed at",
+			groupOfConnectionsToReport: newSampleProbedConnections(),
+			expectedReport: "--- tcpgoon execution statistics ---\n" +
+				"Total established connections: 2\n" +
+				"Max concurrent established connections: 2\n" +
+				"Number of established connections on closure: 2\n" +
+				"Response time stats for 2 successful connections min/avg/max/dev = 500ms/500ms/500ms/0s\n" +
+				"Protocol echo handshake stats for 2 probed connections min/avg/max/dev = 0s/0s/0s/0s\n" +
+				"Protocol echo request stats for 2 probed connections min/avg/max/dev = 1ms/2ms/3ms/1ms\n" +
+				"Protocol echo validate stats for 2 probed connections min/avg/max/dev = 2ms/3ms/4ms/1ms\n" +
+				"Protocol echo request total time stats for 2 probed connections min/avg/max/dev = 3ms/5ms/7ms/2ms\n" +
+				"Time to error stats for 1 failed connections min/avg/max/dev = 1s/1s/1s/0s\n" +
+				"Failed connections by reason: other 1\n" +
+				"Failed probes by phase: validate 1\n",
+		},
+		{
+			scenarioDescription:        "Connections probed with the banner protocol should report the distinct banners, the most seen first",
+			groupOfConnectionsToReport: newSampleBannerConnections(),
+			expectedReport: "--- tcpgoon execution statistics ---\n" +
+				"Total established connections: 3\n" +
+				"Max concurrent established connections: 3\n" +
+				"Number of established connections on closure: 3\n" +
+				"Response time stats for 3 successful connections min/avg/max/dev = 500ms/500ms/500ms/0s\n" +
+				"Protocol banner handshake stats for 3 probed connections min/avg/max/dev = 1ms/1ms/1ms/0s\n" +
+				"Protocol banner request stats for 3 probed connections min/avg/max/dev = 0s/0s/0s/0s\n" +
+				"Protocol banner validate stats for 3 probed connections min/avg/max/dev = 0s/0s/0s/0s\n" +
+				"Protocol banner request total time stats for 3 probed connections min/avg/max/dev = 0s/0s/0s/0s\n" +
+				"Banners seen:\n" +
+				"  2 \"SSH-2.0-OpenSSH_9.6\"\n" +
+				"  1 \"SSH-2.0-OpenSSH_8.9\"\n",
+		},
+		{
+			scenarioDescription:        "Connections probed over HTTP/2 should report the SETTINGS exchange, and the streams reset and GOAWAYs got",
+			groupOfConnectionsToReport: newSampleHTTP2Connections(),
+			expectedReport: "--- tcpgoon execution statistics ---\n" +
+				"Total established connections: 1\n" +
+				"Max concurrent established connections: 1\n" +
+				"Number of established connections on closure: 1\n" +
+				"Response time stats for 1 successful connections min/avg/max/dev = 500ms/500ms/500ms/0s\n" +
+				"Protocol h2 handshake stats for 1 probed connections min/avg/max/dev = 2ms/2ms/2ms/0s\n" +
+				"Protocol h2 request stats for 1 probed connections min/avg/max/dev = 0s/0s/0s/0s\n" +
+				"Protocol h2 validate stats for 1 probed connections min/avg/max/dev = 0s/0s/0s/0s\n" +
+				"Protocol h2 request total time stats for 1 probed connections min/avg/max/dev = 0s/0s/0s/0s\n" +
+				"Protocol h2 SETTINGS exchange stats for 1 probed connections min/avg/max/dev = 2ms/2ms/2ms/0s\n" +
+				"Time to error stats for 2 failed connections min/avg/max/dev = 1s/1s/1s/0s\n" +
+				"Failed connections by reason: other 2\n" +
+				"Failed probes by phase: handshake 1 validate 1\n" +
+				"HTTP/2 streams reset by the server: 1, connections that got a GOAWAY: 1\n",
+		},
+		{
+			scenarioDescription:        "Connections which backend was identified should report their distribution, flagging imbalanced backends",
+			groupOfConnectionsToReport: newSampleBalancedConnections(),
+			expectedReport: "--- tcpgoon execution statistics ---\n" +
+				"Total established connections: 5\n" +
+				"Max concurrent established connections: 5\n" +
+				"Number of established connections on closure: 5\n" +
+				"Response time stats for 5 successful connections min/avg/max/dev = 100ms/300ms/500ms/141.421ms\n" +
+				"Protocol http handshake stats for 5 probed connections min/avg/max/dev = 0s/0s/0s/0s\n" +
+				"Protocol http request stats for 5 probed connections min/avg/max/dev = 10ms/30ms/50ms/14.142ms\n" +
+				"Protocol http validate stats for 5 probed connections min/avg/max/dev = 0s/0s/0s/0s\n" +
+				"Protocol http request total time stats for 5 probed connections min/avg/max/dev = 10ms/30ms/50ms/14.142ms\n" +
+				"Backend distribution:\n" +
+				"  Backend         Connections  Share  Errors  Error rate  Avg request time\n" +
+				"  web-1           4            57.1%  1       25.0%       20ms\n" +
+				"  web-2           2            28.6%  0       0.0%        45ms\n" +
+				"  (unidentified)  1            14.3%  1       100.0%      -\n" +
+				"Backends imbalanced beyond 20% of an even spread: web-1 66.7% web-2 33.3%\n" +
+				"Time to error stats for 2 failed connections min/avg/max/dev = 1s/1s/1s/0s\n" +
+				"Failed connections by reason: other 2\n",
 		},
 	}
 
@@ -47,3 +122,34 @@ func TestFinalMetricsReport(t *testing.T) {
 		}
 	}
 }
+
+func TestImbalancedBackendsThreshold(t *testing.T) {
+	report := NewFinalMetricsReport(*newSampleBalancedConnections())
+	if imbalanced := report.ImbalancedBackends(); len(imbalanced) != 2 {
+		t.Error("Backends deviating beyond the default threshold should be flagged, and they are:", imbalanced)
+	}
+	// web-1 gets 66.7% of the identified connections, deviating 33.3% from an even spread
+	report.ImbalanceThreshold = 0.5
+	if imbalanced := report.ImbalancedBackends(); len(imbalanced) != 0 {
+		t.Error("Backends deviating within the threshold of the report should not be flagged, and they are:", imbalanced)
+	}
+}
+
+func TestConnectionDetails(t *testing.T) {
+	connStatusCh, gc := StartBackgroundReporting(2, 0)
+	connStatusCh <- tcpclient.NewConnection(0, tcpclient.ConnectionDialing, 0)
+	connStatusCh <- tcpclient.NewConnection(0, tcpclient.ConnectionEstablished, 500*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+
+	details := NewFinalMetricsReport(*gc).ConnectionDetails()
+	if len(details) != 2 {
+		t.Fatal("Every connection should be described, and they are:", details)
+	}
+	if events := details[0].Events; len(events) != 2 || events[0].Status != tcpclient.ConnectionDialing ||
+		events[1].Status != tcpclient.ConnectionEstablished {
+		t.Error("Connections should keep the status changes they went through, and they are:", events)
+	}
+	if details[1].ID != 1 || details[1].GetConnectionStatus() != tcpclient.ConnectionNotInitiated || len(details[1].Events) != 0 {
+		t.Error("Connections not initiated should be described as such, and they are:", details[1])
+	}
+}
@@ -15,6 +15,21 @@ import (
 // tcpclient.Connection descriptions on each status update of the connections.
 // closureCh will interrupt execution when closed
 func MultiTCPConnect(numberConnections int, delay int, host string, port int,
+	connStatusCh chan<- tcpclient.Connection, closureCh <-chan bool) {
+	MultiTCPConnectWithOptions(numberConnections, delay, host, port, tcpclient.DefaultConnectOptions(),
+		connStatusCh, closureCh)
+}
+
+// MultiTCPConnectWithOptions behaves as MultiTCPConnect, with each connection being opened
+// and exercised as described by opts
+func MultiTCPConnectWithOptions(numberConnections int, delay int, host string, port int, opts tcpclient.ConnectOptions,
+	connStatusCh chan<- tcpclient.Connection, closureCh <-chan bool) {
+	MultiTCPConnectToHosts(numberConnections, delay, []string{host}, port, opts, connStatusCh, closureCh)
+}
+
+// MultiTCPConnectToHosts behaves as MultiTCPConnectWithOptions, spreading the connections across
+// hosts in a round robin fashion (i.e. all the addresses a hostname resolves to)
+func MultiTCPConnectToHosts(numberConnections int, delay int, hosts []string, port int, opts tcpclient.ConnectOptions,
 	connStatusCh chan<- tcpclient.Connection, closureCh <-chan bool) {
 	var wg sync.WaitGroup
 	for runner := 0; runner < numberConnections; runner++ {
@@ -25,7 +40,7 @@ func MultiTCPConnect(numberConnections int, delay int, host string, port int,
 		default:
 			fmt.Fprintln(debugging.DebugOut, "Initiating gothread # "+strconv.Itoa(runner)+" to start a new connection")
 			wg.Add(1)
-			go tcpclient.TCPConnect(runner, host, port, &wg, connStatusCh, closureCh)
+			go tcpclient.TCPConnectWithOptions(runner, hosts[runner%len(hosts)], port, opts, &wg, connStatusCh, closureCh)
 			fmt.Fprintln(debugging.DebugOut, "Gothread # "+strconv.Itoa(runner)+
 				" initated. Remaining: "+strconv.Itoa(numberConnections-runner))
 			time.Sleep(time.Duration(delay) * time.Millisecond)
@@ -27,3 +27,60 @@ func newSampleMultipleConnections() *GroupOfConnections {
 	gc.metrics.maxConcurrentEstablished = 1
 	return gc
 }
+
+func newSampleProbedConnections() *GroupOfConnections {
+	var gc *GroupOfConnections
+	gc = newGroupOfConnections(0)
+	gc.connections = append(gc.connections, tcpclient.NewProbedConnection(0, tcpclient.ConnectionEstablished,
+		time.Duration(500)*time.Millisecond, tcpclient.NewProbeOutcome("echo", 0, time.Millisecond, 2*time.Millisecond)))
+	gc.connections = append(gc.connections, tcpclient.NewProbedConnection(1, tcpclient.ConnectionEstablished,
+		time.Duration(500)*time.Millisecond, tcpclient.NewProbeOutcome("echo", 0, 3*time.Millisecond, 4*time.Millisecond)))
+	gc.connections = append(gc.connections, tcpclient.NewProbedConnection(2, tcpclient.ConnectionError,
+		time.Duration(1)*time.Second, tcpclient.NewProbeOutcome("echo", 0, time.Millisecond)))
+	gc.metrics.maxConcurrentEstablished = 2
+	return gc
+}
+
+func newSampleBannerConnections() *GroupOfConnections {
+	var gc *GroupOfConnections
+	gc = newGroupOfConnections(0)
+	for id, banner := range []string{"SSH-2.0-OpenSSH_8.9", "SSH-2.0-OpenSSH_9.6", "SSH-2.0-OpenSSH_9.6"} {
+		gc.connections = append(gc.connections, tcpclient.NewProbedConnection(id, tcpclient.ConnectionEstablished,
+			time.Duration(500)*time.Millisecond,
+			tcpclient.NewProbeOutcome("banner", time.Millisecond, 0, 0).WithBanner(banner)))
+	}
+	gc.metrics.maxConcurrentEstablished = 3
+	return gc
+}
+
+func newSampleHTTP2Connections() *GroupOfConnections {
+	var gc *GroupOfConnections
+	gc = newGroupOfConnections(0)
+	gc.connections = append(gc.connections, tcpclient.NewProbedConnection(0, tcpclient.ConnectionEstablished,
+		time.Duration(500)*time.Millisecond, tcpclient.NewProbeOutcome("h2", 2*time.Millisecond, 0, 0).
+			WithHTTP2(tcpclient.HTTP2Outcome{SettingsExchange: 2 * time.Millisecond})))
+	gc.connections = append(gc.connections, tcpclient.NewProbedConnection(1, tcpclient.ConnectionError,
+		time.Duration(1)*time.Second, tcpclient.NewProbeOutcome("h2", 4*time.Millisecond, 0).
+			WithHTTP2(tcpclient.HTTP2Outcome{SettingsExchange: 4 * time.Millisecond, StreamErrors: 1})))
+	gc.connections = append(gc.connections, tcpclient.NewProbedConnection(2, tcpclient.ConnectionError,
+		time.Duration(1)*time.Second, tcpclient.NewProbeOutcome("h2").
+			WithHTTP2(tcpclient.HTTP2Outcome{GoAway: true})))
+	gc.metrics.maxConcurrentEstablished = 1
+	return gc
+}
+
+func newSampleBalancedConnections() *GroupOfConnections {
+	var gc *GroupOfConnections
+	gc = newGroupOfConnections(0)
+	for id, backend := range []string{"web-1", "web-1", "web-1", "web-2", "web-2", "web-1", ""} {
+		status, procTime := tcpclient.ConnectionEstablished, time.Duration(100*(id+1))*time.Millisecond
+		if id >= 5 {
+			status, procTime = tcpclient.ConnectionError, time.Second
+		}
+		requestTime := time.Duration(10*(id+1)) * time.Millisecond
+		gc.connections = append(gc.connections, tcpclient.NewProbedConnection(id, status, procTime,
+			tcpclient.NewProbeOutcome("http", 0, requestTime, 0).WithBackend(backend)))
+	}
+	gc.metrics.maxConcurrentEstablished = 5
+	return gc
+}
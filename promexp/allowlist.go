@@ -0,0 +1,97 @@
+package promexp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/dachad/tcpgoon/cmdutil"
+)
+
+// TargetAllowlist restricts the targets requests are allowed to probe. Empty lists do not restrict anything
+type TargetAllowlist struct {
+	Networks []*net.IPNet
+	// Hostnames are glob patterns (i.e. *.example.com), matched against the requested host
+	Hostnames []string
+	Ports     map[int]bool
+}
+
+// targetNotAllowedError is returned when a request asks for a target out of the allowlist
+type targetNotAllowedError struct {
+	target string
+}
+
+func (e targetNotAllowedError) Error() string {
+	return fmt.Sprintf("Target '%s' is not allowed by the exporter configuration", e.target)
+}
+
+// NewTargetAllowlist builds an allowlist out of hosts, each of them a CIDR, an IP or a hostname glob,
+// and a ports specification, as a list and/or ranges (i.e. 8000-8010,9000)
+func NewTargetAllowlist(hosts []string, portsSpec string) (*TargetAllowlist, error) {
+	allowlist := &TargetAllowlist{}
+	for _, host := range hosts {
+		if network, err := cmdutil.ParseIPOrNetwork(host); err == nil {
+			allowlist.Networks = append(allowlist.Networks, network)
+			continue
+		}
+		if _, err := path.Match(host, ""); err != nil || strings.Contains(host, "/") {
+			return nil, errors.New(host + " is not a valid network, IP address or hostname pattern")
+		}
+		allowlist.Hostnames = append(allowlist.Hostnames, strings.ToLower(host))
+	}
+
+	if portsSpec != "" {
+		ports, err := cmdutil.ParsePorts(portsSpec)
+		if err != nil {
+			return nil, err
+		}
+		allowlist.Ports = make(map[int]bool, len(ports))
+		for _, port := range ports {
+			allowlist.Ports[port] = true
+		}
+	}
+	return allowlist, nil
+}
+
+// allows checks the target against the allowlist. Hosts matching a hostname pattern are allowed,
+// otherwise all the addresses they resolved to, which are the ones to probe, should belong to the allowed
+// networks. The host is not resolved again, so it can not resolve to different addresses once checked
+func (a *TargetAllowlist) allows(host string, addrs []net.IP, port int) error {
+	if a == nil {
+		return nil
+	}
+	target := net.JoinHostPort(host, fmt.Sprint(port))
+	if len(a.Ports) > 0 && !a.Ports[port] {
+		return targetNotAllowedError{target}
+	}
+	if len(a.Networks) == 0 && len(a.Hostnames) == 0 {
+		return nil
+	}
+
+	for _, pattern := range a.Hostnames {
+		if matched, _ := path.Match(pattern, strings.ToLower(host)); matched {
+			return nil
+		}
+	}
+
+	if len(addrs) == 0 {
+		return targetNotAllowedError{target}
+	}
+	for _, addr := range addrs {
+		if !a.allowsIP(addr) {
+			return targetNotAllowedError{target}
+		}
+	}
+	return nil
+}
+
+func (a *TargetAllowlist) allowsIP(ip net.IP) bool {
+	for _, network := range a.Networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package promexp
+
+import (
+	"net"
+	"testing"
+)
+
+func TestTargetAllowlist(t *testing.T) {
+	allowlist, err := NewTargetAllowlist([]string{"10.0.0.0/8", "192.168.1.1", "*.example.com"}, "80,8000-8010")
+	if err != nil {
+		t.Fatal("Valid allowlist could not be built", err)
+	}
+
+	var allowlistScenariosChecks = []struct {
+		scenarioDescription string
+		allowlist           *TargetAllowlist
+		host                string
+		addrs               []net.IP
+		port                int
+		expectedAllowed     bool
+	}{
+		{
+			scenarioDescription: "IPs within an allowed network should be allowed",
+			allowlist:           allowlist,
+			host:                "10.1.2.3",
+			port:                8005,
+			expectedAllowed:     true,
+		},
+		{
+			scenarioDescription: "Allowed single IPs should be allowed",
+			allowlist:           allowlist,
+			host:                "192.168.1.1",
+			port:                80,
+			expectedAllowed:     true,
+		},
+		{
+			scenarioDescription: "IPs out of the allowed networks should be rejected",
+			allowlist:           allowlist,
+			host:                "192.168.1.2",
+			port:                80,
+			expectedAllowed:     false,
+		},
+		{
+			scenarioDescription: "Hostnames matching an allowed pattern should be allowed, regardless of their case",
+			allowlist:           allowlist,
+			host:                "Web.Example.com",
+			port:                80,
+			expectedAllowed:     true,
+		},
+		{
+			scenarioDescription: "Hostnames resolving out of the allowed networks should be rejected",
+			allowlist:           allowlist,
+			host:                "localhost",
+			port:                80,
+			expectedAllowed:     false,
+		},
+		{
+			scenarioDescription: "Hostnames should be checked by the addresses they resolved to, without resolving them again",
+			allowlist:           allowlist,
+			host:                "not-resolvable.invalid",
+			addrs:               []net.IP{net.ParseIP("10.1.2.3")},
+			port:                80,
+			expectedAllowed:     true,
+		},
+		{
+			scenarioDescription: "Hostnames should be rejected if any of the addresses they resolved to is out of the allowed networks",
+			allowlist:           allowlist,
+			host:                "not-resolvable.invalid",
+			addrs:               []net.IP{net.ParseIP("10.1.2.3"), net.ParseIP("127.0.0.1")},
+			port:                80,
+			expectedAllowed:     false,
+		},
+		{
+			scenarioDescription: "Ports out of the allowed ranges should be rejected",
+			allowlist:           allowlist,
+			host:                "10.1.2.3",
+			port:                22,
+			expectedAllowed:     false,
+		},
+		{
+			scenarioDescription: "Nil allowlists should allow any target",
+			allowlist:           nil,
+			host:                "192.168.1.2",
+			port:                22,
+			expectedAllowed:     true,
+		},
+	}
+
+	for _, test := range allowlistScenariosChecks {
+		// scenarios not setting the addresses the host resolved to look them up
+		addrs := test.addrs
+		if addrs == nil {
+			addrs, _ = lookupTarget(test.host)
+		}
+		err := test.allowlist.allows(test.host, addrs, test.port)
+		if (err == nil) != test.expectedAllowed {
+			t.Error(test.scenarioDescription+", and the error is:", err)
+		}
+		if _, ok := err.(targetNotAllowedError); err != nil && !ok {
+			t.Error(test.scenarioDescription+", and the error is not a targetNotAllowedError:", err)
+		}
+	}
+}
+
+func TestNewTargetAllowlistErrors(t *testing.T) {
+	if _, err := NewTargetAllowlist([]string{"10.0.0.0/33"}, ""); err == nil {
+		t.Error("Invalid networks should be rejected")
+	}
+	if _, err := NewTargetAllowlist([]string{"[a-"}, ""); err == nil {
+		t.Error("Invalid hostname patterns should be rejected")
+	}
+	if _, err := NewTargetAllowlist(nil, "80-"); err == nil {
+		t.Error("Invalid port ranges should be rejected")
+	}
+}
@@ -0,0 +1,51 @@
+package promexp
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Credentials describe what callers have to present to use the exporter. Empty values disable each method,
+// and callers presenting any of the enabled ones are allowed
+type Credentials struct {
+	User     string
+	Password string
+	Token    string
+}
+
+func (c Credentials) enabled() bool {
+	return c.User != "" || c.Token != ""
+}
+
+func (c Credentials) authorized(r *http.Request) bool {
+	if user, password, ok := r.BasicAuth(); ok && c.User != "" {
+		return secureCompare(user, c.User) && secureCompare(password, c.Password)
+	}
+	if header := r.Header.Get("Authorization"); c.Token != "" && strings.HasPrefix(header, "Bearer ") {
+		return secureCompare(strings.TrimPrefix(header, "Bearer "), c.Token)
+	}
+	return false
+}
+
+func secureCompare(given string, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// requireCredentials only lets requests presenting valid credentials reach next
+func requireCredentials(credentials Credentials, next http.Handler) http.Handler {
+	if !credentials.enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !credentials.authorized(r) {
+			RequestRejections.WithLabelValues("unauthorized").Inc()
+			if credentials.User != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="tcpgoon"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,64 @@
+package promexp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireCredentials(t *testing.T) {
+	credentials := Credentials{User: "prometheus", Password: "secret", Token: "t0ken"}
+	handler := requireCredentials(credentials, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var authScenariosChecks = []struct {
+		scenarioDescription string
+		user                string
+		password            string
+		authorization       string
+		expectedStatus      int
+	}{
+		{
+			scenarioDescription: "Requests without credentials should be rejected",
+			expectedStatus:      http.StatusUnauthorized,
+		},
+		{
+			scenarioDescription: "Requests with valid basic auth credentials should be served",
+			user:                "prometheus",
+			password:            "secret",
+			expectedStatus:      http.StatusOK,
+		},
+		{
+			scenarioDescription: "Requests with a wrong password should be rejected",
+			user:                "prometheus",
+			password:            "guess",
+			expectedStatus:      http.StatusUnauthorized,
+		},
+		{
+			scenarioDescription: "Requests with a valid bearer token should be served",
+			authorization:       "Bearer t0ken",
+			expectedStatus:      http.StatusOK,
+		},
+		{
+			scenarioDescription: "Requests with a wrong bearer token should be rejected",
+			authorization:       "Bearer guess",
+			expectedStatus:      http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range authScenariosChecks {
+		r := httptest.NewRequest("GET", "/tcpgoon", nil)
+		if test.user != "" {
+			r.SetBasicAuth(test.user, test.password)
+		}
+		if test.authorization != "" {
+			r.Header.Set("Authorization", test.authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.expectedStatus {
+			t.Error(test.scenarioDescription+", and the status is:", w.Code)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package promexp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dachad/tcpgoon/debugging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// staleAfterIntervals is how many target intervals can pass without a new result before it's considered stale
+const staleAfterIntervals = 2
+
+var (
+	backgroundLabels       = append(append([]string{}, labels...), "target", "module")
+	backgroundProbeMetrics = newProbeDescs(backgroundLabels)
+	targetLabels           = []string{"target", "module"}
+	probeTimestamp         = prometheus.NewDesc(
+		prefix+"probe_last_run_timestamp_seconds",
+		"When the last background probe of the target completed, as a unix timestamp",
+		targetLabels, nil)
+	probeStale = prometheus.NewDesc(
+		prefix+"probe_stale",
+		"Whether the cached results of the target are missing or older than two probing intervals",
+		targetLabels, nil)
+)
+
+// BackgroundProber probes the configured targets on their own schedule, keeping their latest results
+// so they can be served on /metrics, decoupling the load generated from how often we get scraped
+type BackgroundProber struct {
+	ctx     context.Context
+	limiter *probeLimiter
+	lock    sync.RWMutex
+	targets map[string]*backgroundTarget
+}
+
+// backgroundTarget keeps the state of a target across configuration reloads
+type backgroundTarget struct {
+	definition Target
+	module     Module
+	cancel     context.CancelFunc
+	// probing serializes the probes of the target, even when it gets reconfigured while probing
+	probing sync.Mutex
+	last    *cachedProbe
+}
+
+type cachedProbe struct {
+	result      probeResult
+	labelValues []string
+	timestamp   time.Time
+}
+
+// NewBackgroundProber creates a prober whose targets are probed until ctx is done
+func NewBackgroundProber(ctx context.Context) *BackgroundProber {
+	return &BackgroundProber{
+		ctx:     ctx,
+		targets: make(map[string]*backgroundTarget),
+	}
+}
+
+// Sync starts probing the targets of the configuration, restarting the ones whose definition
+// changed and stopping the ones that are not there anymore
+func (p *BackgroundProber) Sync(config *SafeConfig) {
+	targets, modules := config.Targets()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	configured := make(map[string]bool, len(targets))
+	for _, definition := range targets {
+		configured[definition.Name] = true
+		t, ok := p.targets[definition.Name]
+		if !ok {
+			t = &backgroundTarget{}
+			p.targets[definition.Name] = t
+		}
+		// module changes are picked up by the next probe, without restarting the schedule
+		t.module = modules[definition.Module]
+		if ok && t.definition == definition {
+			continue
+		}
+		if t.cancel != nil {
+			t.cancel()
+		}
+		ctx, cancel := context.WithCancel(p.ctx)
+		// results of the previous definition do not describe the new one
+		t.last = nil
+		t.definition = definition
+		t.cancel = cancel
+		go p.run(ctx, t, definition)
+	}
+
+	for name, t := range p.targets {
+		if !configured[name] {
+			t.cancel()
+			delete(p.targets, name)
+		}
+	}
+}
+
+func (p *BackgroundProber) run(ctx context.Context, t *backgroundTarget, definition Target) {
+	fmt.Fprintln(debugging.DebugOut, "msg", "Probing target in the background", "target", definition.Name,
+		"interval", definition.Interval)
+	ticker := time.NewTicker(definition.Interval)
+	defer ticker.Stop()
+	for {
+		p.probe(ctx, t, definition)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *BackgroundProber) probe(ctx context.Context, t *backgroundTarget, definition Target) {
+	t.probing.Lock()
+	defer t.probing.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+
+	p.lock.RLock()
+	module := t.module
+	p.lock.RUnlock()
+
+	host, port, _ := splitTarget(definition.Target)
+	var collector *Collector
+	addrs, err := lookupTarget(host)
+	if err == nil {
+		collector, err = NewModuleCollector(host, addrs, port, module)
+	}
+	if err != nil {
+		fmt.Fprintln(debugging.DebugOut, "msg", "Could not resolve target", "target", definition.Name, "err", err)
+		return
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, definition.Timeout)
+	defer cancel()
+	collector.ctx = probeCtx
+
+	// background probes share the budget of scrapes, and get skipped when the exporter is overloaded
+	if err := p.limiter.acquire(probeCtx, collector.numberConnections); err != nil {
+		fmt.Fprintln(debugging.DebugOut, "msg", "Skipping background probe", "target", definition.Name, "err", err)
+		return
+	}
+	defer p.limiter.release(collector.numberConnections)
+	result := collector.probe()
+	if ctx.Err() != nil {
+		// the target got reconfigured or removed while probing
+		return
+	}
+
+	p.lock.Lock()
+	t.last = &cachedProbe{
+		result:      result,
+		labelValues: append(collector.labelValues(), definition.Name, definition.Module),
+		timestamp:   time.Now(),
+	}
+	p.lock.Unlock()
+	fmt.Fprintln(debugging.DebugOut, "msg", "Finished background probe", "target", definition.Name,
+		"duration_seconds", result.duration.Seconds())
+}
+
+func (p *BackgroundProber) Describe(ch chan<- *prometheus.Desc) {
+	backgroundProbeMetrics.describe(ch)
+	ch <- probeTimestamp
+	ch <- probeStale
+}
+
+// Collect serves the cached results of the targets, timestamped with the moment their probe completed
+func (p *BackgroundProber) Collect(ch chan<- prometheus.Metric) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	now := time.Now()
+	for _, t := range p.targets {
+		stale := t.last == nil || now.Sub(t.last.timestamp) > staleAfterIntervals*t.definition.Interval
+		ch <- prometheus.MustNewConstMetric(probeStale, prometheus.GaugeValue, boolToFloat64(stale),
+			t.definition.Name, t.definition.Module)
+		if t.last == nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(probeTimestamp, prometheus.GaugeValue,
+			float64(t.last.timestamp.UnixNano())/1e9, t.definition.Name, t.definition.Module)
+		for _, metric := range backgroundProbeMetrics.metrics(t.last.result, t.last.labelValues) {
+			ch <- prometheus.NewMetricWithTimestamp(t.last.timestamp, metric)
+		}
+	}
+}
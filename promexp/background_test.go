@@ -0,0 +1,94 @@
+package promexp
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dachad/tcpgoon/tcpserver"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gatherGauges collects the gauges of the prober, indexed by metric name and the value of their target label
+func gatherGauges(t *testing.T, prober *BackgroundProber) map[string]map[string]*dto.Metric {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prober)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal("Metrics could not be gathered", err)
+	}
+	gauges := make(map[string]map[string]*dto.Metric)
+	for _, family := range families {
+		gauges[family.GetName()] = make(map[string]*dto.Metric)
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "target" {
+					gauges[family.GetName()][label.GetValue()] = metric
+				}
+			}
+		}
+	}
+	return gauges
+}
+
+func TestBackgroundProber(t *testing.T) {
+	const port = 55561
+	dispatcher := &tcpserver.Dispatcher{
+		Handlers: make(map[string]*tcpserver.Handler),
+		Lock:     sync.RWMutex{},
+	}
+	if err := dispatcher.Start(context.Background(), []int{port}); err != nil {
+		t.Fatal("Could not start the TCP server", err)
+	}
+	defer dispatcher.Shutdown(context.Background())
+
+	configFile := writeTempConfig(t, "modules:\n  small:\n    connections: 3\n    sleep: 1ms\n"+
+		"targets:\n- name: local\n  target: 127.0.0.1:55561\n  module: small\n  interval: 1h\n")
+	defer os.Remove(configFile)
+	config := &SafeConfig{}
+	if err := config.ReloadConfig(configFile); err != nil {
+		t.Fatal("Valid config could not be loaded", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prober := NewBackgroundProber(ctx)
+	prober.Sync(config)
+
+	var gauges map[string]map[string]*dto.Metric
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		gauges = gatherGauges(t, prober)
+		if gauges["tcpgoon_probe_stale"]["local"].GetGauge().GetValue() == 0 {
+			break
+		}
+	}
+
+	if stale := gauges["tcpgoon_probe_stale"]["local"].GetGauge().GetValue(); stale != 0 {
+		t.Fatal("Targets should not be stale after being probed, and staleness is:", stale)
+	}
+	established := gauges["tcpgoon_established_connections_count"]["local"]
+	if established.GetGauge().GetValue() != 3 {
+		t.Error("Cached results should be served, and the established connections are:", established)
+	}
+	if established.GetTimestampMs() == 0 {
+		t.Error("Cached results should carry the time they were probed at")
+	}
+
+	// a single probe should have been run, as the interval is way longer than the test
+	if accepted := dispatcher.Stats().AcceptedConnections; accepted != 3 {
+		t.Error("Targets should be probed on their own schedule, and the accepted connections are:", accepted)
+	}
+
+	emptyConfig := writeTempConfig(t, "modules:\n  small:\n    connections: 3\n")
+	defer os.Remove(emptyConfig)
+	if err := config.ReloadConfig(emptyConfig); err != nil {
+		t.Fatal("Valid config could not be loaded", err)
+	}
+	prober.Sync(config)
+	if gauges := gatherGauges(t, prober); len(gauges["tcpgoon_probe_stale"]) != 0 {
+		t.Error("Targets removed from the config should not be reported anymore")
+	}
+}
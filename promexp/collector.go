@@ -1,9 +1,14 @@
 package promexp
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dachad/tcpgoon/debugging"
 	"github.com/dachad/tcpgoon/mtcpclient"
@@ -14,90 +19,340 @@ import (
 const prefix = "tcpgoon_"
 
 var (
-	labels          = []string{"target_ip", "target_port", "sleep_msecs", "timeout_msecs"}
-	establishedCons = prometheus.NewDesc(
-		prefix+"established_connections_count",
-		"Number of totally established connections",
-		labels, nil)
-	maxConcurrentCons = prometheus.NewDesc(
-		prefix+"max_concurrent_connections_count",
-		"Max concurrent established connections",
-		labels, nil)
-	establishedConsOnClosure = prometheus.NewDesc(
-		prefix+"established_connections_on_closure_count",
-		"Number of established connections on closure",
-		labels, nil)
-	minResponseTimeSecs = prometheus.NewDesc(
-		prefix+"min_response_time_secs",
-		"Minimum wait for SYN-ACK",
-		labels, nil)
-	maxResponseTimeSecs = prometheus.NewDesc(
-		prefix+"max_response_time_secs",
-		"Maximum wait for SYN-ACK",
-		labels, nil)
-	avgResponseTimeSecs = prometheus.NewDesc(
-		prefix+"avg_response_time_secs",
-		"Average wait for SYN-ACK",
-		labels, nil)
-	devResponseTimeSecs = prometheus.NewDesc(
-		prefix+"stddev_response_time_secs",
-		"Standard deviation of wait for SYN-ACK",
-		labels, nil)
-	invConnections = prometheus.NewDesc(
-		prefix+"attempted_connection_count",
-		"Number of connections attempted to connect",
-		labels, nil)
+	labels       = []string{"target_ip", "target_port", "sleep_msecs", "timeout_msecs"}
+	probeMetrics = newProbeDescs(labels)
 )
 
+// probeDescs describes the metrics generated out of a probe execution
+type probeDescs struct {
+	establishedCons          *prometheus.Desc
+	maxConcurrentCons        *prometheus.Desc
+	establishedConsOnClosure *prometheus.Desc
+	minResponseTimeSecs      *prometheus.Desc
+	maxResponseTimeSecs      *prometheus.Desc
+	avgResponseTimeSecs      *prometheus.Desc
+	devResponseTimeSecs      *prometheus.Desc
+	invConnections           *prometheus.Desc
+	probeSuccess             *prometheus.Desc
+	probeDurationSecs        *prometheus.Desc
+	failedCons               *prometheus.Desc
+	closedByPeerCons         *prometheus.Desc
+	notInitiatedCons         *prometheus.Desc
+	ipEstablishedCons        *prometheus.Desc
+	ipFailedCons             *prometheus.Desc
+	ipAvgResponseTimeSecs    *prometheus.Desc
+	responseTimeSecs         *prometheus.Desc
+}
+
+func newProbeDescs(labels []string) probeDescs {
+	reasonLabels := append(append([]string{}, labels...), "reason")
+	ipLabels := append(append([]string{}, labels...), "resolved_ip")
+	return probeDescs{
+		establishedCons: prometheus.NewDesc(
+			prefix+"established_connections_count",
+			"Number of totally established connections",
+			labels, nil),
+		maxConcurrentCons: prometheus.NewDesc(
+			prefix+"max_concurrent_connections_count",
+			"Max concurrent established connections",
+			labels, nil),
+		establishedConsOnClosure: prometheus.NewDesc(
+			prefix+"established_connections_on_closure_count",
+			"Number of established connections on closure",
+			labels, nil),
+		minResponseTimeSecs: prometheus.NewDesc(
+			prefix+"min_response_time_secs",
+			"Minimum wait for SYN-ACK",
+			labels, nil),
+		maxResponseTimeSecs: prometheus.NewDesc(
+			prefix+"max_response_time_secs",
+			"Maximum wait for SYN-ACK",
+			labels, nil),
+		avgResponseTimeSecs: prometheus.NewDesc(
+			prefix+"avg_response_time_secs",
+			"Average wait for SYN-ACK",
+			labels, nil),
+		devResponseTimeSecs: prometheus.NewDesc(
+			prefix+"stddev_response_time_secs",
+			"Standard deviation of wait for SYN-ACK",
+			labels, nil),
+		invConnections: prometheus.NewDesc(
+			prefix+"attempted_connection_count",
+			"Number of connections attempted to connect",
+			labels, nil),
+		probeSuccess: prometheus.NewDesc(
+			prefix+"probe_success",
+			"Whether all the connections were established before the probe deadline",
+			labels, nil),
+		probeDurationSecs: prometheus.NewDesc(
+			prefix+"probe_duration_seconds",
+			"How long the probe took to complete, in seconds",
+			labels, nil),
+		failedCons: prometheus.NewDesc(
+			prefix+"failed_connections_count",
+			"Number of connections that failed, by reason",
+			reasonLabels, nil),
+		closedByPeerCons: prometheus.NewDesc(
+			prefix+"closed_by_peer_connections_count",
+			"Number of established connections closed by the other end",
+			labels, nil),
+		notInitiatedCons: prometheus.NewDesc(
+			prefix+"not_initiated_connections_count",
+			"Number of connections the probe did not get to initiate",
+			labels, nil),
+		ipEstablishedCons: prometheus.NewDesc(
+			prefix+"resolved_ip_established_connections_count",
+			"Number of totally established connections, per address the target resolves to",
+			ipLabels, nil),
+		ipFailedCons: prometheus.NewDesc(
+			prefix+"resolved_ip_failed_connections_count",
+			"Number of connections that failed, per address the target resolves to",
+			ipLabels, nil),
+		ipAvgResponseTimeSecs: prometheus.NewDesc(
+			prefix+"resolved_ip_avg_response_time_secs",
+			"Average wait for SYN-ACK, per address the target resolves to",
+			ipLabels, nil),
+		responseTimeSecs: prometheus.NewDesc(
+			prefix+"response_time_seconds",
+			"Distribution of the wait for SYN-ACK of the established connections",
+			labels, nil),
+	}
+}
+
+func (d probeDescs) describe(ch chan<- *prometheus.Desc) {
+	ch <- d.establishedCons
+	ch <- d.maxConcurrentCons
+	ch <- d.establishedConsOnClosure
+	ch <- d.minResponseTimeSecs
+	ch <- d.maxResponseTimeSecs
+	ch <- d.avgResponseTimeSecs
+	ch <- d.devResponseTimeSecs
+	ch <- d.invConnections
+	ch <- d.probeSuccess
+	ch <- d.probeDurationSecs
+	ch <- d.failedCons
+	ch <- d.closedByPeerCons
+	ch <- d.notInitiatedCons
+	ch <- d.ipEstablishedCons
+	ch <- d.ipFailedCons
+	ch <- d.ipAvgResponseTimeSecs
+	ch <- d.responseTimeSecs
+}
+
+// metrics generates the metrics of a probe execution
+func (d probeDescs) metrics(result probeResult, labelValues []string) []prometheus.Metric {
+	fmr := result.report
+	mr := fmr.SuccessfulConnectionReport()
+
+	metrics := []prometheus.Metric{
+		prometheus.MustNewConstMetric(d.establishedCons, prometheus.GaugeValue, float64(fmr.EstablishedCons()), labelValues...),
+		prometheus.MustNewConstMetric(d.maxConcurrentCons, prometheus.GaugeValue, float64(fmr.MaxConcurrentCons()), labelValues...),
+		prometheus.MustNewConstMetric(d.establishedConsOnClosure, prometheus.GaugeValue, float64(fmr.EstablishedConsOnClosure()), labelValues...),
+		prometheus.MustNewConstMetric(d.minResponseTimeSecs, prometheus.GaugeValue, mr.Min().Seconds(), labelValues...),
+		prometheus.MustNewConstMetric(d.maxResponseTimeSecs, prometheus.GaugeValue, mr.Max().Seconds(), labelValues...),
+		prometheus.MustNewConstMetric(d.avgResponseTimeSecs, prometheus.GaugeValue, mr.Avg().Seconds(), labelValues...),
+		prometheus.MustNewConstMetric(d.devResponseTimeSecs, prometheus.GaugeValue, mr.StdDev().Seconds(), labelValues...),
+		prometheus.MustNewConstMetric(d.invConnections, prometheus.GaugeValue, float64(result.attemptedCons), labelValues...),
+		prometheus.MustNewConstMetric(d.probeSuccess, prometheus.GaugeValue, boolToFloat64(result.success), labelValues...),
+		prometheus.MustNewConstMetric(d.probeDurationSecs, prometheus.GaugeValue, result.duration.Seconds(), labelValues...),
+		prometheus.MustNewConstMetric(d.closedByPeerCons, prometheus.GaugeValue, float64(fmr.ClosedByPeerCons()), labelValues...),
+		prometheus.MustNewConstMetric(d.notInitiatedCons, prometheus.GaugeValue, float64(fmr.NotInitiatedCons()), labelValues...),
+	}
+
+	metrics = append(metrics, d.responseTimeHistogram(result, labelValues))
+
+	for _, reason := range tcpclient.FailureReasons {
+		// a gauge, as every probe counts its own failures from scratch
+		metrics = append(metrics, prometheus.MustNewConstMetric(d.failedCons, prometheus.GaugeValue,
+			float64(fmr.FailedConsByReason()[reason]), append(append([]string{}, labelValues...), string(reason))...))
+	}
+
+	if result.resolvedIPs > 1 {
+		addressReports := fmr.AddressReports()
+		addresses := make([]string, 0, len(addressReports))
+		for address := range addressReports {
+			addresses = append(addresses, address)
+		}
+		sort.Strings(addresses)
+		for _, address := range addresses {
+			ipLabelValues := append(append([]string{}, labelValues...), address)
+			report := addressReports[address]
+			failed := 0
+			for _, count := range report.FailedConsByReason() {
+				failed += count
+			}
+			metrics = append(metrics,
+				prometheus.MustNewConstMetric(d.ipEstablishedCons, prometheus.GaugeValue, float64(report.EstablishedCons()), ipLabelValues...),
+				prometheus.MustNewConstMetric(d.ipFailedCons, prometheus.GaugeValue, float64(failed), ipLabelValues...),
+				prometheus.MustNewConstMetric(d.ipAvgResponseTimeSecs, prometheus.GaugeValue,
+					report.SuccessfulConnectionReport().Avg().Seconds(), ipLabelValues...),
+			)
+		}
+	}
+	return metrics
+}
+
+// responseTimeHistogram distributes the latencies of the successful connections, with the slowest connection
+// of each bucket as exemplar
+func (d probeDescs) responseTimeHistogram(result probeResult, labelValues []string) prometheus.Metric {
+	latencies := result.report.SuccessfulLatencies()
+	buckets := make(map[float64]uint64, len(latencyBuckets))
+	for i, count := range cumulativeLatencyCounts(latencies) {
+		buckets[latencyBuckets[i].Seconds()] = uint64(count)
+	}
+	sum := 0.0
+	for _, latency := range latencies {
+		sum += latency.Seconds()
+	}
+	histogram := prometheus.MustNewConstHistogram(d.responseTimeSecs, uint64(len(latencies)), sum, buckets, labelValues...)
+
+	exemplars := latencyExemplars(result.probeID, result.report.ConnectionDetails())
+	if len(exemplars) == 0 {
+		return histogram
+	}
+	return prometheus.MustNewMetricWithExemplars(histogram, exemplars...)
+}
+
+const statusUpdatesCollectionWait = 100 * time.Millisecond
+
 type Collector struct {
 	targetPort        int
 	targetIp          string
+	targetIps         []string
 	targetName        string
 	numberConnections int
 	delay             int
 	connDialTimeout   int
+	connectOptions    tcpclient.ConnectOptions
+	holdTime          time.Duration
+	// probeID identifies the next probe, so it can be announced before probing. Generated if not set
+	probeID string
+	// ctx bounds the probe execution; in-flight connections are cancelled when it's done
+	ctx context.Context
+}
+
+// lookupTarget resolves the addresses of a target host, failing if it does not resolve to any
+func lookupTarget(targetName string) ([]net.IP, error) {
+	addrs, err := net.LookupIP(targetName)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("Target " + targetName + " does not resolve to any address")
+	}
+	return addrs, nil
 }
 
-func NewCollector(targetName string, targetPort int, numberConnections int, delay int, connDialTimeout int) *Collector {
-	addrs, _ := net.LookupIP(targetName)
+// NewCollector creates a collector that probes the target, spreading the connections across the addresses
+// it resolved to (see lookupTarget). It fails if there is not any
+func NewCollector(targetName string, addrs []net.IP, targetPort int, numberConnections int, delay int,
+	connDialTimeout int) (*Collector, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("Target " + targetName + " does not resolve to any address")
+	}
+	targetIps := make([]string, len(addrs))
+	for i, addr := range addrs {
+		targetIps[i] = addr.String()
+	}
+	connectOptions := tcpclient.DefaultConnectOptions()
+	connectOptions.DialTimeout = time.Duration(connDialTimeout) * time.Millisecond
 	return &Collector{
 		targetPort:        targetPort,
-		targetIp:          addrs[0].String(),
+		targetIp:          targetIps[0],
+		targetIps:         targetIps,
 		targetName:        targetName,
 		numberConnections: numberConnections,
 		delay:             delay,
 		connDialTimeout:   connDialTimeout,
+		connectOptions:    connectOptions,
+	}, nil
+}
+
+// NewModuleCollector creates a collector that probes the target as described by a configuration module
+func NewModuleCollector(targetName string, addrs []net.IP, targetPort int, module Module) (*Collector, error) {
+	c, err := NewCollector(targetName, addrs, targetPort, module.Connections, int(module.Sleep/time.Millisecond),
+		int(module.DialTimeout/time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+	c.connectOptions = module.connectOptions()
+	if c.connectOptions.TLSConfig != nil && c.connectOptions.TLSConfig.ServerName == "" {
+		// connections are dialed against the resolved IP, so certificates should be verified against the name
+		c.connectOptions.TLSConfig = c.connectOptions.TLSConfig.Clone()
+		c.connectOptions.TLSConfig.ServerName = targetName
 	}
+	c.holdTime = module.HoldTime
+	return c, nil
 }
 
 func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- establishedCons
-	ch <- maxConcurrentCons
-	ch <- establishedConsOnClosure
-	ch <- minResponseTimeSecs
-	ch <- maxResponseTimeSecs
-	ch <- avgResponseTimeSecs
-	ch <- devResponseTimeSecs
-	ch <- invConnections
+	probeMetrics.describe(ch)
 }
 
-func (c *Collector) Collect(ch chan<- prometheus.Metric) {
-	tcpclient.DefaultDialTimeoutInMs = c.connDialTimeout
+// probeResult keeps what a probe execution produced, so metrics can be generated out of it
+type probeResult struct {
+	report        *mtcpclient.FinalMetricsReport
+	attemptedCons int
+	success       bool
+	duration      time.Duration
+	// resolvedIPs the connections were spread across
+	resolvedIPs int
+	// probeID its connection details can be retrieved with
+	probeID string
+}
+
+// probe opens the connections against the target, giving up when the collector context is done
+func (c *Collector) probe() probeResult {
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	probeID := c.probeID
+	if probeID == "" {
+		probeID = newProbeID()
+	}
+	c.probeID = ""
 
+	start := time.Now()
 	connStatusCh, connStatusTracker := mtcpclient.StartBackgroundReporting(c.numberConnections, 0)
-	closureCh := mtcpclient.StartBackgroundClosureTrigger(*connStatusTracker)
-	mtcpclient.MultiTCPConnect(c.numberConnections, c.delay, c.targetIp, c.targetPort, connStatusCh, closureCh)
+	closureCh := mtcpclient.StartBackgroundClosureTriggerWithContext(ctx, *connStatusTracker, c.holdTime)
+	mtcpclient.MultiTCPConnectToHosts(c.numberConnections, c.delay, c.targetIps, c.targetPort, c.connectOptions,
+		connStatusCh, closureCh)
+	duration := time.Since(start)
 	fmt.Fprintln(debugging.DebugOut, "Tests execution completed")
-	labelValues := []string{c.targetIp, strconv.Itoa(c.targetPort), strconv.Itoa(c.delay), strconv.Itoa(c.connDialTimeout)}
-	fmr := mtcpclient.NewFinalMetricsReport(*connStatusTracker)
-	mr := fmr.SuccessfulConnectionReport()
 
-	ch <- prometheus.MustNewConstMetric(establishedCons, prometheus.GaugeValue, float64(fmr.EstablishedCons()), labelValues...)
-	ch <- prometheus.MustNewConstMetric(maxConcurrentCons, prometheus.GaugeValue, float64(fmr.MaxConcurrentCons()), labelValues...)
-	ch <- prometheus.MustNewConstMetric(establishedConsOnClosure, prometheus.GaugeValue, float64(fmr.EstablishedConsOnClosure()), labelValues...)
-	ch <- prometheus.MustNewConstMetric(minResponseTimeSecs, prometheus.GaugeValue, mr.Min().Seconds(), labelValues...)
-	ch <- prometheus.MustNewConstMetric(maxResponseTimeSecs, prometheus.GaugeValue, mr.Max().Seconds(), labelValues...)
-	ch <- prometheus.MustNewConstMetric(avgResponseTimeSecs, prometheus.GaugeValue, mr.Avg().Seconds(), labelValues...)
-	ch <- prometheus.MustNewConstMetric(devResponseTimeSecs, prometheus.GaugeValue, mr.StdDev().Seconds(), labelValues...)
-	ch <- prometheus.MustNewConstMetric(invConnections, prometheus.GaugeValue, float64(c.numberConnections), labelValues...)
+	// same workaround the CLI uses to allow last status updates - messages in channels - to be collected properly
+	time.Sleep(statusUpdatesCollectionWait)
+
+	report := mtcpclient.NewFinalMetricsReport(*connStatusTracker)
+	lastProbes.store(strings.Join(append(c.labelValues(), c.targetName), "/"),
+		newProbeDetails(probeID, c, start, report.ConnectionDetails()))
+
+	return probeResult{
+		report:        report,
+		attemptedCons: c.numberConnections,
+		success: ctx.Err() == nil && !connStatusTracker.PendingConnections() &&
+			!connStatusTracker.AtLeastOneConnectionInError(),
+		duration:    duration,
+		resolvedIPs: len(c.targetIps),
+		probeID:     probeID,
+	}
+}
+
+func (c *Collector) labelValues() []string {
+	return []string{c.targetIp, strconv.Itoa(c.targetPort), strconv.Itoa(c.delay), strconv.Itoa(c.connDialTimeout)}
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, metric := range probeMetrics.metrics(c.probe(), c.labelValues()) {
+		ch <- metric
+	}
+}
+
+func boolToFloat64(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
 }
@@ -0,0 +1,120 @@
+package promexp
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dachad/tcpgoon/tcpserver"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollectorProbeDeadline(t *testing.T) {
+	const port = 55560
+	dispatcher := &tcpserver.Dispatcher{
+		Handlers: make(map[string]*tcpserver.Handler),
+		Lock:     sync.RWMutex{},
+	}
+	if err := dispatcher.Start(context.Background(), []int{port}); err != nil {
+		t.Fatal("Could not start the TCP server", err)
+	}
+	defer dispatcher.Shutdown(context.Background())
+
+	var probeScenariosChecks = []struct {
+		scenarioDescription string
+		connections         int
+		sleep               int
+		timeout             time.Duration
+		expectedSuccess     bool
+	}{
+		{
+			scenarioDescription: "Probes completing before the deadline should succeed",
+			connections:         3,
+			sleep:               1,
+			timeout:             5 * time.Second,
+			expectedSuccess:     true,
+		},
+		{
+			scenarioDescription: "Probes reaching the deadline should fail and report partial metrics",
+			connections:         50,
+			sleep:               50,
+			timeout:             300 * time.Millisecond,
+			expectedSuccess:     false,
+		},
+	}
+
+	for _, test := range probeScenariosChecks {
+		collector, _ := NewCollector("127.0.0.1", []net.IP{net.ParseIP("127.0.0.1")}, port, test.connections, test.sleep, 1000)
+		ctx, cancel := context.WithTimeout(context.Background(), test.timeout)
+		collector.ctx = ctx
+		result := collector.probe()
+		cancel()
+
+		if result.success != test.expectedSuccess {
+			t.Error(test.scenarioDescription+", and its success is:", result.success)
+		}
+		if result.duration > test.timeout+time.Second {
+			t.Error(test.scenarioDescription+", and it lasted:", result.duration)
+		}
+		if established := result.report.EstablishedCons(); established == 0 || established > test.connections {
+			t.Error(test.scenarioDescription+", and the established connections are:", established)
+		}
+	}
+}
+
+func TestCollectorFailureMetrics(t *testing.T) {
+	// nothing listens on this port, so every connection gets refused
+	const port = 55562
+	collector, _ := NewCollector("127.0.0.1", []net.IP{net.ParseIP("127.0.0.1")}, port, 4, 1, 1000)
+	// as if the target resolved to several addresses
+	collector.targetIps = []string{"127.0.0.1", "127.0.0.2"}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal("Metrics could not be gathered", err)
+	}
+
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			key := family.GetName()
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "reason" || label.GetName() == "resolved_ip" {
+					key += "/" + label.GetValue()
+				}
+			}
+			values[key] = metric.GetGauge().GetValue()
+		}
+	}
+
+	expectedValues := map[string]float64{
+		"tcpgoon_failed_connections_count/refused":                    4,
+		"tcpgoon_failed_connections_count/timeout":                    0,
+		"tcpgoon_not_initiated_connections_count":                     0,
+		"tcpgoon_closed_by_peer_connections_count":                    0,
+		"tcpgoon_resolved_ip_failed_connections_count/127.0.0.1":      2,
+		"tcpgoon_resolved_ip_failed_connections_count/127.0.0.2":      2,
+		"tcpgoon_resolved_ip_established_connections_count/127.0.0.2": 0,
+	}
+	for key, expected := range expectedValues {
+		if value, ok := values[key]; !ok || value != expected {
+			t.Error("Metric", key, "should be", expected, "and it is:", value, ok)
+		}
+	}
+}
+
+func TestNewCollectorUnresolvable(t *testing.T) {
+	if addrs, err := lookupTarget("not-resolvable.invalid"); err == nil || len(addrs) != 0 {
+		t.Error("Targets not resolving should not be looked up successfully, and they are:", addrs)
+	}
+	if collector, err := NewCollector("not-resolvable.invalid", nil, 80, 1, 1, 1000); err == nil || collector != nil {
+		t.Error("Collectors of targets not resolving should not be created, and it is:", collector)
+	}
+	if collector, err := NewModuleCollector("not-resolvable.invalid", nil, 80, Module{Connections: 1}); err == nil || collector != nil {
+		t.Error("Module collectors of targets not resolving should not be created, and it is:", collector)
+	}
+}
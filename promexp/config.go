@@ -0,0 +1,337 @@
+package promexp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dachad/tcpgoon/tcpclient"
+	"gopkg.in/yaml.v2"
+)
+
+// Config describes the exporter configuration file
+type Config struct {
+	Modules map[string]Module `yaml:"modules"`
+	Targets []Target          `yaml:"targets"`
+}
+
+// UnmarshalYAML validates the targets refer to configured modules, and that their names are unique
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Config
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	names := make(map[string]bool, len(c.Targets))
+	for _, target := range c.Targets {
+		if _, ok := c.Modules[target.Module]; !ok {
+			return fmt.Errorf("target %s refers to an unknown module: %s", target.Name, target.Module)
+		}
+		if names[target.Name] {
+			return fmt.Errorf("target %s is defined more than once", target.Name)
+		}
+		names[target.Name] = true
+	}
+	return nil
+}
+
+// Module describes a named kind of probe, blackbox_exporter style, that scrapes can refer to
+type Module struct {
+	Connections int           `yaml:"connections"`
+	Sleep       time.Duration `yaml:"sleep"`
+	DialTimeout time.Duration `yaml:"dial_timeout"`
+	HoldTime    time.Duration `yaml:"hold_time"`
+	TLS         bool          `yaml:"tls"`
+	TLSConfig   TLSConfig     `yaml:"tls_config"`
+	Payload     string        `yaml:"payload"`
+	Expect      string        `yaml:"expect"`
+	// Protocol connections are probed with, among tcpclient.ProtocolNames. h2 and grpc negotiate it via ALPN over TLS
+	Protocol string `yaml:"protocol"`
+	// HTTP describes the requests of the protocols running over HTTP
+	HTTP HTTPConfig `yaml:"http"`
+	// BannerMatch, when set, has to match the banners of the banner protocol
+	BannerMatch string `yaml:"banner_match"`
+	// GRPCService is the service the grpc protocol checks the health of. Empty checks the whole server
+	GRPCService string `yaml:"grpc_service"`
+
+	tlsConfig   *tls.Config
+	expect      *regexp.Regexp
+	httpProbe   tcpclient.HTTPProbeConfig
+	bannerMatch *regexp.Regexp
+}
+
+// HTTPConfig describes the requests the protocols running over HTTP issue, and how their responses are validated
+type HTTPConfig struct {
+	Method  string            `yaml:"method"`
+	Path    string            `yaml:"path"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+	// ValidStatusCodes accepted as a valid response. Any 2xx or 3xx is accepted when empty
+	ValidStatusCodes []int  `yaml:"valid_status_codes"`
+	BodyMatch        string `yaml:"body_match"`
+}
+
+// httpProtocols are the protocols running over HTTP, which the http settings of modules apply to
+var httpProtocols = map[string]bool{"http": true, "websocket": true, "h2": true, "grpc": true}
+
+// TLSConfig describes how probes should negotiate TLS with their targets
+type TLSConfig struct {
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	ServerName         string `yaml:"server_name"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+}
+
+// Target describes a target probed in the background, on its own schedule, instead of on each scrape
+type Target struct {
+	// Name identifies the target in the metrics. It defaults to the target itself
+	Name     string        `yaml:"name"`
+	Target   string        `yaml:"target"`
+	Module   string        `yaml:"module"`
+	Interval time.Duration `yaml:"interval"`
+	// Timeout bounds each probe. It defaults to the interval
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+var defaultTargetInterval = 60 * time.Second
+
+// UnmarshalYAML applies the target defaults and validates its settings
+func (t *Target) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Target
+	if err := unmarshal((*plain)(t)); err != nil {
+		return err
+	}
+
+	if _, _, err := splitTarget(t.Target); err != nil {
+		return fmt.Errorf("target %q should be formatted as host:port", t.Target)
+	}
+	if t.Module == "" {
+		return fmt.Errorf("target %s should refer to a module", t.Target)
+	}
+	if t.Name == "" {
+		t.Name = t.Target
+	}
+	if t.Interval == 0 {
+		t.Interval = defaultTargetInterval
+	}
+	if t.Timeout == 0 {
+		t.Timeout = t.Interval
+	}
+	if t.Interval < 0 || t.Timeout < 0 {
+		return errors.New("interval and timeout should be positive durations")
+	}
+	return nil
+}
+
+var defaultModule = Module{
+	Connections: 100,
+	Sleep:       10 * time.Millisecond,
+	DialTimeout: 5 * time.Second,
+}
+
+// UnmarshalYAML applies the module defaults and validates its settings
+func (m *Module) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*m = defaultModule
+	type plain Module
+	if err := unmarshal((*plain)(m)); err != nil {
+		return err
+	}
+
+	if m.Connections <= 0 {
+		return errors.New("connections should be a positive number")
+	}
+	if m.Sleep < 0 || m.DialTimeout <= 0 || m.HoldTime < 0 {
+		return errors.New("sleep, dial_timeout and hold_time should be positive durations")
+	}
+	if m.Expect != "" {
+		expect, err := regexp.Compile(m.Expect)
+		if err != nil {
+			return fmt.Errorf("expect is not a valid regular expression: %s", err)
+		}
+		m.expect = expect
+	}
+	if m.Protocol != "" {
+		if err := tcpclient.ValidateProtocol(m.Protocol); err != nil {
+			return err
+		}
+	}
+	if err := m.buildProbeSettings(); err != nil {
+		return err
+	}
+	if m.TLS {
+		tlsConfig, err := m.TLSConfig.build()
+		if err != nil {
+			return err
+		}
+		m.tlsConfig = tlsConfig
+	}
+	return nil
+}
+
+// buildProbeSettings validates the settings of the protocol probes, rejecting the ones the protocol of the
+// module would not use
+func (m *Module) buildProbeSettings() error {
+	if m.HTTP.configured() && !httpProtocols[m.Protocol] {
+		protocols := make([]string, 0, len(httpProtocols))
+		for protocol := range httpProtocols {
+			protocols = append(protocols, protocol)
+		}
+		sort.Strings(protocols)
+		return errors.New("http settings require one of the protocols running over HTTP: " + strings.Join(protocols, ", "))
+	}
+	httpProbe, err := m.HTTP.build()
+	if err != nil {
+		return err
+	}
+	m.httpProbe = httpProbe
+
+	if m.BannerMatch != "" {
+		if m.Protocol != "banner" {
+			return errors.New("banner_match requires the banner protocol")
+		}
+		if m.bannerMatch, err = regexp.Compile(m.BannerMatch); err != nil {
+			return fmt.Errorf("banner_match is not a valid regular expression: %s", err)
+		}
+	}
+	if m.GRPCService != "" && m.Protocol != "grpc" {
+		return errors.New("grpc_service requires the grpc protocol")
+	}
+	return nil
+}
+
+func (c HTTPConfig) configured() bool {
+	return c.Method != "" || c.Path != "" || len(c.Headers) > 0 || c.Body != "" || len(c.ValidStatusCodes) > 0 ||
+		c.BodyMatch != ""
+}
+
+// build translates the settings into the probe configuration, starting from the defaults of the protocols
+func (c HTTPConfig) build() (tcpclient.HTTPProbeConfig, error) {
+	config := tcpclient.DefaultHTTPProbe
+	if c.Method != "" {
+		config.Method = c.Method
+	}
+	if c.Path != "" {
+		if !strings.HasPrefix(c.Path, "/") {
+			return config, errors.New("http path should start with /")
+		}
+		config.Path = c.Path
+	}
+	if len(c.Headers) > 0 {
+		config.Headers = make(http.Header, len(c.Headers))
+		for name, value := range c.Headers {
+			config.Headers.Set(name, value)
+		}
+	}
+	config.Body = []byte(c.Body)
+	for _, code := range c.ValidStatusCodes {
+		if code < 100 || code > 599 {
+			return config, fmt.Errorf("http status %d is not valid", code)
+		}
+	}
+	config.StatusCodes = c.ValidStatusCodes
+	if c.BodyMatch != "" {
+		bodyMatch, err := regexp.Compile(c.BodyMatch)
+		if err != nil {
+			return config, fmt.Errorf("http body_match is not a valid regular expression: %s", err)
+		}
+		config.BodyMatch = bodyMatch
+	}
+	return config, nil
+}
+
+func (c TLSConfig) build() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		ServerName:         c.ServerName,
+	}
+	if c.CAFile != "" {
+		pemCAs, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pemCAs) {
+			return nil, errors.New("No valid certificates found in " + c.CAFile)
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// connectOptions translates the module into the options each probe connection will use
+func (m Module) connectOptions() tcpclient.ConnectOptions {
+	opts := tcpclient.DefaultConnectOptions()
+	opts.DialTimeout = m.DialTimeout
+	opts.TLSConfig = m.tlsConfig
+	opts.Payload = []byte(m.Payload)
+	opts.Expect = m.expect
+	if m.Protocol != "" {
+		opts.Protocol = m.Protocol
+	}
+	// modules not loaded from a configuration file keep the defaults of the protocols
+	if m.httpProbe.Method != "" {
+		opts.HTTP = m.httpProbe
+	}
+	opts.BannerMatch = m.bannerMatch
+	opts.GRPCHealthService = m.GRPCService
+	return opts
+}
+
+// SafeConfig allows the configuration to be reloaded while requests are being served
+type SafeConfig struct {
+	sync.RWMutex
+	C *Config
+}
+
+// ReloadConfig replaces the configuration with the content of configFile, if it's valid
+func (sc *SafeConfig) ReloadConfig(configFile string) error {
+	content, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %s", err)
+	}
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(content, c); err != nil {
+		return fmt.Errorf("error parsing config file: %s", err)
+	}
+
+	sc.Lock()
+	sc.C = c
+	sc.Unlock()
+	return nil
+}
+
+// Module returns the module called name, if it's configured
+func (sc *SafeConfig) Module(name string) (Module, bool) {
+	sc.RLock()
+	defer sc.RUnlock()
+	if sc.C == nil {
+		return Module{}, false
+	}
+	module, ok := sc.C.Modules[name]
+	return module, ok
+}
+
+// Targets returns the targets to be probed in the background, together with the modules they use
+func (sc *SafeConfig) Targets() ([]Target, map[string]Module) {
+	sc.RLock()
+	defer sc.RUnlock()
+	if sc.C == nil {
+		return nil, nil
+	}
+	return sc.C.Targets, sc.C.Modules
+}
@@ -0,0 +1,181 @@
+package promexp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func writeTempConfig(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "tcpgoon-config")
+	if err != nil {
+		t.Fatal("Could not create a temporary config file", err)
+	}
+	file.WriteString(content)
+	file.Close()
+	return file.Name()
+}
+
+func TestReloadConfig(t *testing.T) {
+	var configScenariosChecks = []struct {
+		scenarioDescription string
+		content             string
+		expectedError       bool
+		expectedModule      string
+		expectedConnections int
+		expectedSleep       time.Duration
+	}{
+		{
+			scenarioDescription: "Modules should get defaults for the settings they do not define",
+			content:             "modules:\n  small:\n    connections: 5\n",
+			expectedModule:      "small",
+			expectedConnections: 5,
+			expectedSleep:       10 * time.Millisecond,
+		},
+		{
+			scenarioDescription: "Durations should be parsed from their string representation",
+			content:             "modules:\n  slow:\n    sleep: 1s\n    hold_time: 2s\n    expect: '^OK'\n",
+			expectedModule:      "slow",
+			expectedConnections: 100,
+			expectedSleep:       1 * time.Second,
+		},
+		{
+			scenarioDescription: "Unknown settings should be rejected",
+			content:             "modules:\n  typo:\n    conections: 5\n",
+			expectedError:       true,
+		},
+		{
+			scenarioDescription: "Invalid expect regular expressions should be rejected",
+			content:             "modules:\n  broken:\n    expect: '('\n",
+			expectedError:       true,
+		},
+		{
+			scenarioDescription: "Probe settings the protocol of the module would not use should be rejected",
+			content:             "modules:\n  web:\n    http:\n      path: /health\n",
+			expectedError:       true,
+		},
+		{
+			scenarioDescription: "Banner matches of modules not using the banner protocol should be rejected",
+			content:             "modules:\n  web:\n    protocol: http\n    banner_match: '^SSH-'\n",
+			expectedError:       true,
+		},
+		{
+			scenarioDescription: "Invalid HTTP status codes should be rejected",
+			content:             "modules:\n  web:\n    protocol: http\n    http:\n      valid_status_codes: [2000]\n",
+			expectedError:       true,
+		},
+		{
+			scenarioDescription: "Targets referring to unknown modules should be rejected",
+			content:             "modules:\n  small:\n    connections: 5\ntargets:\n- target: localhost:80\n  module: big\n",
+			expectedError:       true,
+		},
+		{
+			scenarioDescription: "Targets not formatted as host:port should be rejected",
+			content:             "modules:\n  small:\n    connections: 5\ntargets:\n- target: localhost\n  module: small\n",
+			expectedError:       true,
+		},
+		{
+			scenarioDescription: "Targets with duplicated names should be rejected",
+			content: "modules:\n  small:\n    connections: 5\ntargets:\n- target: localhost:80\n  module: small\n" +
+				"- target: localhost:80\n  module: small\n",
+			expectedError: true,
+		},
+	}
+
+	for _, test := range configScenariosChecks {
+		configFile := writeTempConfig(t, test.content)
+		defer os.Remove(configFile)
+
+		config := &SafeConfig{}
+		err := config.ReloadConfig(configFile)
+		if (err != nil) != test.expectedError {
+			t.Error(test.scenarioDescription+", and the error is:", err)
+			continue
+		}
+		if test.expectedError {
+			continue
+		}
+		module, ok := config.Module(test.expectedModule)
+		if !ok || module.Connections != test.expectedConnections || module.Sleep != test.expectedSleep {
+			t.Error(test.scenarioDescription+", and the module is:", module)
+		}
+	}
+}
+
+func TestReloadConfigKeepsPreviousOnError(t *testing.T) {
+	validConfig := writeTempConfig(t, "modules:\n  small:\n    connections: 5\n")
+	defer os.Remove(validConfig)
+	invalidConfig := writeTempConfig(t, "modules: [")
+	defer os.Remove(invalidConfig)
+
+	config := &SafeConfig{}
+	if err := config.ReloadConfig(validConfig); err != nil {
+		t.Fatal("Valid config could not be loaded", err)
+	}
+	if err := config.ReloadConfig(invalidConfig); err == nil {
+		t.Error("Invalid config should not be loaded")
+	}
+	if _, ok := config.Module("small"); !ok {
+		t.Error("Previous config should be kept when reloading fails")
+	}
+}
+
+func TestReloadConfigTargetDefaults(t *testing.T) {
+	configFile := writeTempConfig(t, "modules:\n  small:\n    connections: 5\n"+
+		"targets:\n- target: localhost:80\n  module: small\n- name: web\n  target: localhost:8080\n  module: small\n  interval: 10s\n")
+	defer os.Remove(configFile)
+
+	config := &SafeConfig{}
+	if err := config.ReloadConfig(configFile); err != nil {
+		t.Fatal("Valid config could not be loaded", err)
+	}
+	targets, _ := config.Targets()
+	expectedTargets := []Target{
+		{Name: "localhost:80", Target: "localhost:80", Module: "small", Interval: 60 * time.Second, Timeout: 60 * time.Second},
+		{Name: "web", Target: "localhost:8080", Module: "small", Interval: 10 * time.Second, Timeout: 10 * time.Second},
+	}
+	if len(targets) != len(expectedTargets) {
+		t.Fatal("Targets should be loaded, and they are:", targets)
+	}
+	for i, target := range targets {
+		if target != expectedTargets[i] {
+			t.Error("Targets should get their defaults, and target", i, "is:", target)
+		}
+	}
+}
+
+func TestModuleProbeSettings(t *testing.T) {
+	configFile := writeTempConfig(t, "modules:\n"+
+		"  web:\n    protocol: http\n    http:\n      method: HEAD\n      path: /health\n"+
+		"      headers:\n        Host: example.com\n      valid_status_codes: [200, 204]\n      body_match: ok\n"+
+		"  ssh:\n    protocol: banner\n    banner_match: '^SSH-2.0'\n"+
+		"  grpc:\n    protocol: grpc\n    grpc_service: checkout\n")
+	defer os.Remove(configFile)
+
+	config := &SafeConfig{}
+	if err := config.ReloadConfig(configFile); err != nil {
+		t.Fatal("Valid config could not be loaded", err)
+	}
+
+	web, _ := config.Module("web")
+	httpProbe := web.connectOptions().HTTP
+	if httpProbe.Method != http.MethodHead || httpProbe.Path != "/health" || httpProbe.Headers.Get("Host") != "example.com" ||
+		len(httpProbe.StatusCodes) != 2 || httpProbe.BodyMatch == nil || httpProbe.BodyMatch.String() != "ok" {
+		t.Error("HTTP settings of modules should be used by their probes, and they are:", httpProbe)
+	}
+
+	ssh, _ := config.Module("ssh")
+	if bannerMatch := ssh.connectOptions().BannerMatch; bannerMatch == nil || bannerMatch.String() != "^SSH-2.0" {
+		t.Error("Banner matches of modules should be used by their probes, and it is:", bannerMatch)
+	}
+	if sshHTTP := ssh.connectOptions().HTTP; sshHTTP.Method != http.MethodGet || sshHTTP.Path != "/" {
+		t.Error("Modules without HTTP settings should keep the defaults, and they are:", sshHTTP)
+	}
+
+	grpc, _ := config.Module("grpc")
+	if service := grpc.connectOptions().GRPCHealthService; service != "checkout" {
+		t.Error("gRPC services of modules should be used by their probes, and it is:", service)
+	}
+}
@@ -0,0 +1,211 @@
+package promexp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dachad/tcpgoon/debugging"
+	"github.com/dachad/tcpgoon/mtcpclient"
+	"github.com/dachad/tcpgoon/tcpclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxStoredProbes bounds how many targets keep the details of their last probe
+const maxStoredProbes = 100
+
+// probeIDHeader is the response header carrying the ID of the probe the response describes
+const probeIDHeader = "X-Tcpgoon-Probe-Id"
+
+// probeDetails describes every connection of a probe execution, so exemplars can be followed up
+type probeDetails struct {
+	ID          string             `json:"probe_id"`
+	Target      string             `json:"target"`
+	TargetPort  int                `json:"target_port"`
+	StartedAt   time.Time          `json:"started_at"`
+	Connections []connectionDetail `json:"connections"`
+}
+
+type connectionDetail struct {
+	ID                  int               `json:"id"`
+	Status              string            `json:"status"`
+	ResolvedIP          string            `json:"resolved_ip,omitempty"`
+	LocalPort           int               `json:"local_port,omitempty"`
+	FailureReason       string            `json:"failure_reason,omitempty"`
+	ResponseTimeSeconds float64           `json:"response_time_seconds"`
+	HTTP2               *http2Detail      `json:"http2,omitempty"`
+	Events              []connectionEvent `json:"events"`
+}
+
+// http2Detail describes the HTTP/2 connection of the connections probed with the h2 and grpc protocols
+type http2Detail struct {
+	SettingsExchangeSeconds float64 `json:"settings_exchange_seconds"`
+	StreamErrors            int     `json:"stream_errors"`
+	GoAway                  bool    `json:"goaway"`
+	GoAwayCode              uint32  `json:"goaway_code,omitempty"`
+}
+
+type connectionEvent struct {
+	Status string    `json:"status"`
+	At     time.Time `json:"at"`
+	// ElapsedSeconds since the probe started
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+}
+
+func newProbeDetails(id string, c *Collector, start time.Time, connections []mtcpclient.ConnectionDetail) *probeDetails {
+	details := &probeDetails{
+		ID:          id,
+		Target:      c.targetName,
+		TargetPort:  c.targetPort,
+		StartedAt:   start,
+		Connections: make([]connectionDetail, 0, len(connections)),
+	}
+	for _, connection := range connections {
+		detail := connectionDetail{
+			ID:            connection.ID,
+			Status:        connection.GetConnectionStatus().String(),
+			ResolvedIP:    connection.GetAddress(),
+			LocalPort:     connection.GetLocalPort(),
+			FailureReason: string(connection.GetFailureReason()),
+			Events:        make([]connectionEvent, 0, len(connection.Events)),
+		}
+		if connection.GetConnectionStatus() != tcpclient.ConnectionNotInitiated {
+			detail.ResponseTimeSeconds = connection.GetTCPProcessingDuration().Seconds()
+		}
+		if http2 := connection.GetProbeOutcome().HTTP2(); http2 != (tcpclient.HTTP2Outcome{}) {
+			detail.HTTP2 = &http2Detail{
+				SettingsExchangeSeconds: http2.SettingsExchange.Seconds(),
+				StreamErrors:            http2.StreamErrors,
+				GoAway:                  http2.GoAway,
+				GoAwayCode:              http2.GoAwayCode,
+			}
+		}
+		for _, event := range connection.Events {
+			detail.Events = append(detail.Events, connectionEvent{
+				Status:         event.Status.String(),
+				At:             event.At,
+				ElapsedSeconds: event.At.Sub(start).Seconds(),
+			})
+		}
+		details.Connections = append(details.Connections, detail)
+	}
+	return details
+}
+
+// probeStore keeps the details of the last probe of each target
+type probeStore struct {
+	lock     sync.RWMutex
+	byTarget map[string]*probeDetails
+	// targets in the order their last probe was stored, to forget the oldest first
+	targets []string
+}
+
+var lastProbes = newProbeStore()
+
+func newProbeStore() *probeStore {
+	return &probeStore{byTarget: make(map[string]*probeDetails)}
+}
+
+func (s *probeStore) store(target string, details *probeDetails) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.byTarget[target]; ok {
+		for i, t := range s.targets {
+			if t == target {
+				s.targets = append(s.targets[:i], s.targets[i+1:]...)
+				break
+			}
+		}
+	} else if len(s.targets) >= maxStoredProbes {
+		delete(s.byTarget, s.targets[0])
+		s.targets = s.targets[1:]
+	}
+	s.byTarget[target] = details
+	s.targets = append(s.targets, target)
+}
+
+func (s *probeStore) get(id string) *probeDetails {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, details := range s.byTarget {
+		if details.ID == id {
+			return details
+		}
+	}
+	return nil
+}
+
+func newProbeID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// latencyExemplars picks, for each bucket of the latency histogram, its slowest successful connection,
+// so the outliers can be identified
+func latencyExemplars(probeID string, connections []mtcpclient.ConnectionDetail) []prometheus.Exemplar {
+	slowest := make(map[int]mtcpclient.ConnectionDetail)
+	for _, connection := range connections {
+		if !tcpclient.WentOk(connection.Connection) {
+			continue
+		}
+		latency := connection.GetTCPProcessingDuration()
+		bucket := latencyBucketIndex(latency)
+		if previous, ok := slowest[bucket]; !ok || latency > previous.GetTCPProcessingDuration() {
+			slowest[bucket] = connection
+		}
+	}
+
+	exemplars := make([]prometheus.Exemplar, 0, len(slowest))
+	for bucket := 0; bucket <= len(latencyBuckets); bucket++ {
+		connection, ok := slowest[bucket]
+		if !ok {
+			continue
+		}
+		labels := prometheus.Labels{
+			"connection_id": strconv.Itoa(connection.ID),
+			"local_port":    strconv.Itoa(connection.GetLocalPort()),
+			"resolved_ip":   connection.GetAddress(),
+		}
+		if probeID != "" {
+			labels["probe_id"] = probeID
+		}
+		exemplars = append(exemplars, prometheus.Exemplar{
+			Value:     connection.GetTCPProcessingDuration().Seconds(),
+			Labels:    labels,
+			Timestamp: establishedAt(connection),
+		})
+	}
+	return exemplars
+}
+
+// establishedAt returns when the connection got established, if known
+func establishedAt(connection mtcpclient.ConnectionDetail) time.Time {
+	for _, event := range connection.Events {
+		if event.Status == tcpclient.ConnectionEstablished {
+			return event.At
+		}
+	}
+	return time.Time{}
+}
+
+// probeDetailsHandler serves the details of the last probe of a target, given the ID of the probe
+func probeDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/probes/")
+	details := lastProbes.get(id)
+	if id == "" || details == nil {
+		http.Error(w, fmt.Sprintf("Probe '%s' not found. Only the last probe of each target is kept", id),
+			http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(details); err != nil {
+		fmt.Fprintln(debugging.DebugOut, "msg", "Error encoding the probe details", "err", err)
+	}
+}
@@ -0,0 +1,86 @@
+package promexp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dachad/tcpgoon/tcpserver"
+)
+
+func TestOpenMetricsExemplarsAndProbeDetails(t *testing.T) {
+	const port = 55564
+	dispatcher := &tcpserver.Dispatcher{
+		Handlers: make(map[string]*tcpserver.Handler),
+		Lock:     sync.RWMutex{},
+	}
+	if err := dispatcher.Start(context.Background(), []int{port}); err != nil {
+		t.Fatal("Could not start the TCP server", err)
+	}
+	defer dispatcher.Shutdown(context.Background())
+
+	r := httptest.NewRequest("GET", "/tcpgoon?target_ip=127.0.0.1&target_port=55564&connections=3&sleep=1", nil)
+	r.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	w := httptest.NewRecorder()
+	tcpgoonRequestHandler(w, r, Options{ConnDialTimeout: 1000}, &SafeConfig{C: &Config{}}, nil)
+
+	probeID := w.Header().Get(probeIDHeader)
+	if probeID == "" {
+		t.Fatal("Scrapes should announce the ID of their probe")
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/openmetrics-text") {
+		t.Error("Scrapes asking for OpenMetrics should get it, and they got:", w.Header().Get("Content-Type"))
+	}
+	exemplar := ""
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, "tcpgoon_response_time_seconds_bucket") && strings.Contains(line, " # {") {
+			exemplar = line
+		}
+	}
+	for _, label := range []string{"connection_id=", "local_port=", `resolved_ip="127.0.0.1"`, `probe_id="` + probeID + `"`} {
+		if !strings.Contains(exemplar, label) {
+			t.Error("Latency histogram should have exemplars identifying the slowest connections, and it is:", exemplar)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	probeDetailsHandler(w, httptest.NewRequest("GET", "/api/v1/probes/"+probeID, nil))
+	var details probeDetails
+	if err := json.NewDecoder(w.Body).Decode(&details); err != nil {
+		t.Fatal("Probe details should be served as JSON", err)
+	}
+	if details.ID != probeID || len(details.Connections) != 3 {
+		t.Fatal("Probe details should describe every connection, and they are:", details)
+	}
+	for _, connection := range details.Connections {
+		if connection.Status != "established" || connection.LocalPort == 0 || len(connection.Events) != 2 ||
+			connection.Events[0].Status != "dialing" || connection.Events[1].Status != "established" {
+			t.Error("Connection details should include their status changes, and they are:", connection)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	probeDetailsHandler(w, httptest.NewRequest("GET", "/api/v1/probes/unknown", nil))
+	if w.Code != 404 {
+		t.Error("Unknown probes should not be found, and the status is:", w.Code)
+	}
+}
+
+func TestProbeStoreKeepsLastProbePerTarget(t *testing.T) {
+	store := newProbeStore()
+	store.store("a", &probeDetails{ID: "1"})
+	store.store("a", &probeDetails{ID: "2"})
+	if store.get("1") != nil || store.get("2") == nil {
+		t.Error("Only the last probe of a target should be kept")
+	}
+
+	for i := 0; i < maxStoredProbes; i++ {
+		store.store(strings.Repeat("b", i+1), &probeDetails{ID: "b"})
+	}
+	if store.get("2") != nil || len(store.byTarget) != maxStoredProbes {
+		t.Error("Probes of the least recently probed targets should be forgotten, and they are:", len(store.byTarget))
+	}
+}
@@ -0,0 +1,103 @@
+package promexp
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	errOverloaded = errors.New("Too many probes running or queued, try again later")
+	errOverBudget = errors.New("Probe needs more connections than the exporter allows in flight")
+)
+
+// probeLimiter bounds the probes running concurrently and the connections they open, queueing
+// probes that do not fit in the budget yet. Zero values mean no limit. A nil limiter does not limit anything
+type probeLimiter struct {
+	maxProbes      int
+	maxConnections int
+	maxQueued      int
+
+	lock        sync.Mutex
+	probes      int
+	connections int
+	queued      int
+	// released is closed, and replaced, each time a probe releases its budget
+	released chan struct{}
+}
+
+func newProbeLimiter(maxProbes int, maxConnections int, maxQueued int) *probeLimiter {
+	return &probeLimiter{
+		maxProbes:      maxProbes,
+		maxConnections: maxConnections,
+		maxQueued:      maxQueued,
+		released:       make(chan struct{}),
+	}
+}
+
+// fits tells whether a probe opening that many connections can start now. Lock must be held by the caller
+func (l *probeLimiter) fits(connections int) bool {
+	return (l.maxProbes == 0 || l.probes < l.maxProbes) &&
+		(l.maxConnections == 0 || l.connections+connections <= l.maxConnections)
+}
+
+// acquire reserves the budget of a probe, waiting in the queue until it fits or ctx is done.
+// Probes are rejected when the queue is already full
+func (l *probeLimiter) acquire(ctx context.Context, connections int) error {
+	if l == nil {
+		return nil
+	}
+	if l.maxConnections != 0 && connections > l.maxConnections {
+		return errOverBudget
+	}
+
+	l.lock.Lock()
+	if !l.fits(connections) {
+		if l.queued >= l.maxQueued {
+			l.lock.Unlock()
+			return errOverloaded
+		}
+		l.queued++
+		for !l.fits(connections) {
+			released := l.released
+			l.lock.Unlock()
+			select {
+			case <-released:
+			case <-ctx.Done():
+				l.lock.Lock()
+				l.queued--
+				l.lock.Unlock()
+				return ctx.Err()
+			}
+			l.lock.Lock()
+		}
+		l.queued--
+	}
+	l.probes++
+	l.connections += connections
+	l.lock.Unlock()
+	return nil
+}
+
+// release gives back the budget reserved by acquire, waking up the queued probes
+func (l *probeLimiter) release(connections int) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.probes--
+	l.connections -= connections
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// inFlight returns the probes running and the connections they reserved
+func (l *probeLimiter) inFlight() (probes int, connections int) {
+	if l == nil {
+		return 0, 0
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.probes, l.connections
+}
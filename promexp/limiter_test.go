@@ -0,0 +1,105 @@
+package promexp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestProbeLimiterRejections(t *testing.T) {
+	var limiterScenariosChecks = []struct {
+		scenarioDescription string
+		limiter             *probeLimiter
+		running             int
+		connections         int
+		expectedError       error
+	}{
+		{
+			scenarioDescription: "Probes fitting in the budget should run right away",
+			limiter:             newProbeLimiter(2, 100, 0),
+			running:             50,
+			connections:         50,
+			expectedError:       nil,
+		},
+		{
+			scenarioDescription: "Probes bigger than the whole budget should be rejected",
+			limiter:             newProbeLimiter(0, 100, 10),
+			connections:         101,
+			expectedError:       errOverBudget,
+		},
+		{
+			scenarioDescription: "Probes not fitting in the connections budget should be rejected without a queue",
+			limiter:             newProbeLimiter(0, 100, 0),
+			running:             60,
+			connections:         50,
+			expectedError:       errOverloaded,
+		},
+		{
+			scenarioDescription: "Probes over the concurrency limit should be rejected without a queue",
+			limiter:             newProbeLimiter(1, 0, 0),
+			running:             1,
+			connections:         1,
+			expectedError:       errOverloaded,
+		},
+		{
+			scenarioDescription: "Nil limiters should not limit anything",
+			limiter:             nil,
+			connections:         100000,
+			expectedError:       nil,
+		},
+	}
+
+	for _, test := range limiterScenariosChecks {
+		if test.running > 0 {
+			if err := test.limiter.acquire(context.Background(), test.running); err != nil {
+				t.Error(test.scenarioDescription+", and the running probe could not start:", err)
+				continue
+			}
+		}
+		if err := test.limiter.acquire(context.Background(), test.connections); err != test.expectedError {
+			t.Error(test.scenarioDescription+", and the error is:", err)
+		}
+	}
+}
+
+func TestProbeLimiterQueue(t *testing.T) {
+	limiter := newProbeLimiter(1, 0, 1)
+	if err := limiter.acquire(context.Background(), 10); err != nil {
+		t.Fatal("First probe should run right away, and the error is:", err)
+	}
+
+	queued := make(chan error)
+	go func() {
+		queued <- limiter.acquire(context.Background(), 10)
+	}()
+	// give the second probe time to get queued
+	time.Sleep(100 * time.Millisecond)
+
+	if err := limiter.acquire(context.Background(), 10); err != errOverloaded {
+		t.Error("Probes should be rejected when the queue is full, and the error is:", err)
+	}
+
+	limiter.release(10)
+	select {
+	case err := <-queued:
+		if err != nil {
+			t.Error("Queued probes should run once there is budget, and the error is:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Queued probes should run once there is budget")
+	}
+	if probes, connections := limiter.inFlight(); probes != 1 || connections != 10 {
+		t.Error("Budget should be accounted for the running probe, and it is:", probes, connections)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := limiter.acquire(ctx, 10); err != context.DeadlineExceeded {
+		t.Error("Queued probes should give up when their context is done, and the error is:", err)
+	}
+	retryCtx, retryCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer retryCancel()
+	if err := limiter.acquire(retryCtx, 10); err != context.DeadlineExceeded {
+		t.Error("Queue should be released by probes giving up, and the error is:", err)
+	}
+}
@@ -0,0 +1,111 @@
+package promexp
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dachad/tcpgoon/mtcpclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// RunResults describes a finished `tcpgoon run` execution, so it can be pushed as the metrics a probe generates
+type RunResults struct {
+	TargetIP             string
+	TargetPort           int
+	Sleep                int
+	DialTimeout          int
+	AttemptedConnections int
+	Success              bool
+	Duration             time.Duration
+	Report               *mtcpclient.FinalMetricsReport
+}
+
+// PushOptions describes where run results should be pushed to
+type PushOptions struct {
+	// PushgatewayURL, when set, makes results to be pushed to that Pushgateway
+	PushgatewayURL string
+	// Job is the job name results are pushed under
+	Job string
+	// Grouping labels, identifying the pushed group together with the job
+	Grouping map[string]string
+	// RemoteWriteURL, when set, makes results to be sent to that Prometheus remote write receiver
+	RemoteWriteURL string
+}
+
+// resultsCollector serves the metrics of an already finished execution
+type resultsCollector struct {
+	result      probeResult
+	labelValues []string
+}
+
+func (c resultsCollector) Describe(ch chan<- *prometheus.Desc) {
+	probeMetrics.describe(ch)
+}
+
+func (c resultsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, metric := range probeMetrics.metrics(c.result, c.labelValues) {
+		ch <- metric
+	}
+}
+
+func (r RunResults) registry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(resultsCollector{
+		result: probeResult{
+			report:        r.Report,
+			attemptedCons: r.AttemptedConnections,
+			success:       r.Success,
+			duration:      r.Duration,
+			resolvedIPs:   1,
+		},
+		labelValues: []string{r.TargetIP, strconv.Itoa(r.TargetPort), strconv.Itoa(r.Sleep), strconv.Itoa(r.DialTimeout)},
+	})
+	return registry
+}
+
+// CheckPushGrouping rejects grouping labels colliding with the job, or with the labels the pushed metrics
+// already carry, as the series pushed would otherwise end up with duplicated labels
+func CheckPushGrouping(grouping map[string]string) error {
+	reserved := append([]string{"__name__", "job", "reason", "resolved_ip", "le"}, labels...)
+	for _, name := range reserved {
+		if _, ok := grouping[name]; ok {
+			return fmt.Errorf("Grouping label '%s' collides with the labels of the pushed metrics", name)
+		}
+	}
+	return nil
+}
+
+// PushRunResults sends the results to the Pushgateway and/or remote write receiver described by opts
+func PushRunResults(results RunResults, opts PushOptions) error {
+	if err := CheckPushGrouping(opts.Grouping); err != nil {
+		return err
+	}
+	registry := results.registry()
+
+	if opts.PushgatewayURL != "" {
+		pusher := push.New(opts.PushgatewayURL, opts.Job).Gatherer(registry)
+		for name, value := range opts.Grouping {
+			pusher = pusher.Grouping(name, value)
+		}
+		if err := pusher.Push(); err != nil {
+			return err
+		}
+	}
+
+	if opts.RemoteWriteURL != "" {
+		families, err := registry.Gather()
+		if err != nil {
+			return err
+		}
+		extraLabels := map[string]string{"job": opts.Job}
+		for name, value := range opts.Grouping {
+			extraLabels[name] = value
+		}
+		if err := remoteWrite(opts.RemoteWriteURL, families, extraLabels, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
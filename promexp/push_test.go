@@ -0,0 +1,159 @@
+package promexp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dachad/tcpgoon/mtcpclient"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeWriteRequest extracts the labels of each series of a remote write request
+func decodeWriteRequest(t *testing.T, body []byte) []map[string]string {
+	consumeMessages := func(b []byte, field protowire.Number) [][]byte {
+		var messages [][]byte
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			b = b[n:]
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				t.Fatal("Remote write request is not valid protobuf")
+			}
+			if num == field && typ == protowire.BytesType {
+				message, _ := protowire.ConsumeBytes(b)
+				messages = append(messages, message)
+			}
+			b = b[n:]
+		}
+		return messages
+	}
+
+	var series []map[string]string
+	for _, timeSeries := range consumeMessages(body, 1) {
+		labels := make(map[string]string)
+		for _, label := range consumeMessages(timeSeries, 1) {
+			fields := consumeMessages(label, 1)
+			values := consumeMessages(label, 2)
+			if len(fields) == 1 && len(values) == 1 {
+				labels[string(fields[0])] = string(values[0])
+			}
+		}
+		series = append(series, labels)
+	}
+	return series
+}
+
+func TestPushRunResults(t *testing.T) {
+	var pushedPath, pushedBody string
+	pushgateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		pushedPath, pushedBody = r.Method+" "+r.URL.Path, string(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer pushgateway.Close()
+
+	var remoteSeries []map[string]string
+	var remoteEncoding string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, _ := ioutil.ReadAll(r.Body)
+		remoteEncoding = r.Header.Get("Content-Encoding")
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Error("Remote write request should be snappy compressed", err)
+		}
+		remoteSeries = decodeWriteRequest(t, body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	_, gc := mtcpclient.StartBackgroundReporting(0, 0)
+	results := RunResults{
+		TargetIP:    "127.0.0.1",
+		TargetPort:  8080,
+		Sleep:       10,
+		DialTimeout: 5000,
+		Success:     true,
+		Duration:    time.Second,
+		Report:      mtcpclient.NewFinalMetricsReport(*gc),
+	}
+	err := PushRunResults(results, PushOptions{
+		PushgatewayURL: pushgateway.URL,
+		Job:            "ci",
+		Grouping:       map[string]string{"pipeline": "nightly"},
+		RemoteWriteURL: receiver.URL,
+	})
+	if err != nil {
+		t.Fatal("Results could not be pushed", err)
+	}
+
+	if pushedPath != "PUT /metrics/job/ci/pipeline/nightly" {
+		t.Error("Results should be pushed to the job and grouping labels path, and they went to:", pushedPath)
+	}
+	if !strings.Contains(pushedBody, "tcpgoon_probe_success") {
+		t.Error("Pushed results should contain the probe metrics")
+	}
+
+	if remoteEncoding != "snappy" {
+		t.Error("Remote write requests should announce their encoding, and it is:", remoteEncoding)
+	}
+	found := false
+	for _, labels := range remoteSeries {
+		if labels["__name__"] == "tcpgoon_probe_success" {
+			found = true
+			if labels["job"] != "ci" || labels["pipeline"] != "nightly" || labels["target_ip"] != "127.0.0.1" {
+				t.Error("Remote write series should carry the job, grouping and probe labels, and they are:", labels)
+			}
+		}
+	}
+	if !found {
+		t.Error("Remote write request should contain the probe metrics, and it is:", remoteSeries)
+	}
+}
+
+func TestPushRunResultsErrors(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer receiver.Close()
+
+	_, gc := mtcpclient.StartBackgroundReporting(0, 0)
+	results := RunResults{Report: mtcpclient.NewFinalMetricsReport(*gc)}
+	if err := PushRunResults(results, PushOptions{Job: "ci", RemoteWriteURL: receiver.URL}); err == nil ||
+		!strings.Contains(err.Error(), "out of order sample") {
+		t.Error("Errors from the remote write receiver should be reported, and the error is:", err)
+	}
+}
+
+func TestPushRunResultsGroupingCollisions(t *testing.T) {
+	pushed := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushed = true
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	_, gc := mtcpclient.StartBackgroundReporting(0, 0)
+	results := RunResults{Report: mtcpclient.NewFinalMetricsReport(*gc)}
+	for _, name := range []string{"job", "target_ip", "reason", "le"} {
+		err := PushRunResults(results, PushOptions{
+			PushgatewayURL: receiver.URL,
+			Job:            "ci",
+			Grouping:       map[string]string{"pipeline": "nightly", name: "value"},
+			RemoteWriteURL: receiver.URL,
+		})
+		if err == nil || !strings.Contains(err.Error(), name) {
+			t.Error("Grouping labels colliding with", name, "should be rejected, and the error is:", err)
+		}
+	}
+	if pushed {
+		t.Error("Results with grouping labels colliding should not be pushed anywhere")
+	}
+	if err := CheckPushGrouping(map[string]string{"pipeline": "nightly"}); err != nil {
+		t.Error("Grouping labels not colliding should be accepted, and the error is:", err)
+	}
+}
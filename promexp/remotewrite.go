@@ -0,0 +1,129 @@
+package promexp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const remoteWriteTimeout = 30 * time.Second
+
+// remoteLabel and remoteSample mirror the messages of the Prometheus remote write protocol (prompb)
+type remoteLabel struct {
+	name  string
+	value string
+}
+
+type remoteSample struct {
+	value     float64
+	timestamp int64
+}
+
+type remoteTimeSeries struct {
+	labels []remoteLabel
+	sample remoteSample
+}
+
+// remoteWrite sends the metric families to a Prometheus remote write receiver, adding extraLabels to every series
+func remoteWrite(url string, families []*dto.MetricFamily, extraLabels map[string]string, now time.Time) error {
+	body := snappy.Encode(nil, encodeWriteRequest(toTimeSeries(families, extraLabels, now)))
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	client := http.Client{Timeout: remoteWriteTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remote write receiver returned %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+// toTimeSeries flattens the metric families into series, as Prometheus would have scraped them
+func toTimeSeries(families []*dto.MetricFamily, extraLabels map[string]string, now time.Time) []remoteTimeSeries {
+	timestamp := now.UnixNano() / int64(time.Millisecond)
+	var series []remoteTimeSeries
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			add := func(suffix string, value float64, extra ...remoteLabel) {
+				labels := []remoteLabel{{"__name__", family.GetName() + suffix}}
+				for _, label := range metric.GetLabel() {
+					labels = append(labels, remoteLabel{label.GetName(), label.GetValue()})
+				}
+				for name, value := range extraLabels {
+					labels = append(labels, remoteLabel{name, value})
+				}
+				labels = append(labels, extra...)
+				sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+				series = append(series, remoteTimeSeries{labels, remoteSample{value, timestamp}})
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", metric.GetGauge().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				for _, bucket := range histogram.GetBucket() {
+					add("_bucket", float64(bucket.GetCumulativeCount()),
+						remoteLabel{"le", strconv.FormatFloat(bucket.GetUpperBound(), 'g', -1, 64)})
+				}
+				add("_bucket", float64(histogram.GetSampleCount()), remoteLabel{"le", "+Inf"})
+				add("_sum", histogram.GetSampleSum())
+				add("_count", float64(histogram.GetSampleCount()))
+			default:
+				add("", metric.GetUntyped().GetValue())
+			}
+		}
+	}
+	return series
+}
+
+// encodeWriteRequest marshals the series as a prompb.WriteRequest protobuf message
+func encodeWriteRequest(series []remoteTimeSeries) []byte {
+	var request []byte
+	for _, ts := range series {
+		var timeSeries []byte
+		for _, label := range ts.labels {
+			var l []byte
+			l = protowire.AppendTag(l, 1, protowire.BytesType)
+			l = protowire.AppendString(l, label.name)
+			l = protowire.AppendTag(l, 2, protowire.BytesType)
+			l = protowire.AppendString(l, label.value)
+			timeSeries = protowire.AppendTag(timeSeries, 1, protowire.BytesType)
+			timeSeries = protowire.AppendBytes(timeSeries, l)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(ts.sample.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(ts.sample.timestamp))
+		timeSeries = protowire.AppendTag(timeSeries, 2, protowire.BytesType)
+		timeSeries = protowire.AppendBytes(timeSeries, sample)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, timeSeries)
+	}
+	return request
+}
@@ -0,0 +1,296 @@
+package promexp
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dachad/tcpgoon/debugging"
+	"github.com/dachad/tcpgoon/tcpclient"
+)
+
+// latencyBuckets are the upper bounds of the latency distribution reported for successful connections
+var latencyBuckets = []time.Duration{
+	500 * time.Microsecond,
+	1 * time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// latencyBucketIndex returns the index of the first bucket bounding the latency, or len(latencyBuckets)
+// when it only fits in the +Inf one
+func latencyBucketIndex(latency time.Duration) int {
+	for i, bound := range latencyBuckets {
+		if latency <= bound {
+			return i
+		}
+	}
+	return len(latencyBuckets)
+}
+
+// cumulativeLatencyCounts counts, per bucket, how many latencies it bounds, as Prometheus histograms do
+func cumulativeLatencyCounts(latencies []time.Duration) []int {
+	counts := make([]int, len(latencyBuckets))
+	for _, latency := range latencies {
+		for i := latencyBucketIndex(latency); i < len(latencyBuckets); i++ {
+			counts[i]++
+		}
+	}
+	return counts
+}
+
+// probeReport is the human and machine friendly description of a probe execution
+type probeReport struct {
+	ProbeID             string            `json:"probe_id"`
+	Target              string            `json:"target"`
+	TargetIP            string            `json:"target_ip"`
+	TargetPort          int               `json:"target_port"`
+	SleepMsecs          int               `json:"sleep_msecs"`
+	TimeoutMsecs        int               `json:"timeout_msecs"`
+	Success             bool              `json:"success"`
+	DurationSeconds     float64           `json:"duration_seconds"`
+	Attempted           int               `json:"attempted_connections"`
+	Established         int               `json:"established_connections"`
+	MaxConcurrent       int               `json:"max_concurrent_connections"`
+	EstablishedOnClosed int               `json:"established_connections_on_closure"`
+	ClosedByPeer        int               `json:"closed_by_peer_connections"`
+	NotInitiated        int               `json:"not_initiated_connections"`
+	States              []stateCount      `json:"states"`
+	Failures            map[string]int    `json:"failures"`
+	ResponseTime        responseTimeStats `json:"response_time_seconds"`
+	Latency             []latencyBucket   `json:"latency_histogram"`
+	ResolvedIPs         []resolvedIPStats `json:"resolved_ips,omitempty"`
+}
+
+type stateCount struct {
+	State       string `json:"state"`
+	Connections int    `json:"connections"`
+}
+
+type responseTimeStats struct {
+	Min    float64 `json:"min"`
+	Avg    float64 `json:"avg"`
+	Max    float64 `json:"max"`
+	StdDev float64 `json:"stddev"`
+}
+
+// latencyBucket follows the Prometheus histograms convention: Count is cumulative, and Le the upper bound
+type latencyBucket struct {
+	Le    string `json:"le"`
+	Count int    `json:"count"`
+	// InBucket is the number of connections not counted by the previous bucket, to draw the distribution
+	InBucket int `json:"-"`
+	// Percentage of the successful connections in this bucket
+	Percentage float64 `json:"-"`
+}
+
+type resolvedIPStats struct {
+	IP                 string  `json:"ip"`
+	Established        int     `json:"established_connections"`
+	Failed             int     `json:"failed_connections"`
+	AvgResponseSeconds float64 `json:"avg_response_time_seconds"`
+}
+
+func newProbeReport(c *Collector, result probeResult) probeReport {
+	fmr := result.report
+	mr := fmr.SuccessfulConnectionReport()
+	report := probeReport{
+		ProbeID:             result.probeID,
+		Target:              c.targetName,
+		TargetIP:            c.targetIp,
+		TargetPort:          c.targetPort,
+		SleepMsecs:          c.delay,
+		TimeoutMsecs:        c.connDialTimeout,
+		Success:             result.success,
+		DurationSeconds:     result.duration.Seconds(),
+		Attempted:           result.attemptedCons,
+		Established:         fmr.EstablishedCons(),
+		MaxConcurrent:       fmr.MaxConcurrentCons(),
+		EstablishedOnClosed: fmr.EstablishedConsOnClosure(),
+		ClosedByPeer:        fmr.ClosedByPeerCons(),
+		NotInitiated:        fmr.NotInitiatedCons(),
+		Failures:            make(map[string]int),
+		ResponseTime: responseTimeStats{
+			Min:    mr.Min().Seconds(),
+			Avg:    mr.Avg().Seconds(),
+			Max:    mr.Max().Seconds(),
+			StdDev: mr.StdDev().Seconds(),
+		},
+		Latency: newLatencyHistogram(fmr.SuccessfulLatencies()),
+	}
+
+	connectionsByStatus := fmr.ConnectionsByStatus()
+	for _, status := range tcpclient.ConnectionStatuses {
+		report.States = append(report.States, stateCount{status.String(), connectionsByStatus[status]})
+	}
+	for reason, count := range fmr.FailedConsByReason() {
+		report.Failures[string(reason)] = count
+	}
+
+	if result.resolvedIPs > 1 {
+		for ip, ipReport := range fmr.AddressReports() {
+			failed := 0
+			for _, count := range ipReport.FailedConsByReason() {
+				failed += count
+			}
+			report.ResolvedIPs = append(report.ResolvedIPs, resolvedIPStats{
+				IP:                 ip,
+				Established:        ipReport.EstablishedCons(),
+				Failed:             failed,
+				AvgResponseSeconds: ipReport.SuccessfulConnectionReport().Avg().Seconds(),
+			})
+		}
+		sort.Slice(report.ResolvedIPs, func(i, j int) bool { return report.ResolvedIPs[i].IP < report.ResolvedIPs[j].IP })
+	}
+	return report
+}
+
+func newLatencyHistogram(latencies []time.Duration) []latencyBucket {
+	histogram := make([]latencyBucket, 0, len(latencyBuckets)+1)
+	previous := 0
+	addBucket := func(le string, count int) {
+		bucket := latencyBucket{Le: le, Count: count, InBucket: count - previous}
+		if len(latencies) > 0 {
+			bucket.Percentage = 100 * float64(bucket.InBucket) / float64(len(latencies))
+		}
+		histogram = append(histogram, bucket)
+		previous = count
+	}
+
+	for i, count := range cumulativeLatencyCounts(latencies) {
+		addBucket(strconv.FormatFloat(latencyBuckets[i].Seconds(), 'g', -1, 64), count)
+	}
+	addBucket("+Inf", len(latencies))
+	return histogram
+}
+
+// apiProbeHandler runs the probe described by the request, replying with its full results as JSON
+func apiProbeHandler(w http.ResponseWriter, r *http.Request, opts Options, config *SafeConfig, limiter *probeLimiter) {
+	collector, release := newCollectorFromRequest(w, r, opts, config, limiter)
+	if collector == nil {
+		return
+	}
+	defer release()
+
+	collector.probeID = newProbeID()
+	w.Header().Set(probeIDHeader, collector.probeID)
+	report := newProbeReport(collector, collector.probe())
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		fmt.Fprintln(debugging.DebugOut, "msg", "Error encoding the probe report", "err", err)
+	}
+}
+
+// resultsPageHandler runs the probe described by the request, rendering its results for humans
+func resultsPageHandler(w http.ResponseWriter, r *http.Request, opts Options, config *SafeConfig, limiter *probeLimiter) {
+	collector, release := newCollectorFromRequest(w, r, opts, config, limiter)
+	if collector == nil {
+		return
+	}
+	defer release()
+
+	collector.probeID = newProbeID()
+	w.Header().Set(probeIDHeader, collector.probeID)
+	report := newProbeReport(collector, collector.probe())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := resultsTemplate.Execute(w, report); err != nil {
+		fmt.Fprintln(debugging.DebugOut, "msg", "Error rendering the results page", "err", err)
+	}
+}
+
+var resultsTemplate = template.Must(template.New("results").Funcs(template.FuncMap{
+	"ms": func(seconds float64) string { return strconv.FormatFloat(seconds*1000, 'f', 3, 64) + "ms" },
+}).Parse(`
+	<html>
+		<head>
+			<title>tcpgoon Exporter - {{.Target}}:{{.TargetPort}}</title>
+			<style>
+				table {
+					border-collapse: collapse;
+					margin: 10px;
+				}
+				th, td {
+					border: 1px solid #ccc;
+					padding: 4px 10px;
+					text-align: right;
+				}
+				.bar {
+					background: #4a90d9;
+					height: 12px;
+				}
+				.ok { color: green; }
+				.ko { color: red; }
+			</style>
+		</head>
+		<body>
+		<h1>tcpgoon Exporter</h1>
+		<h2>{{.Target}}:{{.TargetPort}} ({{.TargetIP}})
+			{{if .Success}}<span class="ok">OK</span>{{else}}<span class="ko">FAILED</span>{{end}}</h2>
+		<p>{{.Attempted}} connections, {{.SleepMsecs}}ms apart, {{.TimeoutMsecs}}ms dial timeout.
+			Completed in {{printf "%.3f" .DurationSeconds}}s</p>
+
+		<h3>Connections</h3>
+		<table>
+			<tr><th>Total established</th><td>{{.Established}}</td></tr>
+			<tr><th>Max concurrent established</th><td>{{.MaxConcurrent}}</td></tr>
+			<tr><th>Established on closure</th><td>{{.EstablishedOnClosed}}</td></tr>
+			<tr><th>Closed by the other end</th><td>{{.ClosedByPeer}}</td></tr>
+			<tr><th>Not initiated</th><td>{{.NotInitiated}}</td></tr>
+		</table>
+
+		<h3>Final state</h3>
+		<table>
+			<tr><th>State</th><th>Connections</th></tr>
+			{{range .States}}<tr><td>{{.State}}</td><td>{{.Connections}}</td></tr>
+			{{end}}
+		</table>
+
+		<h3>Failures</h3>
+		<table>
+			<tr><th>Reason</th><th>Connections</th></tr>
+			{{range $reason, $count := .Failures}}{{if $count}}<tr><td>{{$reason}}</td><td>{{$count}}</td></tr>
+			{{end}}{{end}}
+		</table>
+
+		<h3>Response time</h3>
+		<table>
+			<tr><th>min</th><th>avg</th><th>max</th><th>stddev</th></tr>
+			<tr><td>{{ms .ResponseTime.Min}}</td><td>{{ms .ResponseTime.Avg}}</td>
+				<td>{{ms .ResponseTime.Max}}</td><td>{{ms .ResponseTime.StdDev}}</td></tr>
+		</table>
+
+		<h3>Latency distribution</h3>
+		<table>
+			<tr><th>Up to (s)</th><th>Connections</th><th></th></tr>
+			{{range .Latency}}<tr><td>{{.Le}}</td><td>{{.InBucket}}</td>
+				<td style="text-align: left; width: 300px"><div class="bar" style="width: {{printf "%.0f" .Percentage}}%"></div></td></tr>
+			{{end}}
+		</table>
+
+		{{if .ResolvedIPs}}
+		<h3>Resolved addresses</h3>
+		<table>
+			<tr><th>IP</th><th>Established</th><th>Failed</th><th>Avg response time</th></tr>
+			{{range .ResolvedIPs}}<tr><td>{{.IP}}</td><td>{{.Established}}</td><td>{{.Failed}}</td>
+				<td>{{ms .AvgResponseSeconds}}</td></tr>
+			{{end}}
+		</table>
+		{{end}}
+
+		<p><a href="/api/v1/probes/{{.ProbeID}}">Connection details</a> | <a href="/">New check</a></p>
+		</body>
+	</html>
+	`))
@@ -0,0 +1,108 @@
+package promexp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dachad/tcpgoon/tcpserver"
+)
+
+func TestNewLatencyHistogram(t *testing.T) {
+	latencies := []time.Duration{
+		200 * time.Microsecond,
+		800 * time.Microsecond,
+		900 * time.Microsecond,
+		3 * time.Second,
+		10 * time.Second,
+	}
+	histogram := newLatencyHistogram(latencies)
+
+	if len(histogram) != len(latencyBuckets)+1 {
+		t.Fatal("Histogram should have a bucket per bound plus +Inf, and it has:", len(histogram))
+	}
+	var histogramScenariosChecks = []struct {
+		scenarioDescription string
+		bucket              int
+		expectedLe          string
+		expectedCount       int
+		expectedInBucket    int
+	}{
+		{
+			scenarioDescription: "First bucket should count the fastest connections",
+			bucket:              0,
+			expectedLe:          "0.0005",
+			expectedCount:       1,
+			expectedInBucket:    1,
+		},
+		{
+			scenarioDescription: "Buckets should be cumulative",
+			bucket:              1,
+			expectedLe:          "0.001",
+			expectedCount:       3,
+			expectedInBucket:    2,
+		},
+		{
+			scenarioDescription: "Last bucket should count all the connections",
+			bucket:              len(latencyBuckets),
+			expectedLe:          "+Inf",
+			expectedCount:       5,
+			expectedInBucket:    1,
+		},
+	}
+
+	for _, test := range histogramScenariosChecks {
+		bucket := histogram[test.bucket]
+		if bucket.Le != test.expectedLe || bucket.Count != test.expectedCount || bucket.InBucket != test.expectedInBucket {
+			t.Error(test.scenarioDescription+", and it is:", bucket)
+		}
+	}
+}
+
+func TestProbeReportEndpoints(t *testing.T) {
+	const port = 55563
+	dispatcher := &tcpserver.Dispatcher{
+		Handlers: make(map[string]*tcpserver.Handler),
+		Lock:     sync.RWMutex{},
+	}
+	if err := dispatcher.Start(context.Background(), []int{port}); err != nil {
+		t.Fatal("Could not start the TCP server", err)
+	}
+	defer dispatcher.Shutdown(context.Background())
+
+	query := "?target_ip=127.0.0.1&target_port=55563&connections=3&sleep=1"
+	config := &SafeConfig{C: &Config{}}
+	opts := Options{ConnDialTimeout: 1000}
+
+	w := httptest.NewRecorder()
+	apiProbeHandler(w, httptest.NewRequest("GET", "/api/v1/probe"+query, nil), opts, config, nil)
+	var report probeReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal("JSON API should reply with a probe report", err)
+	}
+	if !report.Success || report.Established != 3 || report.Latency[len(report.Latency)-1].Count != 3 {
+		t.Error("JSON API should describe the probe results, and they are:", report)
+	}
+	for _, state := range report.States {
+		if state.State == "established" && state.Connections != 3 {
+			t.Error("JSON API should count connections per state, and they are:", report.States)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	resultsPageHandler(w, httptest.NewRequest("GET", "/results"+query, nil), opts, config, nil)
+	page := w.Body.String()
+	if !strings.Contains(page, "Latency distribution") || !strings.Contains(page, "<td>established</td><td>3</td>") {
+		t.Error("Results page should render the probe results, and it is:", page)
+	}
+
+	w = httptest.NewRecorder()
+	apiProbeHandler(w, httptest.NewRequest("GET", "/api/v1/probe?target_ip=127.0.0.1", nil), opts, config, nil)
+	if w.Code != 400 {
+		t.Error("JSON API should reject malformed requests, and its status is:", w.Code)
+	}
+}
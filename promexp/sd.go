@@ -0,0 +1,118 @@
+package promexp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SDTarget is a target to be probed through the exporter, as listed by the operator
+type SDTarget struct {
+	Target string
+	// Module is optional. Targets without a module are probed with the connections and sleep defaults
+	Module string
+}
+
+// SDDefaults are the params of the targets not using a module
+type SDDefaults struct {
+	Connections int
+	Sleep       int
+}
+
+// TargetGroup is a Prometheus file_sd / http_sd target group
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// ParseSDTargets reads a target per line, formatted as "host:port [module]". Blank lines and
+// lines starting with # are ignored
+func ParseSDTargets(r io.Reader) ([]SDTarget, error) {
+	var targets []SDTarget
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d should be formatted as host:port [module]", line)
+		}
+		target := SDTarget{Target: fields[0]}
+		if len(fields) == 2 {
+			target.Module = fields[1]
+		}
+		targets = append(targets, target)
+	}
+	return targets, scanner.Err()
+}
+
+// params returns the query params the exporter expects to probe the target
+func (t SDTarget) params(defaults SDDefaults) (url.Values, error) {
+	if t.Module != "" {
+		return url.Values{"module": {t.Module}, "target": {t.Target}}, nil
+	}
+	host, port, err := splitTarget(t.Target)
+	if err != nil {
+		return nil, err
+	}
+	return url.Values{
+		"target_ip":   {host},
+		"target_port": {strconv.Itoa(port)},
+		"connections": {strconv.Itoa(defaults.Connections)},
+		"sleep":       {strconv.Itoa(defaults.Sleep)},
+	}, nil
+}
+
+// validateParams applies to the params the same checks the exporter applies to the requests
+func validateParams(q url.Values, config *SafeConfig) []error {
+	if q.Get("module") != "" {
+		if errs := checkParamsPresent(q, moduleQueryParams[:]); len(errs) > 0 {
+			return errs
+		}
+		_, errs := checkModuleQueryParamsValid(q, config, nil)
+		return errs
+	}
+	if errs := checkQueryParamsPresent(q); len(errs) > 0 {
+		return errs
+	}
+	_, errs := checkQueryParamsValid(q, nil)
+	return errs
+}
+
+// NewTargetGroups generates a target group per target, scraping exporterAddress with the __param_*
+// labels Prometheus translates into the query params of the probe, and the target as instance.
+// Targets not passing the exporter validations are left out, and reported back
+func NewTargetGroups(targets []SDTarget, exporterAddress string, defaults SDDefaults,
+	config *SafeConfig) ([]TargetGroup, []error) {
+	groups := make([]TargetGroup, 0, len(targets))
+	var errs []error
+	for _, target := range targets {
+		params, err := target.params(defaults)
+		if err == nil {
+			if validationErrs := validateParams(params, config); len(validationErrs) > 0 {
+				err = validationErrs[0]
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", target.Target, err))
+			continue
+		}
+
+		labels := map[string]string{
+			"__metrics_path__": "/tcpgoon",
+			"instance":         target.Target,
+		}
+		for param := range params {
+			labels["__param_"+param] = params.Get(param)
+		}
+		groups = append(groups, TargetGroup{
+			Targets: []string{exporterAddress},
+			Labels:  labels,
+		})
+	}
+	return groups, errs
+}
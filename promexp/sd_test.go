@@ -0,0 +1,117 @@
+package promexp
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseSDTargets(t *testing.T) {
+	targets, err := ParseSDTargets(strings.NewReader("# databases\nlocalhost:5432 postgres\n\n127.0.0.1:80\n"))
+	if err != nil {
+		t.Fatal("Valid targets could not be parsed", err)
+	}
+	expectedTargets := []SDTarget{{"localhost:5432", "postgres"}, {"127.0.0.1:80", ""}}
+	if len(targets) != len(expectedTargets) || targets[0] != expectedTargets[0] || targets[1] != expectedTargets[1] {
+		t.Error("Targets should be parsed ignoring comments and blank lines, and they are:", targets)
+	}
+
+	if _, err := ParseSDTargets(strings.NewReader("localhost:80 small extra\n")); err == nil {
+		t.Error("Lines with too many fields should be rejected")
+	}
+}
+
+func TestNewTargetGroups(t *testing.T) {
+	configFile := writeTempConfig(t, "modules:\n  small:\n    connections: 5\n")
+	defer os.Remove(configFile)
+	config := &SafeConfig{}
+	if err := config.ReloadConfig(configFile); err != nil {
+		t.Fatal("Valid config could not be loaded", err)
+	}
+
+	var groupsScenariosChecks = []struct {
+		scenarioDescription string
+		target              SDTarget
+		expectedError       bool
+		expectedLabels      map[string]string
+	}{
+		{
+			scenarioDescription: "Targets using a module should get the module and target params",
+			target:              SDTarget{"127.0.0.1:8080", "small"},
+			expectedLabels: map[string]string{
+				"__metrics_path__": "/tcpgoon",
+				"__param_module":   "small",
+				"__param_target":   "127.0.0.1:8080",
+				"instance":         "127.0.0.1:8080",
+			},
+		},
+		{
+			scenarioDescription: "Targets without a module should get the defaults as params",
+			target:              SDTarget{"127.0.0.1:8080", ""},
+			expectedLabels: map[string]string{
+				"__metrics_path__":    "/tcpgoon",
+				"__param_target_ip":   "127.0.0.1",
+				"__param_target_port": "8080",
+				"__param_connections": "10",
+				"__param_sleep":       "5",
+				"instance":            "127.0.0.1:8080",
+			},
+		},
+		{
+			scenarioDescription: "Targets using unknown modules should be rejected",
+			target:              SDTarget{"127.0.0.1:8080", "big"},
+			expectedError:       true,
+		},
+		{
+			scenarioDescription: "Targets without a port should be rejected",
+			target:              SDTarget{"127.0.0.1", ""},
+			expectedError:       true,
+		},
+		{
+			scenarioDescription: "Targets without a module and a zero port should be rejected",
+			target:              SDTarget{"127.0.0.1:0", ""},
+			expectedError:       true,
+		},
+		{
+			scenarioDescription: "Targets without a module and a port out of range should be rejected",
+			target:              SDTarget{"127.0.0.1:70000", ""},
+			expectedError:       true,
+		},
+		{
+			scenarioDescription: "Targets using a module and a port out of range should be rejected",
+			target:              SDTarget{"127.0.0.1:70000", "small"},
+			expectedError:       true,
+		},
+		{
+			scenarioDescription: "Targets not resolvable should be rejected",
+			target:              SDTarget{"not-resolvable.invalid:80", "small"},
+			expectedError:       true,
+		},
+	}
+
+	for _, test := range groupsScenariosChecks {
+		groups, errs := NewTargetGroups([]SDTarget{test.target}, "exporter:9100", SDDefaults{Connections: 10, Sleep: 5}, config)
+		if (len(errs) > 0) != test.expectedError {
+			t.Error(test.scenarioDescription+", and the errors are:", errs)
+			continue
+		}
+		if test.expectedError {
+			if len(groups) != 0 {
+				t.Error(test.scenarioDescription+", and the groups are:", groups)
+			}
+			continue
+		}
+		if len(groups) != 1 || len(groups[0].Targets) != 1 || groups[0].Targets[0] != "exporter:9100" {
+			t.Error(test.scenarioDescription+", and the groups are:", groups)
+			continue
+		}
+		if len(groups[0].Labels) != len(test.expectedLabels) {
+			t.Error(test.scenarioDescription+", and the labels are:", groups[0].Labels)
+		}
+		for name, value := range test.expectedLabels {
+			if groups[0].Labels[name] != value {
+				t.Error(test.scenarioDescription+", and the labels are:", groups[0].Labels)
+			}
+		}
+	}
+}
@@ -1,13 +1,17 @@
 package promexp
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/dachad/tcpgoon/debugging"
@@ -36,13 +40,15 @@ var (
 		</head>
 		<body>
 		<h1>tcpgoon Exporter</h1>
-		<form action="/tcpgoon">
+		<form action="/results">
 			<label>Target:</label> <input type="text" name="target_ip" placeholder="X.X.X.X"><br>
 			<label>Target Port:</label> <input type="text" name="target_port" placeholder="8080"><br>
 			<label>Connection Count:</label> <input type="text" name="connections" placeholder="100"><br>
 			<label>Sleep:</label> <input type="text" name="sleep" placeholder="10"><br>
 			<input type="submit" value="Submit">
 		</form>
+		<p>The same params can be sent to <a href="/tcpgoon">/tcpgoon</a> for Prometheus metrics,
+		or to <a href="/api/v1/probe">/api/v1/probe</a> for a JSON report.</p>
 		</body>
 	</html>
 	`)
@@ -60,12 +66,72 @@ var (
 			Help: "Number of requests with params that are not present in the config or did not pass parameter validation",
 		},
 	)
-	queryParams = [...]string{"target_ip", "target_port", "connections", "sleep"}
+
+	RequestOverloadRejections = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tcpgoon_request_overload_rejections_total",
+			Help: "Number of requests rejected because the exporter was running too many probes or connections",
+		},
+	)
+
+	RequestRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tcpgoon_request_rejected_total",
+			Help: "Number of requests rejected because of their credentials or a target out of the allowlist",
+		},
+		[]string{"reason"},
+	)
+	ConfigReloadSuccess = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tcpgoon_config_last_reload_successful",
+			Help: "Whether the last configuration reload attempt was successful",
+		},
+	)
+
+	ConfigReloadSeconds = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tcpgoon_config_last_reload_success_timestamp_seconds",
+			Help: "Timestamp of the last successful configuration reload",
+		},
+	)
+	queryParams       = [...]string{"target_ip", "target_port", "connections", "sleep"}
+	moduleQueryParams = [...]string{"module", "target"}
 )
 
+// Options gathers the exporter settings supplied by the operator
+type Options struct {
+	// ConnDialTimeout, in ms, applies to requests not using a module
+	ConnDialTimeout int
+	// ConfigFile, when set, holds the modules requests can refer to
+	ConfigFile string
+	// TimeoutOffset is subtracted from the Prometheus scrape timeout to bound the probes duration
+	TimeoutOffset time.Duration
+	// MaxConcurrentProbes bounds the probes running at the same time. 0 means no limit
+	MaxConcurrentProbes int
+	// MaxInFlightConnections bounds the connections all running probes can open. 0 means no limit
+	MaxInFlightConnections int
+	// MaxQueuedProbes is how many probes can wait for budget before new scrapes get rejected
+	MaxQueuedProbes int
+	// MaxConnectionsPerProbe caps the connections param of requests not using a module. 0 means no limit
+	MaxConnectionsPerProbe int
+	// MaxSleep, in ms, caps the sleep param of requests not using a module. 0 means no limit
+	MaxSleep int
+	// Allowlist, when set, restricts the targets requests can probe
+	Allowlist *TargetAllowlist
+	// Credentials callers have to present, if any
+	Credentials Credentials
+	// TLSCertFile and TLSKeyFile, when set, make the exporter serve HTTPS
+	TLSCertFile string
+	TLSKeyFile  string
+}
+
 func checkQueryParamsPresent(q url.Values) []error {
-	errs := make([]error, 0, len(queryParams))
-	for _, param := range queryParams {
+	return checkParamsPresent(q, queryParams[:])
+}
+
+func checkParamsPresent(q url.Values, params []string) []error {
+	errs := make([]error, 0, len(params))
+	for _, param := range params {
 		if q[param] == nil {
 			errs = append(errs, fmt.Errorf("Param '%s' must be specified", param))
 		}
@@ -79,32 +145,108 @@ func checkQueryParamsPresent(q url.Values) []error {
 	return errs
 }
 
-func checkQueryParamsValid(q url.Values) []error {
+// checkQueryParamsValid validates the params of requests not using a module, returning the addresses
+// the target resolved to when it is valid and allowed
+func checkQueryParamsValid(q url.Values, allowlist *TargetAllowlist) ([]net.IP, []error) {
 	errs := make([]error, 0, len(queryParams))
 
-	addrs, err := net.LookupIP(q.Get("target_ip"))
-	if err != nil || len(addrs) == 0 {
-		return append(errs, errors.New("Param 'target_ip' is not a valid IP address or not resolvable"))
+	addrs, err := lookupTarget(q.Get("target_ip"))
+	if err != nil {
+		return nil, append(errs, errors.New("Param 'target_ip' is not a valid IP address or not resolvable"))
 	}
 
-	i, err := strconv.Atoi(q.Get("connections"))
-	if err != nil && i < 0 && i > 65536 {
+	if port, err := strconv.Atoi(q.Get("target_port")); err != nil || port <= 0 || port > 65535 {
 		errs = append(errs, errors.New("Param 'target_port' is not a valid port number"))
 	}
 
 	for _, param := range queryParams[2:] {
 		i, err := strconv.Atoi(q.Get(param))
-		if err != nil && i < 0 {
+		if err != nil || i < 0 {
 			errs = append(errs, fmt.Errorf("Param %s is not a valid positive number", param))
 		}
 	}
 
+	if len(errs) > 0 {
+		RequestInvalidParamsErrors.Inc()
+		return nil, errs
+	}
+
+	targetPort, _ := strconv.Atoi(q.Get("target_port"))
+	if errs := checkTargetAllowed(q.Get("target_ip"), addrs, targetPort, allowlist); len(errs) > 0 {
+		return nil, errs
+	}
+	return addrs, nil
+}
+
+// checkTargetAllowed rejects targets out of the allowlist set by the operator
+func checkTargetAllowed(host string, addrs []net.IP, port int, allowlist *TargetAllowlist) []error {
+	if err := allowlist.allows(host, addrs, port); err != nil {
+		RequestRejections.WithLabelValues("target_not_allowed").Inc()
+		return []error{err}
+	}
+	return nil
+}
+
+// checkModuleQueryParamsValid validates the params of requests using a module, returning the addresses
+// the target resolved to when it is valid and allowed
+func checkModuleQueryParamsValid(q url.Values, config *SafeConfig, allowlist *TargetAllowlist) ([]net.IP, []error) {
+	var errs []error
+	if _, ok := config.Module(q.Get("module")); !ok {
+		errs = append(errs, fmt.Errorf("Module '%s' is not configured", q.Get("module")))
+	}
+
+	host, port, err := splitTarget(q.Get("target"))
+	var addrs []net.IP
+	if err != nil {
+		errs = append(errs, err)
+	} else if addrs, err = lookupTarget(host); err != nil {
+		errs = append(errs, errors.New("Param 'target' host is not a valid IP address or not resolvable"))
+	} else if port <= 0 || port > 65535 {
+		errs = append(errs, errors.New("Param 'target' port is not a valid port number"))
+	}
+
+	if len(errs) > 0 {
+		RequestInvalidParamsErrors.Inc()
+		return nil, errs
+	}
+	if errs := checkTargetAllowed(host, addrs, port, allowlist); len(errs) > 0 {
+		return nil, errs
+	}
+	return addrs, nil
+}
+
+// checkQueryParamsLimits enforces the caps set by the operator on the params of requests not using a module
+func checkQueryParamsLimits(q url.Values, opts Options) []error {
+	errs := make([]error, 0, 2)
+
+	connections, _ := strconv.Atoi(q.Get("connections"))
+	if opts.MaxConnectionsPerProbe != 0 && connections > opts.MaxConnectionsPerProbe {
+		errs = append(errs, fmt.Errorf("Param 'connections' can not be bigger than %d", opts.MaxConnectionsPerProbe))
+	}
+
+	sleep, _ := strconv.Atoi(q.Get("sleep"))
+	if opts.MaxSleep != 0 && sleep > opts.MaxSleep {
+		errs = append(errs, fmt.Errorf("Param 'sleep' can not be bigger than %d", opts.MaxSleep))
+	}
+
 	if len(errs) > 0 {
 		RequestInvalidParamsErrors.Inc()
 	}
 	return errs
 }
 
+func splitTarget(target string) (string, int, error) {
+	host, sport, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", 0, errors.New("Param 'target' should be formatted as host:port")
+	}
+	port, err := strconv.Atoi(sport)
+	if err != nil {
+		return "", 0, errors.New("Param 'target' port is not a valid integer")
+	}
+	return host, port, nil
+}
+
 func handleRequestErrors(errs []error, w http.ResponseWriter) {
 
 	errorString := strings.Join(func() []string {
@@ -115,70 +257,294 @@ func handleRequestErrors(errs []error, w http.ResponseWriter) {
 		return errorStrings
 	}(), "\n")
 
+	status := http.StatusBadRequest
 	for _, err := range errs {
 		fmt.Fprintln(debugging.DebugOut, "bad_request", err)
+		if _, ok := err.(targetNotAllowedError); ok {
+			status = http.StatusForbidden
+		}
 	}
 
-	http.Error(w, errorString, 400)
+	http.Error(w, errorString, status)
 }
 
-func tcpgoonRequestHandler(w http.ResponseWriter, r *http.Request, connDialTimeout int) {
+// probeContext bounds the probe to the scrape timeout Prometheus announces, minus an offset
+// to leave some room to serve the response. Without that header, probes are only bound to the request
+func probeContext(r *http.Request, offset time.Duration) (context.Context, context.CancelFunc, error) {
+	header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if header == "" {
+		ctx, cancel := context.WithCancel(r.Context())
+		return ctx, cancel, nil
+	}
+
+	timeoutSeconds, err := strconv.ParseFloat(header, 64)
+	if err != nil || timeoutSeconds <= 0 {
+		return nil, nil, fmt.Errorf("Failed to parse timeout from Prometheus header: %s", header)
+	}
+	timeout := time.Duration(timeoutSeconds * float64(time.Second))
+	if timeout > offset {
+		timeout -= offset
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return ctx, cancel, nil
+}
+
+// newCollectorFromRequest validates the request and reserves the budget to probe what it asks for, writing
+// the error response when it can not be served. The returned function releases the probe resources
+func newCollectorFromRequest(w http.ResponseWriter, r *http.Request, opts Options, config *SafeConfig,
+	limiter *probeLimiter) (*Collector, func()) {
 	query := r.URL.Query()
 
 	fmt.Fprintln(debugging.DebugOut, "request_param", fmt.Sprint(query), "remote", r.RemoteAddr)
+	ctx, cancel, err := probeContext(r, opts.TimeoutOffset)
+	if err != nil {
+		handleRequestErrors([]error{err}, w)
+		return nil, nil
+	}
+
+	var collector *Collector
+	if query.Get("module") != "" {
+		collector = newModuleCollectorFromQuery(w, query, config, opts.Allowlist)
+	} else {
+		collector = newCollectorFromQuery(w, query, opts)
+	}
+	if collector == nil {
+		cancel()
+		return nil, nil
+	}
+	collector.ctx = ctx
+
+	if err := limiter.acquire(ctx, collector.numberConnections); err != nil {
+		cancel()
+		RequestOverloadRejections.Inc()
+		fmt.Fprintln(debugging.DebugOut, "msg", "Rejecting scrape", "err", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return nil, nil
+	}
+	return collector, func() {
+		limiter.release(collector.numberConnections)
+		cancel()
+	}
+}
+
+func tcpgoonRequestHandler(w http.ResponseWriter, r *http.Request, opts Options, config *SafeConfig,
+	limiter *probeLimiter) {
+	collector, release := newCollectorFromRequest(w, r, opts, config, limiter)
+	if collector == nil {
+		return
+	}
+	defer release()
+
+	collector.probeID = newProbeID()
+	w.Header().Set(probeIDHeader, collector.probeID)
+	start := time.Now()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	// OpenMetrics is negotiated with the scrapers supporting it, so the latency exemplars get exposed
+	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+	h.ServeHTTP(w, r)
+	duration := time.Since(start).Seconds()
+	fmt.Fprintln(debugging.DebugOut, "msg", "Finished scrape", "duration_seconds", duration)
+
+}
+
+func newCollectorFromQuery(w http.ResponseWriter, query url.Values, opts Options) *Collector {
 	errsQueryParamsPresent := checkQueryParamsPresent(query)
 	if len(errsQueryParamsPresent) > 0 {
 		handleRequestErrors(errsQueryParamsPresent, w)
-		return
+		return nil
 	}
 
-	errsQueryParamsValid := checkQueryParamsValid(query)
+	addrs, errsQueryParamsValid := checkQueryParamsValid(query, opts.Allowlist)
 	if len(errsQueryParamsValid) > 0 {
 		handleRequestErrors(errsQueryParamsValid, w)
-		return
+		return nil
 	}
 
-	start := time.Now()
-	registry := prometheus.NewRegistry()
+	errsQueryParamsLimits := checkQueryParamsLimits(query, opts)
+	if len(errsQueryParamsLimits) > 0 {
+		handleRequestErrors(errsQueryParamsLimits, w)
+		return nil
+	}
 
 	targetPort, _ := strconv.Atoi(query.Get("target_port"))
 	connections, _ := strconv.Atoi(query.Get("connections"))
 	sleep, _ := strconv.Atoi(query.Get("sleep"))
 
-	collector := NewCollector(
+	collector, err := NewCollector(
 		query.Get("target_ip"),
+		addrs,
 		targetPort,
 		connections,
-		connDialTimeout,
 		sleep,
+		opts.ConnDialTimeout,
 	)
+	if err != nil {
+		handleRequestErrors([]error{err}, w)
+		return nil
+	}
+	return collector
+}
 
-	registry.MustRegister(collector)
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
-	h.ServeHTTP(w, r)
-	duration := time.Since(start).Seconds()
-	fmt.Fprintln(debugging.DebugOut, "msg", "Finished scrape", "duration_seconds", duration)
+func newModuleCollectorFromQuery(w http.ResponseWriter, query url.Values, config *SafeConfig,
+	allowlist *TargetAllowlist) *Collector {
+	errsQueryParamsPresent := checkParamsPresent(query, moduleQueryParams[:])
+	if len(errsQueryParamsPresent) > 0 {
+		handleRequestErrors(errsQueryParamsPresent, w)
+		return nil
+	}
+
+	addrs, errsQueryParamsValid := checkModuleQueryParamsValid(query, config, allowlist)
+	if len(errsQueryParamsValid) > 0 {
+		handleRequestErrors(errsQueryParamsValid, w)
+		return nil
+	}
+
+	module, _ := config.Module(query.Get("module"))
+	host, port, _ := splitTarget(query.Get("target"))
+	// the addresses checked against the allowlist are probed, instead of resolving the target again
+	collector, err := NewModuleCollector(host, addrs, port, module)
+	if err != nil {
+		handleRequestErrors([]error{err}, w)
+		return nil
+	}
+	return collector
+}
 
+func reloadConfig(config *SafeConfig, configFile string) error {
+	if err := config.ReloadConfig(configFile); err != nil {
+		ConfigReloadSuccess.Set(0)
+		fmt.Fprintln(debugging.DebugOut, "msg", "Error reloading config", "err", err)
+		return err
+	}
+	ConfigReloadSuccess.Set(1)
+	ConfigReloadSeconds.SetToCurrentTime()
+	fmt.Fprintln(debugging.DebugOut, "msg", "Loaded config file", "file", configFile)
+	return nil
 }
 
-// RunHTTP starts a http server listening for exporter requests
-func RunHTTP(listenAddress string, connDialTimeout int) {
+// reloadConfigOnSIGHUP keeps reloading the configuration each time the process gets a SIGHUP
+func reloadConfigOnSIGHUP(reload func() error) {
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			reload()
+		}
+	}()
+}
+
+// serverShutdownTimeout bounds how long the exporter waits for requests being served when terminating
+const serverShutdownTimeout = 5 * time.Second
+
+// RunHTTP starts a http server listening for exporter requests, until ctx is done
+func RunHTTP(ctx context.Context, listenAddress string, opts Options) error {
 	prometheus.MustRegister(RequestMalformedErrors)
 	prometheus.MustRegister(RequestInvalidParamsErrors)
+	prometheus.MustRegister(RequestOverloadRejections)
+	prometheus.MustRegister(RequestRejections)
+
+	limiter := newProbeLimiter(opts.MaxConcurrentProbes, opts.MaxInFlightConnections, opts.MaxQueuedProbes)
+	prometheus.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "tcpgoon_inflight_probes",
+			Help: "Number of probes currently running",
+		},
+		func() float64 {
+			probes, _ := limiter.inFlight()
+			return float64(probes)
+		},
+	))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "tcpgoon_inflight_probe_connections",
+			Help: "Number of connections reserved by the probes currently running",
+		},
+		func() float64 {
+			_, connections := limiter.inFlight()
+			return float64(connections)
+		},
+	))
+
+	config := &SafeConfig{C: &Config{}}
+	if opts.ConfigFile != "" {
+		prometheus.MustRegister(ConfigReloadSuccess)
+		prometheus.MustRegister(ConfigReloadSeconds)
+
+		// configured targets are probed in the background, and their latest results served on /metrics
+		prober := NewBackgroundProber(ctx)
+		prober.limiter = limiter
+		prometheus.MustRegister(prober)
+		reload := func() error {
+			if err := reloadConfig(config, opts.ConfigFile); err != nil {
+				return err
+			}
+			prober.Sync(config)
+			return nil
+		}
+
+		if err := reload(); err != nil {
+			return err
+		}
+		reloadConfigOnSIGHUP(reload)
+
+		http.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				fmt.Fprintf(w, "This endpoint requires a POST request.\n")
+				return
+			}
+			if err := reload(); err != nil {
+				http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+			}
+		})
+	}
 
 	fmt.Fprintln(debugging.DebugOut, "msg", "registering handler /tcpgoon")
 	http.HandleFunc("/tcpgoon", func(w http.ResponseWriter, r *http.Request) {
-		tcpgoonRequestHandler(w, r, connDialTimeout)
+		tcpgoonRequestHandler(w, r, opts, config, limiter)
 	})
 
-	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/api/v1/probe", func(w http.ResponseWriter, r *http.Request) {
+		apiProbeHandler(w, r, opts, config, limiter)
+	})
+	http.HandleFunc("/results", func(w http.ResponseWriter, r *http.Request) {
+		resultsPageHandler(w, r, opts, config, limiter)
+	})
+
+	http.HandleFunc("/api/v1/probes/", probeDetailsHandler)
+
+	http.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})))
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write(webhtml)
 	})
 
+	server := &http.Server{
+		Addr:    listenAddress,
+		Handler: requireCredentials(opts.Credentials, http.DefaultServeMux),
+		// probes running when the exporter terminates are cancelled
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		fmt.Fprintln(debugging.DebugOut, "msg", "Stopping http server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
 	fmt.Fprintln(debugging.DebugOut, "msg", "Starting http server")
-	if err := http.ListenAndServe(listenAddress, nil); err != nil {
+	var err error
+	if opts.TLSCertFile != "" {
+		err = server.ListenAndServeTLS(opts.TLSCertFile, opts.TLSKeyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		fmt.Fprintln(debugging.DebugOut, "msg", "Error starting HTTP server", "err", err)
+		return err
 	}
+	return nil
 }
@@ -0,0 +1,109 @@
+package promexp
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestProbeContext(t *testing.T) {
+	var probeContextScenariosChecks = []struct {
+		scenarioDescription string
+		scrapeTimeoutHeader string
+		offset              time.Duration
+		expectedError       bool
+		expectedDeadline    bool
+		expectedTimeout     time.Duration
+	}{
+		{
+			scenarioDescription: "Requests without scrape timeout should not get a deadline",
+			expectedDeadline:    false,
+		},
+		{
+			scenarioDescription: "Scrape timeout minus the offset should be the probe deadline",
+			scrapeTimeoutHeader: "10",
+			offset:              500 * time.Millisecond,
+			expectedDeadline:    true,
+			expectedTimeout:     9500 * time.Millisecond,
+		},
+		{
+			scenarioDescription: "Offsets bigger than the scrape timeout should be ignored",
+			scrapeTimeoutHeader: "0.2",
+			offset:              500 * time.Millisecond,
+			expectedDeadline:    true,
+			expectedTimeout:     200 * time.Millisecond,
+		},
+		{
+			scenarioDescription: "Malformed scrape timeouts should be rejected",
+			scrapeTimeoutHeader: "ten",
+			expectedError:       true,
+		},
+	}
+
+	for _, test := range probeContextScenariosChecks {
+		r := httptest.NewRequest("GET", "/tcpgoon", nil)
+		if test.scrapeTimeoutHeader != "" {
+			r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", test.scrapeTimeoutHeader)
+		}
+		ctx, cancel, err := probeContext(r, test.offset)
+		if (err != nil) != test.expectedError {
+			t.Error(test.scenarioDescription+", and the error is:", err)
+			continue
+		}
+		if test.expectedError {
+			continue
+		}
+		deadline, hasDeadline := ctx.Deadline()
+		if hasDeadline != test.expectedDeadline {
+			t.Error(test.scenarioDescription+", and the deadline is:", deadline)
+		}
+		if hasDeadline {
+			if timeout := time.Until(deadline); timeout > test.expectedTimeout || timeout < test.expectedTimeout-time.Second {
+				t.Error(test.scenarioDescription+", and the timeout is:", timeout)
+			}
+		}
+		cancel()
+	}
+}
+
+func TestCheckQueryParamsLimits(t *testing.T) {
+	opts := Options{MaxConnectionsPerProbe: 100, MaxSleep: 50}
+	var limitsScenariosChecks = []struct {
+		scenarioDescription string
+		connections         string
+		sleep               string
+		expectedErrors      int
+	}{
+		{
+			scenarioDescription: "Params within the limits should be accepted",
+			connections:         "100",
+			sleep:               "50",
+			expectedErrors:      0,
+		},
+		{
+			scenarioDescription: "Too many connections should be rejected",
+			connections:         "101",
+			sleep:               "10",
+			expectedErrors:      1,
+		},
+		{
+			scenarioDescription: "Params over both limits should report both errors",
+			connections:         "1000",
+			sleep:               "1000",
+			expectedErrors:      2,
+		},
+	}
+
+	for _, test := range limitsScenariosChecks {
+		q := url.Values{"connections": {test.connections}, "sleep": {test.sleep}}
+		if errs := checkQueryParamsLimits(q, opts); len(errs) != test.expectedErrors {
+			t.Error(test.scenarioDescription+", and the errors are:", errs)
+		}
+	}
+
+	unlimited := url.Values{"connections": {"100000"}, "sleep": {"100000"}}
+	if errs := checkQueryParamsLimits(unlimited, Options{}); len(errs) != 0 {
+		t.Error("Params should not be limited when no limits are set, and the errors are:", errs)
+	}
+}
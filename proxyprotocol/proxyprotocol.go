@@ -0,0 +1,216 @@
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Header describes the original endpoints of a proxied connection. Nil addresses represent a
+// connection the proxy opened on its own behalf (i.e. health checks)
+type Header struct {
+	Version     int
+	Source      *net.TCPAddr
+	Destination *net.TCPAddr
+}
+
+// https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+const (
+	v1Prefix          = "PROXY "
+	v1MaxHeaderLength = 107
+	v2HeaderLength    = 16
+	v2VersionLocal    = 0x20
+	v2VersionProxy    = 0x21
+	v2FamilyUnspec    = 0x00
+	v2FamilyTCP4      = 0x11
+	v2FamilyTCP6      = 0x21
+	v2AddressesTCP4   = 12
+	v2AddressesTCP6   = 36
+)
+
+var v2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// ErrNoHeader is returned when the stream does not start with a PROXY protocol header
+var ErrNoHeader = errors.New("No PROXY protocol header found")
+
+// Format encodes the header in its wire representation
+func (h Header) Format() ([]byte, error) {
+	switch h.Version {
+	case 1:
+		return h.formatV1()
+	case 2:
+		return h.formatV2()
+	default:
+		return nil, fmt.Errorf("Unsupported PROXY protocol version %d", h.Version)
+	}
+}
+
+func (h Header) String() string {
+	if h.Source == nil || h.Destination == nil {
+		return fmt.Sprintf("PROXY v%d local", h.Version)
+	}
+	return fmt.Sprintf("PROXY v%d %s -> %s", h.Version, h.Source, h.Destination)
+}
+
+// addresses returns the source and destination IPs in the same family, and whether they are IPv4
+func (h Header) addresses() (src net.IP, dst net.IP, isV4 bool, err error) {
+	if h.Source == nil || h.Destination == nil {
+		return nil, nil, false, nil
+	}
+	if src, dst = h.Source.IP.To4(), h.Destination.IP.To4(); src != nil && dst != nil {
+		return src, dst, true, nil
+	}
+	if h.Source.IP.To4() != nil || h.Destination.IP.To4() != nil {
+		return nil, nil, false, errors.New("PROXY protocol source and destination addresses must belong to the same family")
+	}
+	return h.Source.IP.To16(), h.Destination.IP.To16(), false, nil
+}
+
+func (h Header) formatV1() ([]byte, error) {
+	src, dst, isV4, err := h.addresses()
+	if err != nil {
+		return nil, err
+	}
+	if src == nil {
+		return []byte(v1Prefix + "UNKNOWN\r\n"), nil
+	}
+	family := "TCP6"
+	if isV4 {
+		family = "TCP4"
+	}
+	return []byte(fmt.Sprintf("%s%s %s %s %d %d\r\n", v1Prefix, family, src, dst,
+		h.Source.Port, h.Destination.Port)), nil
+}
+
+func (h Header) formatV2() ([]byte, error) {
+	src, dst, isV4, err := h.addresses()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(v2Signature)
+	switch {
+	case src == nil:
+		buf.Write([]byte{v2VersionLocal, v2FamilyUnspec, 0, 0})
+		return buf.Bytes(), nil
+	case isV4:
+		buf.Write([]byte{v2VersionProxy, v2FamilyTCP4})
+		binary.Write(&buf, binary.BigEndian, uint16(v2AddressesTCP4))
+	default:
+		buf.Write([]byte{v2VersionProxy, v2FamilyTCP6})
+		binary.Write(&buf, binary.BigEndian, uint16(v2AddressesTCP6))
+	}
+	buf.Write(src)
+	buf.Write(dst)
+	binary.Write(&buf, binary.BigEndian, uint16(h.Source.Port))
+	binary.Write(&buf, binary.BigEndian, uint16(h.Destination.Port))
+	return buf.Bytes(), nil
+}
+
+// Read consumes a PROXY protocol header, of any version, from the beginning of the stream.
+// ErrNoHeader is returned when the stream starts with something else
+func Read(r *bufio.Reader) (*Header, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case v1Prefix[0]:
+		return readV1(r)
+	case v2Signature[0]:
+		return readV2(r)
+	default:
+		return nil, ErrNoHeader
+	}
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < v1MaxHeaderLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasPrefix(line, []byte(v1Prefix)) {
+		return nil, ErrNoHeader
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("PROXY v1 header is not properly terminated")
+	}
+
+	fields := strings.Fields(string(line[len(v1Prefix):]))
+	if len(fields) > 0 && fields[0] == "UNKNOWN" {
+		return &Header{Version: 1}, nil
+	}
+	if len(fields) != 5 || (fields[0] != "TCP4" && fields[0] != "TCP6") {
+		return nil, errors.New("Malformed PROXY v1 header: " + strings.TrimSpace(string(line)))
+	}
+	src, err := parseV1Address(fields[1], fields[3])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Address(fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	return &Header{Version: 1, Source: src, Destination: dst}, nil
+}
+
+func parseV1Address(ip string, port string) (*net.TCPAddr, error) {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return nil, errors.New("Invalid address in PROXY v1 header: " + ip)
+	}
+	parsedPort, err := strconv.Atoi(port)
+	if err != nil || parsedPort < 0 || parsedPort > 65535 {
+		return nil, errors.New("Invalid port in PROXY v1 header: " + port)
+	}
+	return &net.TCPAddr{IP: parsedIP, Port: parsedPort}, nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, v2HeaderLength)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(fixed[:len(v2Signature)], v2Signature) {
+		return nil, ErrNoHeader
+	}
+	versionAndCommand, family := fixed[12], fixed[13]
+	if versionAndCommand&0xF0 != 0x20 {
+		return nil, fmt.Errorf("Unsupported PROXY v2 version byte 0x%x", versionAndCommand)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	header := &Header{Version: 2}
+	if versionAndCommand == v2VersionLocal {
+		return header, nil
+	}
+	switch {
+	case family == v2FamilyTCP4 && len(payload) >= v2AddressesTCP4:
+		header.Source = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		header.Destination = &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+	case family == v2FamilyTCP6 && len(payload) >= v2AddressesTCP6:
+		header.Source = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		header.Destination = &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+	case family == v2FamilyUnspec:
+	default:
+		return nil, fmt.Errorf("Unsupported PROXY v2 address family 0x%x", family)
+	}
+	return header, nil
+}
@@ -0,0 +1,78 @@
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	var roundTripScenariosChecks = []struct {
+		scenarioDescription string
+		header              Header
+		expectedV1          string
+	}{
+		{
+			scenarioDescription: "IPv4 addresses should be encoded as TCP4",
+			header: Header{
+				Source:      &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 40000},
+				Destination: &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 443},
+			},
+			expectedV1: "PROXY TCP4 10.1.2.3 192.168.0.1 40000 443\r\n",
+		},
+		{
+			scenarioDescription: "IPv6 addresses should be encoded as TCP6",
+			header: Header{
+				Source:      &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 40000},
+				Destination: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 80},
+			},
+			expectedV1: "PROXY TCP6 2001:db8::1 2001:db8::2 40000 80\r\n",
+		},
+		{
+			scenarioDescription: "Headers without addresses should be encoded as local/unknown",
+			header:              Header{},
+			expectedV1:          "PROXY UNKNOWN\r\n",
+		},
+	}
+
+	for _, test := range roundTripScenariosChecks {
+		for _, version := range []int{1, 2} {
+			header := test.header
+			header.Version = version
+			encoded, err := header.Format()
+			if err != nil {
+				t.Fatal(test.scenarioDescription+", but it could not be formatted:", err)
+			}
+			if version == 1 && string(encoded) != test.expectedV1 {
+				t.Error(test.scenarioDescription+", and it is:", string(encoded))
+			}
+
+			decoded, err := Read(bufio.NewReader(bytes.NewReader(append(encoded, []byte("payload")...))))
+			if err != nil {
+				t.Fatal(test.scenarioDescription+", but it could not be read back:", err)
+			}
+			if decoded.String() != header.String() {
+				t.Error(test.scenarioDescription+", and v", version, "was read back as:", decoded)
+			}
+		}
+	}
+}
+
+func TestReadWithoutHeader(t *testing.T) {
+	reader := bufio.NewReader(bytes.NewReader([]byte("GET / HTTP/1.1\r\n")))
+	if _, err := Read(reader); err != ErrNoHeader {
+		t.Error("Streams without a PROXY header should be reported as such, and the error is:", err)
+	}
+}
+
+func TestFormatMixedFamilies(t *testing.T) {
+	header := Header{
+		Version:     2,
+		Source:      &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 40000},
+		Destination: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 80},
+	}
+	if _, err := header.Format(); err == nil {
+		t.Error("Mixing IPv4 and IPv6 addresses should not be allowed")
+	}
+}
@@ -0,0 +1,107 @@
+package tcpclient
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// BackendExtractor tells how to identify the backend serving each connection, so the way a load balancer
+// spreads them can be analysed
+type BackendExtractor struct {
+	// FirstLine identifies backends by the first line they send, as the banner protocol reads it
+	FirstLine bool
+	// Pattern identifies backends by its first submatch, or its whole match, on the first line they send
+	Pattern *regexp.Regexp
+	// Header identifies backends by a header of their responses, for the protocols running over HTTP
+	Header string
+}
+
+// maxBackendLength bounds the backend identities kept, as they are aggregated across all the connections
+const maxBackendLength = 128
+
+// backendFromLineProtocols and backendFromHeaderProtocols are the protocols each kind of extractor works with
+var (
+	backendFromLineProtocols   = []string{"banner"}
+	backendFromHeaderProtocols = []string{"http", "h2", "grpc", "websocket"}
+)
+
+// ParseBackendExtractor parses the description of an extractor: first-line, banner:<regular expression>
+// or header:<name>
+func ParseBackendExtractor(description string) (BackendExtractor, error) {
+	var extractor BackendExtractor
+	kind := strings.SplitN(description, ":", 2)
+	switch {
+	case description == "":
+	case description == "first-line":
+		extractor.FirstLine = true
+	case kind[0] == "banner" && len(kind) == 2 && kind[1] != "":
+		pattern, err := regexp.Compile(kind[1])
+		if err != nil {
+			return extractor, err
+		}
+		extractor.Pattern = pattern
+	case kind[0] == "header" && len(kind) == 2 && kind[1] != "":
+		extractor.Header = http.CanonicalHeaderKey(kind[1])
+	default:
+		return extractor, errors.New("Backend extractor " + description +
+			" should be first-line, banner:<regular expression> or header:<name>")
+	}
+	return extractor, nil
+}
+
+// Enabled returns true if the extractor identifies backends
+func (e BackendExtractor) Enabled() bool {
+	return e.FirstLine || e.Pattern != nil || e.Header != ""
+}
+
+// ValidateProtocol checks connections probed with protocol can identify their backends with the extractor
+func (e BackendExtractor) ValidateProtocol(protocol string) error {
+	if !e.Enabled() {
+		return nil
+	}
+	protocols := backendFromLineProtocols
+	if e.Header != "" {
+		protocols = backendFromHeaderProtocols
+	}
+	for _, supported := range protocols {
+		if protocol == supported {
+			return nil
+		}
+	}
+	return errors.New("Backends can only be identified this way with the protocols: " + strings.Join(protocols, ", "))
+}
+
+func (e BackendExtractor) fromLine(line string) string {
+	switch {
+	case e.Pattern != nil:
+		match := e.Pattern.FindStringSubmatch(line)
+		if len(match) > 1 {
+			return match[1]
+		} else if len(match) == 1 {
+			return match[0]
+		}
+	case e.FirstLine:
+		return line
+	}
+	return ""
+}
+
+func (e BackendExtractor) fromHeader(header http.Header) string {
+	if e.Header == "" {
+		return ""
+	}
+	return header.Get(e.Header)
+}
+
+// identifyBackend records the backend serving the connection, if the extractor could tell
+func (c *ProbeConn) identifyBackend(backend string) {
+	if backend == "" {
+		return
+	}
+	if len(backend) > maxBackendLength {
+		backend = backend[:maxBackendLength]
+	}
+	c.backend = backend
+}
@@ -0,0 +1,101 @@
+package tcpclient
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBackendExtractors(t *testing.T) {
+	const port = 55572
+	var backendScenariosChecks = []struct {
+		scenarioDescription string
+		protocol            string
+		extractor           string
+		handle              func(net.Conn)
+		expectedBackend     string
+	}{
+		{
+			scenarioDescription: "First line extractor should identify backends by their whole banner",
+			protocol:            "banner",
+			extractor:           "first-line",
+			handle:              greetingWith("SSH-2.0-OpenSSH_9.6 bastion-2\r\n"),
+			expectedBackend:     "SSH-2.0-OpenSSH_9.6 bastion-2",
+		},
+		{
+			scenarioDescription: "Banner extractor should identify backends by the first submatch of the pattern",
+			protocol:            "banner",
+			extractor:           `banner:^220 (\S+) ESMTP`,
+			handle:              greetingWith("220 mx-3.example.com ESMTP Postfix\r\n"),
+			expectedBackend:     "mx-3.example.com",
+		},
+		{
+			scenarioDescription: "Banner extractor should not identify backends which banner does not match",
+			protocol:            "banner",
+			extractor:           `banner:^220 (\S+) ESMTP`,
+			handle:              greetingWith("SSH-2.0-OpenSSH_9.6\r\n"),
+		},
+		{
+			scenarioDescription: "Header extractor should identify backends by the header of their responses",
+			protocol:            "http",
+			extractor:           "header:x-served-by",
+			handle:              replyingWith("HTTP/1.1 200 OK\r\nX-Served-By: web-7\r\nContent-Length: 0\r\n\r\n"),
+			expectedBackend:     "web-7",
+		},
+		{
+			scenarioDescription: "Header extractor should identify backends by the header of their HTTP/2 responses",
+			protocol:            "h2",
+			extractor:           "header:X-Served-By",
+			handle: servingHTTP2(func(conn *ProbeConn, streamID uint32) {
+				writeHTTP2Frame(conn, http2FrameHeaders, http2FlagEndHeaders|http2FlagEndStream, streamID,
+					encodeHeaders(":status", "200", "x-served-by", "web-8"))
+			}),
+			expectedBackend: "web-8",
+		},
+	}
+
+	for _, test := range backendScenariosChecks {
+		extractor, err := ParseBackendExtractor(test.extractor)
+		if err != nil || extractor.ValidateProtocol(test.protocol) != nil {
+			t.Error(test.scenarioDescription+", and its extractor is not valid:", err)
+			continue
+		}
+		opts := DefaultConnectOptions()
+		opts.DialTimeout = time.Second
+		opts.Protocol = test.protocol
+		opts.Backend = extractor
+		stop := serveFake(t, port, test.handle)
+		connection := probeOnceWithOptions(port, opts)
+		stop()
+
+		if connection.GetConnectionStatus() != ConnectionEstablished || connection.GetBackend() != test.expectedBackend {
+			t.Error(test.scenarioDescription+", and the connection is:", connection, "served by", connection.GetBackend())
+		}
+	}
+}
+
+func TestParseBackendExtractor(t *testing.T) {
+	var parseScenariosChecks = []struct {
+		scenarioDescription string
+		extractor           string
+		protocol            string
+		expectedValid       bool
+	}{
+		{"Header extractor should be valid for HTTP based protocols", "header:X-Backend", "grpc", true},
+		{"Header extractor should not be valid for protocols not running over HTTP", "header:X-Backend", "banner", false},
+		{"Banner extractor should not be valid with invalid regular expressions", "banner:(", "banner", false},
+		{"Banner extractor should not be valid for protocols not reading banners", "banner:.*", "tcp", false},
+		{"Unknown extractors should not be valid", "cookie:SERVERID", "http", false},
+		{"No extractor should be valid for any protocol", "", "tcp", true},
+	}
+
+	for _, test := range parseScenariosChecks {
+		extractor, err := ParseBackendExtractor(test.extractor)
+		if err == nil {
+			err = extractor.ValidateProtocol(test.protocol)
+		}
+		if (err == nil) != test.expectedValid {
+			t.Error(test.scenarioDescription+", and it is:", err)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package tcpclient
+
+import (
+	"errors"
+	"regexp"
+)
+
+// bannerProbe reads the first line servers like SSH, SMTP or FTP ones send when accepting connections
+type bannerProbe struct {
+	match *regexp.Regexp
+}
+
+func (p *bannerProbe) Handshake(conn *ProbeConn) error {
+	banner, err := readReplyLine(conn)
+	if err != nil {
+		return err
+	}
+	conn.SetBanner(banner)
+	return nil
+}
+
+func (p *bannerProbe) Request(conn *ProbeConn) error { return nil }
+
+func (p *bannerProbe) Validate(conn *ProbeConn) error {
+	if p.match != nil && !p.match.MatchString(conn.banner) {
+		return errors.New("Banner " + conn.banner + " does not match " + p.match.String())
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package tcpclient
+
+import (
+	"net"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// greetingWith handles connections sending banner as soon as they are accepted
+func greetingWith(banner string) func(net.Conn) {
+	return func(conn net.Conn) {
+		conn.Write([]byte(banner))
+		conn.Read(make([]byte, 1))
+	}
+}
+
+func TestBannerProbe(t *testing.T) {
+	const port = 55569
+	var bannerScenariosChecks = []struct {
+		scenarioDescription string
+		handle              func(net.Conn)
+		match               *regexp.Regexp
+		expectedStatus      ConnectionStatus
+		expectedReason      FailureReason
+		expectedBanner      string
+	}{
+		{
+			scenarioDescription: "SSH servers should get connections established, recording their banner",
+			handle:              greetingWith("SSH-2.0-OpenSSH_9.6\r\n"),
+			expectedStatus:      ConnectionEstablished,
+			expectedBanner:      "SSH-2.0-OpenSSH_9.6",
+		},
+		{
+			scenarioDescription: "SMTP servers with a banner matching the pattern should get connections established",
+			handle:              greetingWith("220 mail.example.com ESMTP Postfix\r\n"),
+			match:               regexp.MustCompile(`^220 .*ESMTP`),
+			expectedStatus:      ConnectionEstablished,
+			expectedBanner:      "220 mail.example.com ESMTP Postfix",
+		},
+		{
+			scenarioDescription: "FTP servers with a banner not matching the pattern should fail as an unexpected response",
+			handle:              greetingWith("421 Too many connections (10) from this IP\r\n"),
+			match:               regexp.MustCompile(`^220 `),
+			expectedStatus:      ConnectionError,
+			expectedReason:      FailureUnexpectedResponse,
+			expectedBanner:      "421 Too many connections (10) from this IP",
+		},
+		{
+			scenarioDescription: "Servers not sending any banner should fail as a timeout",
+			handle:              func(conn net.Conn) { conn.Read(make([]byte, 1)) },
+			expectedStatus:      ConnectionError,
+			expectedReason:      FailureTimeout,
+		},
+	}
+
+	for _, test := range bannerScenariosChecks {
+		opts := DefaultConnectOptions()
+		opts.DialTimeout = 200 * time.Millisecond
+		opts.Protocol = "banner"
+		opts.BannerMatch = test.match
+		stop := serveFake(t, port, test.handle)
+		connection := probeOnceWithOptions(port, opts)
+		stop()
+
+		outcome := connection.GetProbeOutcome()
+		if connection.GetConnectionStatus() != test.expectedStatus || connection.GetFailureReason() != test.expectedReason ||
+			outcome.Banner() != test.expectedBanner {
+			t.Error(test.scenarioDescription+", and it is:", connection.GetConnectionStatus(),
+				connection.GetFailureReason(), outcome.Banner())
+		}
+		if _, ok := outcome.TimeToFirstByte(); test.expectedStatus == ConnectionEstablished && !ok {
+			t.Error(test.scenarioDescription + ", and it does not report when the banner arrived")
+		}
+	}
+}
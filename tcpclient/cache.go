@@ -0,0 +1,73 @@
+package tcpclient
+
+import (
+	"errors"
+	"strings"
+)
+
+// redisMaxClientsError is what Redis replies to the connections over its maxclients setting
+const redisMaxClientsError = "-ERR max number of clients reached"
+
+// memcachedMaxConnsError is what memcached sends to the connections over its maxconns setting, before closing them
+const memcachedMaxConnsError = "ERROR Too many open connections"
+
+// redisProbe sends a PING command, using the RESP protocol, expecting a PONG back
+type redisProbe struct{}
+
+func (p *redisProbe) Handshake(conn *ProbeConn) error { return nil }
+
+func (p *redisProbe) Request(conn *ProbeConn) error {
+	_, err := conn.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+	return err
+}
+
+func (p *redisProbe) Validate(conn *ProbeConn) error {
+	reply, err := readReplyLine(conn)
+	if err != nil {
+		return err
+	}
+	switch {
+	case reply == "+PONG":
+		return nil
+	case strings.HasPrefix(reply, redisMaxClientsError):
+		return failure{FailureTooManyClients, errors.New(reply)}
+	}
+	return errors.New("Unexpected reply to PING: " + reply)
+}
+
+// memcachedProbe sends a version command, using the memcached text protocol
+type memcachedProbe struct{}
+
+func (p *memcachedProbe) Handshake(conn *ProbeConn) error { return nil }
+
+func (p *memcachedProbe) Request(conn *ProbeConn) error {
+	_, err := conn.Write([]byte("version\r\n"))
+	return err
+}
+
+func (p *memcachedProbe) Validate(conn *ProbeConn) error {
+	reply, err := readReplyLine(conn)
+	if err != nil {
+		return err
+	}
+	switch {
+	case strings.HasPrefix(reply, "VERSION "):
+		return nil
+	case strings.HasPrefix(reply, memcachedMaxConnsError):
+		return failure{FailureTooManyClients, errors.New(reply)}
+	}
+	return errors.New("Unexpected reply to version: " + reply)
+}
+
+// readReplyLine reads a line terminated protocol reply, without its line terminator
+func readReplyLine(conn *ProbeConn) (string, error) {
+	if _, err := conn.Reader.Peek(1); err != nil {
+		return "", err
+	}
+	conn.MarkFirstByte()
+	line, err := conn.Reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,88 @@
+package tcpclient
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+// replyingWith handles connections reading a request line, and replying it with reply
+func replyingWith(reply string) func(net.Conn) {
+	return func(conn net.Conn) {
+		bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte(reply))
+		conn.Read(make([]byte, 1))
+	}
+}
+
+func TestCacheProbes(t *testing.T) {
+	const port = 55567
+	var cacheScenariosChecks = []struct {
+		scenarioDescription string
+		protocol            string
+		handle              func(net.Conn)
+		expectedStatus      ConnectionStatus
+		expectedReason      FailureReason
+	}{
+		{
+			scenarioDescription: "Redis replying PONG should get connections established",
+			protocol:            "redis",
+			handle: func(conn net.Conn) {
+				reader := bufio.NewReader(conn)
+				for _, expected := range []string{"*1\r\n", "$4\r\n", "PING\r\n"} {
+					if line, _ := reader.ReadString('\n'); line != expected {
+						conn.Write([]byte("-ERR unknown command\r\n"))
+						return
+					}
+				}
+				conn.Write([]byte("+PONG\r\n"))
+				conn.Read(make([]byte, 1))
+			},
+			expectedStatus: ConnectionEstablished,
+		},
+		{
+			scenarioDescription: "Redis over its maxclients should fail as too many clients",
+			protocol:            "redis",
+			handle:              replyingWith("-ERR max number of clients reached\r\n"),
+			expectedStatus:      ConnectionError,
+			expectedReason:      FailureTooManyClients,
+		},
+		{
+			scenarioDescription: "Redis requiring authentication should fail as an unexpected response",
+			protocol:            "redis",
+			handle:              replyingWith("-NOAUTH Authentication required.\r\n"),
+			expectedStatus:      ConnectionError,
+			expectedReason:      FailureUnexpectedResponse,
+		},
+		{
+			scenarioDescription: "Memcached replying its version should get connections established",
+			protocol:            "memcached",
+			handle:              replyingWith("VERSION 1.6.21\r\n"),
+			expectedStatus:      ConnectionEstablished,
+		},
+		{
+			scenarioDescription: "Memcached over its maxconns should fail as too many clients",
+			protocol:            "memcached",
+			handle: func(conn net.Conn) {
+				conn.Write([]byte("ERROR Too many open connections\r\n"))
+			},
+			expectedStatus: ConnectionError,
+			expectedReason: FailureTooManyClients,
+		},
+	}
+
+	for _, test := range cacheScenariosChecks {
+		stop := serveFake(t, port, test.handle)
+		connection := probeOnce(port, test.protocol, time.Second)
+		stop()
+
+		if connection.GetConnectionStatus() != test.expectedStatus || connection.GetFailureReason() != test.expectedReason {
+			t.Error(test.scenarioDescription+", and the connection is:", connection)
+			continue
+		}
+		if _, ok := connection.GetProbeOutcome().TimeToFirstByte(); test.expectedStatus == ConnectionEstablished && !ok {
+			t.Error(test.scenarioDescription + ", reporting the command latency")
+		}
+	}
+}
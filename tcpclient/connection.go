@@ -9,13 +9,22 @@ type Connection struct {
 	ID      int
 	status  ConnectionStatus
 	metrics connectionMetrics
+	// address is the IP the connection was established with, or the host it was dialing when failed
+	address       string
+	failureReason FailureReason
+	// localPort the connection was dialed from, once established
+	localPort int
+	probe     ProbeOutcome
 }
 
 type ConnectionStatus int
 
 type connectionMetrics struct {
+	// tcpEstablishedDuration covers the TCP handshake only, TLS and the protocol probe being timed apart
 	tcpEstablishedDuration time.Duration
 	tcpErroredDuration     time.Duration
+	// tlsHandshakeDuration is how long negotiating TLS took, 0 when not negotiated
+	tlsHandshakeDuration time.Duration
 	// packets lost, retransmissions and other metrics could come
 }
 
@@ -28,6 +37,15 @@ const (
 	ConnectionError
 )
 
+// ConnectionStatuses lists all the statuses a connection can be in, in the order they happen
+var ConnectionStatuses = []ConnectionStatus{
+	ConnectionNotInitiated,
+	ConnectionDialing,
+	ConnectionEstablished,
+	ConnectionClosed,
+	ConnectionError,
+}
+
 // ConnectionFunc type to use connection functions as an argument
 type ConnectionFunc func(Connection) bool
 
@@ -45,35 +63,74 @@ func NewConnection(id int, status ConnectionStatus, procTime time.Duration) Conn
 
 }
 
+// NewProbedConnection initializes a connection as NewConnection, plus the outcome of its probe. Used by tests too
+func NewProbedConnection(id int, status ConnectionStatus, procTime time.Duration, outcome ProbeOutcome) Connection {
+	connection := NewConnection(id, status, procTime)
+	connection.probe = outcome
+	return connection
+}
+
 func (c Connection) GetConnectionStatus() ConnectionStatus {
 	return c.status
 }
 
-func (c Connection) String() string {
-	var status string
-	switch c.status {
+// GetAddress returns the IP the connection was established with, or the host it was dialing if it failed
+func (c Connection) GetAddress() string {
+	return c.address
+}
+
+// GetFailureReason returns why the connection ended up in error, if it did
+func (c Connection) GetFailureReason() FailureReason {
+	return c.failureReason
+}
+
+// GetLocalPort returns the local port the connection was dialed from, or 0 if it did not get established
+func (c Connection) GetLocalPort() int {
+	return c.localPort
+}
+
+// GetBackend returns the identity of the backend that served the connection, if it could be identified
+func (c Connection) GetBackend() string {
+	return c.probe.Backend()
+}
+
+// GetProbeOutcome returns how the probe of the application protocol went, if the connection was probed
+func (c Connection) GetProbeOutcome() ProbeOutcome {
+	return c.probe
+}
+
+func (s ConnectionStatus) String() string {
+	switch s {
 	case ConnectionNotInitiated:
-		status = "not initiated"
+		return "not initiated"
 	case ConnectionDialing:
-		status = "dialing"
+		return "dialing"
 	case ConnectionEstablished:
-		status = "established"
+		return "established"
 	case ConnectionClosed:
-		status = "closed"
+		return "closed"
 	case ConnectionError:
-		status = "errored"
+		return "errored"
 	}
+	return ""
+}
+
+func (c Connection) String() string {
+	status := c.status.String()
 
 	switch c.status {
 	case ConnectionEstablished:
 		return fmt.Sprintf("Connection %d has become %s after %s", c.ID, status, c.metrics.tcpEstablishedDuration)
+	case ConnectionError:
+		return fmt.Sprintf("Connection %d is %s (%s)", c.ID, status, c.failureReason)
 	default:
 		return fmt.Sprintf("Connection %d is %s", c.ID, status)
 	}
 
 }
 
-// GetTCPProcessingDuration returns the time spent processing the connection
+// GetTCPProcessingDuration returns the time the TCP handshake took for the connections that went well, or
+// the time until they failed otherwise
 func (c Connection) GetTCPProcessingDuration() time.Duration {
 	if WentOk(c) {
 		return c.metrics.tcpEstablishedDuration
@@ -81,6 +138,11 @@ func (c Connection) GetTCPProcessingDuration() time.Duration {
 	return c.metrics.tcpErroredDuration
 }
 
+// GetTLSHandshakeDuration returns how long negotiating TLS took, and false if it was not negotiated
+func (c Connection) GetTLSHandshakeDuration() (time.Duration, bool) {
+	return c.metrics.tlsHandshakeDuration, c.metrics.tlsHandshakeDuration > 0
+}
+
 func (c Connection) isStatusIn(statuses []ConnectionStatus) bool {
 	for _, s := range statuses {
 		if c.GetConnectionStatus() == s {
@@ -105,7 +167,7 @@ func WithError(c Connection) bool {
 	return c.isStatusIn([]ConnectionStatus{ConnectionError})
 }
 
-//PendingToProcess return true when the Connection is Established or Closed state
+// PendingToProcess return true when the Connection is Established or Closed state
 func PendingToProcess(c Connection) bool {
 	return c.isStatusIn([]ConnectionStatus{ConnectionNotInitiated, ConnectionDialing})
 }
@@ -2,6 +2,8 @@ package tcpclient
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"reflect"
@@ -19,60 +21,164 @@ func reportConnectionStatus(statusChannel chan<- Connection, connectionDescripti
 	fmt.Fprintln(debugging.DebugOut, "\t", connectionDescription)
 }
 
+// dialUnlessClosed dials address, giving up as soon as a close request arrives
+func dialUnlessClosed(address string, opts ConnectOptions, closeRequest <-chan bool) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dialed := make(chan bool)
+	closeRequested := make(chan bool, 1)
+	go func() {
+		select {
+		case <-closeRequest:
+			closeRequested <- true
+			cancel()
+		case <-dialed:
+			closeRequested <- false
+		}
+	}()
+
+	dialer := net.Dialer{Timeout: opts.DialTimeout, KeepAlive: opts.KeepAlive}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	close(dialed)
+	// the close request may have been consumed while dialing, so it has to be honoured here
+	if <-closeRequested {
+		if err == nil {
+			conn.Close()
+		}
+		return nil, failure{FailureCancelled, errors.New("Connection closure requested while dialing")}
+	}
+	return conn, err
+}
+
 // TCPConnect just opens a TCP connection against the target described by
 // the host:port, and considers the id to report back status changes through the
 // status goChannel with descriptors matching the Connection struct supplied in this
 // same package.
 func TCPConnect(id int, host string, port int, wg *sync.WaitGroup,
+	statusChannel chan<- Connection, closeRequest <-chan bool) error {
+	return TCPConnectWithOptions(id, host, port, DefaultConnectOptions(), wg, statusChannel, closeRequest)
+}
+
+// TCPConnectWithOptions behaves as TCPConnect, but opening and exercising the connection
+// as described by opts rather than the package defaults
+func TCPConnectWithOptions(id int, host string, port int, opts ConnectOptions, wg *sync.WaitGroup,
 	statusChannel chan<- Connection, closeRequest <-chan bool) error {
 	connectionDescription := Connection{
 		ID:      id,
 		status:  ConnectionDialing,
+		address: host,
 		metrics: connectionMetrics{},
 	}
 	reportConnectionStatus(statusChannel, connectionDescription)
 	timeTCPInitiatied := time.Now()
-	conn, err := net.DialTimeout("tcp", host+":"+strconv.Itoa(port),
-		time.Duration(DefaultDialTimeoutInMs)*time.Millisecond)
+	conn, err := dialUnlessClosed(net.JoinHostPort(host, strconv.Itoa(port)), opts, closeRequest)
+	var connBuf *bufio.Reader
+	if err == nil {
+		// the connection counts as established since the handshake completes, so TLS and the probe are timed apart
+		connectionDescription.metrics.tcpEstablishedDuration = time.Now().Sub(timeTCPInitiatied)
+		if remoteAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			connectionDescription.address = remoteAddr.IP.String()
+		}
+		if localAddr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+			connectionDescription.localPort = localAddr.Port
+		}
+		conn, connBuf, connectionDescription.metrics.tlsHandshakeDuration, err = prepareConnection(conn, host, opts)
+	}
+	var session *probeSession
+	if err == nil {
+		session, err = runProbe(conn, connBuf, host, port, opts)
+		if session != nil {
+			connectionDescription.probe = session.outcome
+		}
+	}
 	if err != nil {
 		connectionDescription.metrics.tcpErroredDuration = time.Now().Sub(timeTCPInitiatied)
 		connectionDescription.status = ConnectionError
+		connectionDescription.failureReason = classifyFailure(err)
 		reportConnectionStatus(statusChannel, connectionDescription)
 		fmt.Fprintln(debugging.DebugOut, "Connection", id, "was unable to open the connection. Error:")
 		fmt.Fprintln(debugging.DebugOut, err)
 		wg.Done()
 		return err
 	}
-	connectionDescription.metrics.tcpEstablishedDuration = time.Now().Sub(timeTCPInitiatied)
 	defer conn.Close()
 	connectionDescription.status = ConnectionEstablished
 	reportConnectionStatus(statusChannel, connectionDescription)
-	connBuf := bufio.NewReader(conn)
-	for {
+	// closure requests interrupt any pending read, so connections are released promptly
+	closing := make(chan bool)
+	finished := make(chan bool)
+	defer close(finished)
+	go func() {
 		select {
 		case <-closeRequest:
+			close(closing)
+			conn.Close()
+		case <-finished:
+		}
+	}()
+	if session != nil && opts.RepeatInterval > 0 && repeatableProtocols[opts.Protocol] {
+		return repeatProbeUntilClosed(session, opts.RepeatInterval, connectionDescription, wg, statusChannel, closing)
+	}
+	for {
+		const ReadTimeoutAndBetweenPollsInMs = 1000
+		conn.SetReadDeadline(time.Now().Add(time.Duration(ReadTimeoutAndBetweenPollsInMs) * time.Millisecond))
+		str, err := connBuf.ReadString('\n')
+		select {
+		case <-closing:
 			fmt.Fprintln(debugging.DebugOut, "Connection", id, "is being requested to close")
 			// we don't mark connection as closed, as its us closing cleanly at the end of the execution,
 			//  so final report can consider it was established when finishing and not closed by the other end
 			wg.Done()
 			return nil
 		default:
-			const ReadTimeoutAndBetweenPollsInMs = 1000
-			conn.SetReadDeadline(time.Now().Add(time.Duration(ReadTimeoutAndBetweenPollsInMs) * time.Millisecond))
-			str, err := connBuf.ReadString('\n')
-			if terr, ok := err.(net.Error); ok && terr.Timeout() {
-				fmt.Fprintln(debugging.DebugOut, "No info from connection", id, "before timing out. Reading again...")
-			} else if err != nil {
-				fmt.Fprintln(debugging.DebugOut, "Connection", id, "looks closed. Error", reflect.TypeOf(err), "when reading:")
-				fmt.Fprintln(debugging.DebugOut, err)
-				connectionDescription.status = ConnectionClosed
-				reportConnectionStatus(statusChannel, connectionDescription)
-				wg.Done()
-				return err
-			} else if len(str) > 0 {
-				fmt.Fprintln(debugging.DebugOut, "Connection", id, "got", str)
-			}
 		}
+		if terr, ok := err.(net.Error); ok && terr.Timeout() {
+			fmt.Fprintln(debugging.DebugOut, "No info from connection", id, "before timing out. Reading again...")
+		} else if err != nil {
+			fmt.Fprintln(debugging.DebugOut, "Connection", id, "looks closed. Error", reflect.TypeOf(err), "when reading:")
+			fmt.Fprintln(debugging.DebugOut, err)
+			connectionDescription.status = ConnectionClosed
+			reportConnectionStatus(statusChannel, connectionDescription)
+			wg.Done()
+			return err
+		} else if len(str) > 0 {
+			fmt.Fprintln(debugging.DebugOut, "Connection", id, "got", str)
+		}
+	}
+}
 
+// repeatProbeUntilClosed keeps issuing the probe requests on the held connection, reporting their outcome.
+// The connection is considered closed as soon as a request fails
+func repeatProbeUntilClosed(session *probeSession, interval time.Duration, connectionDescription Connection,
+	wg *sync.WaitGroup, statusChannel chan<- Connection, closing <-chan bool) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closing:
+			fmt.Fprintln(debugging.DebugOut, "Connection", connectionDescription.ID, "is being requested to close")
+			wg.Done()
+			return nil
+		case <-ticker.C:
+		}
+		err := session.repeat()
+		select {
+		case <-closing:
+			// the failure, if any, comes from us closing the connection
+			fmt.Fprintln(debugging.DebugOut, "Connection", connectionDescription.ID, "is being requested to close")
+			wg.Done()
+			return nil
+		default:
+		}
+		connectionDescription.probe = session.outcome
+		if err != nil {
+			fmt.Fprintln(debugging.DebugOut, "Connection", connectionDescription.ID, "failed a repeated probe request:", err)
+			connectionDescription.status = ConnectionClosed
+			connectionDescription.failureReason = classifyFailure(err)
+			reportConnectionStatus(statusChannel, connectionDescription)
+			wg.Done()
+			return err
+		}
+		reportConnectionStatus(statusChannel, connectionDescription)
 	}
 }
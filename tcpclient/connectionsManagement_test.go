@@ -1,6 +1,8 @@
 package tcpclient
 
 import (
+	"context"
+	"crypto/tls"
 	"sync"
 	"testing"
 	"time"
@@ -20,15 +22,11 @@ func TestTCPConnectEstablished(t *testing.T) {
 		Lock:     sync.RWMutex{},
 	}
 
-	runTCPServer := func() {
-		t.Log("Starting TCP server...")
-		if err := dispatcher.ListenHandlers(port); err != nil {
-			t.Fatal("Could not start the TCP server", err)
-			return
-		}
+	t.Log("Starting TCP server...")
+	if err := dispatcher.Start(context.Background(), []int{port}); err != nil {
+		t.Fatal("Could not start the TCP server", err)
 	}
-	go runTCPServer()
-	time.Sleep(500 * time.Millisecond)
+	defer dispatcher.Shutdown(context.Background())
 
 	defer func() {
 		err := recover()
@@ -61,6 +59,9 @@ func TestTCPConnectEstablished(t *testing.T) {
 	} else {
 		t.Error("Connection TCP Processing Duration not consistent")
 	}
+	if connectionEstablished.GetLocalPort() == 0 {
+		t.Error("Established connection should report its local port")
+	}
 
 	// We ask to close the TCP connection
 	closeRequest <- true
@@ -102,6 +103,9 @@ func TestTCPConnectErrored(t *testing.T) {
 	} else {
 		t.Error("Connection not errored")
 	}
+	if connectionErrored.GetFailureReason() != FailureRefused {
+		t.Error("Connection failure reason should be refused, and it is:", connectionErrored.GetFailureReason())
+	}
 	if connectionErrored.GetTCPProcessingDuration() != 0 {
 		t.Log("Connection Errored in ", connectionErrored.GetTCPProcessingDuration())
 	} else {
@@ -125,3 +129,60 @@ func TestReportConnectionStatus(t *testing.T) {
 		t.Error("Not proper Connection reported: ", <-connStatusCh)
 	}
 }
+
+func TestTCPConnectWithOptionsTLS(t *testing.T) {
+	var host = "127.0.0.1"
+	var tlsScenariosChecks = []struct {
+		scenarioDescription string
+		port                int
+		serverTLS           bool
+		expectedStatus      ConnectionStatus
+	}{
+		{
+			scenarioDescription: "TLS connections against a TLS server should become established",
+			port:                55557,
+			serverTLS:           true,
+			expectedStatus:      ConnectionEstablished,
+		},
+		{
+			scenarioDescription: "TLS connections against a plain TCP server should be reported as errored",
+			port:                55558,
+			serverTLS:           false,
+			expectedStatus:      ConnectionError,
+		},
+	}
+
+	for _, test := range tlsScenariosChecks {
+		dispatcher := &tcpserver.Dispatcher{
+			Handlers: make(map[string]*tcpserver.Handler),
+			Lock:     sync.RWMutex{},
+		}
+		if test.serverTLS {
+			dispatcher.TLSConfig, _ = tcpserver.NewTLSConfig(tcpserver.TLSOptions{})
+		}
+		if err := dispatcher.Start(context.Background(), []int{test.port}); err != nil {
+			t.Fatal("Could not start the TCP server", err)
+		}
+
+		opts := DefaultConnectOptions()
+		opts.DialTimeout = 500 * time.Millisecond
+		opts.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		var statusChannel = make(chan Connection, 2)
+		var closeRequest = make(chan bool)
+		go TCPConnectWithOptions(1, host, test.port, opts, &wg, statusChannel, closeRequest)
+		<-statusChannel
+		connection := <-statusChannel
+		if status := connection.GetConnectionStatus(); status != test.expectedStatus {
+			t.Error(test.scenarioDescription+", and its status is:", status)
+		}
+		if _, negotiated := connection.GetTLSHandshakeDuration(); negotiated != test.serverTLS {
+			t.Error(test.scenarioDescription+", and reporting its TLS handshake time is:", negotiated)
+		}
+		close(closeRequest)
+		wg.Wait()
+		dispatcher.Shutdown(context.Background())
+	}
+}
@@ -0,0 +1,170 @@
+package tcpclient
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// postgresSSLRequestCode and postgresProtocolVersion identify the messages opening PostgreSQL sessions
+const (
+	postgresSSLRequestCode  = 80877103
+	postgresProtocolVersion = 3 << 16
+	// postgresTooManyConnections is the SQLSTATE of the errors sent over max_connections
+	postgresTooManyConnections = "53300"
+)
+
+// maxHandshakeMessageSize bounds the messages read from servers not speaking the protocol they are probed with
+const maxHandshakeMessageSize = 1 << 16
+
+// mysqlTooManyConnections is the error code MySQL (and ProxySQL) send over max_connections
+const mysqlTooManyConnections = 1040
+
+// postgresProbe opens a PostgreSQL session up to the server asking for credentials: it goes through the
+// SSLRequest exchange (negotiating TLS if offered) and sends the startup message
+type postgresProbe struct{}
+
+func (p *postgresProbe) Handshake(conn *ProbeConn) error {
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request[0:4], 8)
+	binary.BigEndian.PutUint32(request[4:8], postgresSSLRequestCode)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	answer, err := conn.Reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch answer {
+	case 'N':
+		return nil
+	case 'S':
+		// no credentials are sent, so the certificate is not verified
+		tlsConn := tls.Client(conn.Conn, &tls.Config{ServerName: conn.Host, InsecureSkipVerify: true})
+		if err := tlsConn.Handshake(); err != nil {
+			return failure{FailureTLS, err}
+		}
+		conn.Conn = tlsConn
+		conn.Reader = bufio.NewReader(tlsConn)
+		return nil
+	case 'E':
+		// servers rejecting the connection right away reply with an error message
+		conn.Reader.UnreadByte()
+		return readPostgresAuthRequest(conn)
+	}
+	return fmt.Errorf("Unexpected answer to SSLRequest: %q", answer)
+}
+
+func (p *postgresProbe) Request(conn *ProbeConn) error {
+	var params bytes.Buffer
+	for _, param := range []string{"user", "tcpgoon", "database", "postgres", "application_name", "tcpgoon"} {
+		params.WriteString(param)
+		params.WriteByte(0)
+	}
+	params.WriteByte(0)
+
+	message := make([]byte, 8, 8+params.Len())
+	binary.BigEndian.PutUint32(message[0:4], uint32(8+params.Len()))
+	binary.BigEndian.PutUint32(message[4:8], postgresProtocolVersion)
+	_, err := conn.Write(append(message, params.Bytes()...))
+	return err
+}
+
+func (p *postgresProbe) Validate(conn *ProbeConn) error {
+	return readPostgresAuthRequest(conn)
+}
+
+// readPostgresAuthRequest expects the server to ask for authentication, classifying the errors it may send instead
+func readPostgresAuthRequest(conn *ProbeConn) error {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn.Reader, header); err != nil {
+		return err
+	}
+	conn.MarkFirstByte()
+	length := binary.BigEndian.Uint32(header[1:5])
+	if length < 4 || length > maxHandshakeMessageSize {
+		return failure{FailureUnexpectedResponse, fmt.Errorf("Unexpected PostgreSQL message length %d", length)}
+	}
+	body := make([]byte, length-4)
+	if _, err := io.ReadFull(conn.Reader, body); err != nil {
+		return err
+	}
+
+	switch header[0] {
+	case 'R':
+		return nil
+	case 'E':
+		code, message := parsePostgresError(body)
+		err := errors.New("PostgreSQL error " + code + ": " + message)
+		// PgBouncer does not send the SQLSTATE of too many connections, only its message
+		if code == postgresTooManyConnections || strings.Contains(message, "too many") ||
+			strings.Contains(message, "no more connections allowed") {
+			return failure{FailureTooManyClients, err}
+		}
+		return failure{FailureUnexpectedResponse, err}
+	}
+	return fmt.Errorf("Unexpected PostgreSQL message type %q", header[0])
+}
+
+// parsePostgresError extracts the SQLSTATE code and message of an ErrorResponse body
+func parsePostgresError(body []byte) (code string, message string) {
+	for _, field := range bytes.Split(body, []byte{0}) {
+		if len(field) == 0 {
+			continue
+		}
+		switch field[0] {
+		case 'C':
+			code = string(field[1:])
+		case 'M':
+			message = string(field[1:])
+		}
+	}
+	return code, message
+}
+
+// mysqlProbe reads the greeting MySQL servers send to new connections
+type mysqlProbe struct {
+	greeting []byte
+}
+
+func (p *mysqlProbe) Handshake(conn *ProbeConn) error {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn.Reader, header); err != nil {
+		return err
+	}
+	conn.MarkFirstByte()
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	if length > maxHandshakeMessageSize {
+		return failure{FailureUnexpectedResponse, fmt.Errorf("Unexpected MySQL packet length %d", length)}
+	}
+	p.greeting = make([]byte, length)
+	_, err := io.ReadFull(conn.Reader, p.greeting)
+	return err
+}
+
+func (p *mysqlProbe) Request(conn *ProbeConn) error { return nil }
+
+func (p *mysqlProbe) Validate(conn *ProbeConn) error {
+	switch {
+	case len(p.greeting) > 1 && p.greeting[0] == 10:
+		return nil
+	case len(p.greeting) >= 3 && p.greeting[0] == 0xff:
+		code := binary.LittleEndian.Uint16(p.greeting[1:3])
+		message := p.greeting[3:]
+		if len(message) > 6 && message[0] == '#' {
+			// 4.1 protocol errors carry the SQLSTATE before the message
+			message = message[6:]
+		}
+		err := fmt.Errorf("MySQL error %d: %s", code, message)
+		if code == mysqlTooManyConnections {
+			return failure{FailureTooManyClients, err}
+		}
+		return failure{FailureUnexpectedResponse, err}
+	}
+	return errors.New("Unexpected MySQL greeting")
+}
@@ -0,0 +1,130 @@
+package tcpclient
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakePostgres answers the SSLRequest with sslAnswer, and the startup message with reply
+func fakePostgres(sslAnswer byte, reply []byte) func(net.Conn) {
+	return func(conn net.Conn) {
+		sslRequest := make([]byte, 8)
+		if _, err := io.ReadFull(conn, sslRequest); err != nil ||
+			binary.BigEndian.Uint32(sslRequest[4:8]) != postgresSSLRequestCode {
+			return
+		}
+		conn.Write([]byte{sslAnswer})
+		length := make([]byte, 4)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return
+		}
+		startup := make([]byte, binary.BigEndian.Uint32(length)-4)
+		if _, err := io.ReadFull(conn, startup); err != nil ||
+			binary.BigEndian.Uint32(startup[0:4]) != postgresProtocolVersion {
+			return
+		}
+		conn.Write(reply)
+		conn.Read(make([]byte, 1))
+	}
+}
+
+func postgresMessage(messageType byte, body []byte) []byte {
+	message := []byte{messageType, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(message[1:5], uint32(4+len(body)))
+	return append(message, body...)
+}
+
+func mysqlPacket(payload []byte) []byte {
+	return append([]byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), 0}, payload...)
+}
+
+func TestDatabaseProbes(t *testing.T) {
+	const port = 55568
+	md5AuthRequest := postgresMessage('R', []byte{0, 0, 0, 5, 1, 2, 3, 4})
+	tooManyClients := postgresMessage('E', []byte("SFATAL\x00C53300\x00Msorry, too many clients already\x00\x00"))
+	noSuchDatabase := postgresMessage('E', []byte("SFATAL\x00C3D000\x00Mdatabase \"postgres\" does not exist\x00\x00"))
+
+	var databaseScenariosChecks = []struct {
+		scenarioDescription string
+		protocol            string
+		handle              func(net.Conn)
+		expectedStatus      ConnectionStatus
+		expectedReason      FailureReason
+	}{
+		{
+			scenarioDescription: "PostgreSQL asking for credentials should get connections established",
+			protocol:            "postgres",
+			handle:              fakePostgres('N', md5AuthRequest),
+			expectedStatus:      ConnectionEstablished,
+		},
+		{
+			scenarioDescription: "PostgreSQL over max_connections should fail as too many clients",
+			protocol:            "postgres",
+			handle:              fakePostgres('N', tooManyClients),
+			expectedStatus:      ConnectionError,
+			expectedReason:      FailureTooManyClients,
+		},
+		{
+			scenarioDescription: "PostgreSQL rejecting the session for other reasons should fail as an unexpected response",
+			protocol:            "postgres",
+			handle:              fakePostgres('N', noSuchDatabase),
+			expectedStatus:      ConnectionError,
+			expectedReason:      FailureUnexpectedResponse,
+		},
+		{
+			scenarioDescription: "PostgreSQL rejecting the connection before the startup message should fail as too many clients",
+			protocol:            "postgres",
+			handle: func(conn net.Conn) {
+				io.ReadFull(conn, make([]byte, 8))
+				conn.Write(postgresMessage('E', []byte("SERROR\x00Mno more connections allowed (max_client_conn)\x00\x00")))
+			},
+			expectedStatus: ConnectionError,
+			expectedReason: FailureTooManyClients,
+		},
+		{
+			scenarioDescription: "MySQL sending its greeting should get connections established",
+			protocol:            "mysql",
+			handle: func(conn net.Conn) {
+				conn.Write(mysqlPacket(append([]byte{10}, "8.0.36\x00"...)))
+				conn.Read(make([]byte, 1))
+			},
+			expectedStatus: ConnectionEstablished,
+		},
+		{
+			scenarioDescription: "MySQL over max_connections should fail as too many clients",
+			protocol:            "mysql",
+			handle: func(conn net.Conn) {
+				conn.Write(mysqlPacket(append([]byte{0xff, 0x10, 0x04}, "#08004Too many connections"...)))
+			},
+			expectedStatus: ConnectionError,
+			expectedReason: FailureTooManyClients,
+		},
+		{
+			scenarioDescription: "Servers not speaking MySQL should fail",
+			protocol:            "mysql",
+			handle: func(conn net.Conn) {
+				conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+			},
+			expectedStatus: ConnectionError,
+			expectedReason: FailureUnexpectedResponse,
+		},
+	}
+
+	for _, test := range databaseScenariosChecks {
+		stop := serveFake(t, port, test.handle)
+		connection := probeOnce(port, test.protocol, time.Second)
+		stop()
+
+		if connection.GetConnectionStatus() != test.expectedStatus || connection.GetFailureReason() != test.expectedReason {
+			t.Error(test.scenarioDescription+", and the connection is:", connection)
+			continue
+		}
+		if handshake, ok := connection.GetProbeOutcome().PhaseDuration(PhaseHandshake); test.expectedStatus == ConnectionEstablished &&
+			(!ok || handshake == 0) {
+			t.Error(test.scenarioDescription+", timing the handshake, and it took:", handshake)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package tcpclient
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// FailureReason classifies why a connection ended up in error
+type FailureReason string
+
+// Reasons a connection can fail for
+const (
+	FailureNone               FailureReason = ""
+	FailureTimeout            FailureReason = "timeout"
+	FailureRefused            FailureReason = "refused"
+	FailureReset              FailureReason = "reset"
+	FailureUnreachable        FailureReason = "unreachable"
+	FailureTLS                FailureReason = "tls"
+	FailureUnexpectedResponse FailureReason = "unexpected_response"
+	FailureTooManyClients     FailureReason = "too_many_clients"
+	FailureCancelled          FailureReason = "cancelled"
+	FailureOther              FailureReason = "other"
+)
+
+// FailureReasons lists all the reasons a connection can fail for
+var FailureReasons = []FailureReason{
+	FailureTimeout,
+	FailureRefused,
+	FailureReset,
+	FailureUnreachable,
+	FailureTLS,
+	FailureUnexpectedResponse,
+	FailureTooManyClients,
+	FailureCancelled,
+	FailureOther,
+}
+
+// failure attaches a reason to an error, when the error itself is not enough to classify it
+type failure struct {
+	reason FailureReason
+	err    error
+}
+
+func (f failure) Error() string { return f.err.Error() }
+func (f failure) Unwrap() error { return f.err }
+
+// classifyFailure tells why a connection failed, given the error it got
+func classifyFailure(err error) FailureReason {
+	var f failure
+	switch {
+	case err == nil:
+		return FailureNone
+	case errors.As(err, &f):
+		return f.reason
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return FailureRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return FailureReset
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return FailureUnreachable
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return FailureTimeout
+	}
+	return FailureOther
+}
@@ -0,0 +1,66 @@
+package tcpclient
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyFailure(t *testing.T) {
+	var classifyScenariosChecks = []struct {
+		scenarioDescription string
+		err                 error
+		expectedReason      FailureReason
+	}{
+		{
+			scenarioDescription: "No error should have no failure reason",
+			err:                 nil,
+			expectedReason:      FailureNone,
+		},
+		{
+			scenarioDescription: "Refused dials should be classified as refused",
+			err:                 &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+			expectedReason:      FailureRefused,
+		},
+		{
+			scenarioDescription: "Resets should be classified as reset",
+			err:                 &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)},
+			expectedReason:      FailureReset,
+		},
+		{
+			scenarioDescription: "Unreachable hosts should be classified as unreachable",
+			err:                 &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)},
+			expectedReason:      FailureUnreachable,
+		},
+		{
+			scenarioDescription: "Timeouts should be classified as timeout",
+			err:                 &net.OpError{Op: "dial", Err: timeoutError{}},
+			expectedReason:      FailureTimeout,
+		},
+		{
+			scenarioDescription: "Reasons attached to errors should prevail",
+			err:                 fmt.Errorf("handshake: %w", failure{FailureTLS, &net.OpError{Op: "read", Err: timeoutError{}}}),
+			expectedReason:      FailureTLS,
+		},
+		{
+			scenarioDescription: "Unknown errors should be classified as other",
+			err:                 errors.New("something else"),
+			expectedReason:      FailureOther,
+		},
+	}
+
+	for _, test := range classifyScenariosChecks {
+		if reason := classifyFailure(test.err); reason != test.expectedReason {
+			t.Error(test.scenarioDescription+", and it is:", reason)
+		}
+	}
+}
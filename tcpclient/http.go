@@ -0,0 +1,101 @@
+package tcpclient
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// maxHTTPBodySize bounds how much of the response bodies is read to validate them
+const maxHTTPBodySize = 1 << 20
+
+// HTTPProbeConfig describes the request the http protocol probe issues, and how its response is validated
+type HTTPProbeConfig struct {
+	Method  string
+	Path    string
+	Headers http.Header
+	Body    []byte
+	// StatusCodes accepted as a valid response. Any 2xx or 3xx is accepted when empty
+	StatusCodes []int
+	// BodyMatch, when set, has to match the response body
+	BodyMatch *regexp.Regexp
+}
+
+// DefaultHTTPProbe is the configuration of the protocols running over HTTP DefaultConnectOptions start from
+var DefaultHTTPProbe = HTTPProbeConfig{
+	Method: http.MethodGet,
+	Path:   "/",
+}
+
+// httpProbe issues HTTP/1.1 requests, keeping the connection alive so they can be repeated on it
+type httpProbe struct {
+	config  HTTPProbeConfig
+	request *http.Request
+}
+
+func (p *httpProbe) Handshake(conn *ProbeConn) error { return nil }
+
+func (p *httpProbe) Request(conn *ProbeConn) error {
+	req, err := http.NewRequest(p.config.Method, "http://"+conn.Authority()+p.config.Path, bytes.NewReader(p.config.Body))
+	if err != nil {
+		return err
+	}
+	for name, values := range p.config.Headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	if host := p.config.Headers.Get("Host"); host != "" {
+		req.Host = host
+	}
+	req.Header.Set("User-Agent", "tcpgoon")
+	p.request = req
+	return req.Write(conn)
+}
+
+func (p *httpProbe) Validate(conn *ProbeConn) error {
+	if _, err := conn.Reader.Peek(1); err != nil {
+		return err
+	}
+	conn.MarkFirstByte()
+	resp, err := http.ReadResponse(conn.Reader, p.request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	conn.identifyBackend(conn.extractor.fromHeader(resp.Header))
+	// the whole body is read, so the next request on the connection starts on a clean stream
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHTTPBodySize))
+	if err != nil {
+		return err
+	}
+	if n, _ := io.Copy(ioutil.Discard, resp.Body); n > 0 {
+		return errors.New("Response body is bigger than " + strconv.Itoa(maxHTTPBodySize) + " bytes")
+	}
+
+	if !p.config.validStatus(resp.StatusCode) {
+		return failure{FailureUnexpectedResponse, fmt.Errorf("Unexpected response status %s", resp.Status)}
+	}
+	if p.config.BodyMatch != nil && !p.config.BodyMatch.Match(body) {
+		return failure{FailureUnexpectedResponse,
+			errors.New("Response body does not match " + p.config.BodyMatch.String())}
+	}
+	return nil
+}
+
+func (c HTTPProbeConfig) validStatus(status int) bool {
+	if len(c.StatusCodes) == 0 {
+		return status >= 200 && status < 400
+	}
+	for _, code := range c.StatusCodes {
+		if status == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,443 @@
+package tcpclient
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// HTTP2Outcome describes how the HTTP/2 connection of the h2 and grpc protocols went
+type HTTP2Outcome struct {
+	// SettingsExchange is the time from sending the connection preface to have the SETTINGS of the server
+	// and its acknowledgement of ours
+	SettingsExchange time.Duration
+	// StreamErrors counts the streams of the probe the server reset
+	StreamErrors int
+	// GoAway tells if the server sent a GOAWAY frame, being GoAwayCode its error code
+	GoAway     bool
+	GoAwayCode uint32
+}
+
+const (
+	http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+	// http2MaxFrameSize is the default maximum frame size, which we do not raise
+	http2MaxFrameSize = 1 << 14
+
+	http2FrameData         = 0x0
+	http2FrameHeaders      = 0x1
+	http2FrameRSTStream    = 0x3
+	http2FrameSettings     = 0x4
+	http2FramePing         = 0x6
+	http2FrameGoAway       = 0x7
+	http2FrameWindowUpdate = 0x8
+	http2FrameContinuation = 0x9
+
+	http2FlagEndStream  = 0x1
+	http2FlagAck        = 0x1
+	http2FlagEndHeaders = 0x4
+	http2FlagPadded     = 0x8
+	http2FlagPriority   = 0x20
+
+	http2SettingEnablePush = 0x2
+
+	http2ErrCodeRefusedStream   = 0x7
+	http2ErrCodeEnhanceYourCalm = 0xb
+
+	grpcHealthCheckPath = "/grpc.health.v1.Health/Check"
+	grpcHealthServing   = 1
+)
+
+type http2Frame struct {
+	kind     byte
+	flags    byte
+	streamID uint32
+	payload  []byte
+}
+
+// http2Probe speaks HTTP/2, negotiated via ALPN over TLS or with prior knowledge over plain TCP (h2c). Its
+// handshake is the exchange of SETTINGS, and each request is issued on a new stream of the connection. Issuing
+// gRPC health checks rather than the requests of the http protocol when grpc is set
+type http2Probe struct {
+	config      HTTPProbeConfig
+	grpc        bool
+	grpcService string
+	scheme      string
+	decoder     *hpack.Decoder
+	encoder     *hpack.Encoder
+	headerBlock bytes.Buffer
+	streamID    uint32
+}
+
+func (p *http2Probe) Handshake(conn *ProbeConn) error {
+	p.scheme = "http"
+	if tlsConn, ok := conn.Conn.(*tls.Conn); ok {
+		if protocol := tlsConn.ConnectionState().NegotiatedProtocol; protocol != "h2" {
+			return failure{FailureUnexpectedResponse,
+				errors.New("Server negotiated " + strconv.Quote(protocol) + " rather than h2 via ALPN")}
+		}
+		p.scheme = "https"
+	}
+	p.decoder = hpack.NewDecoder(4096, nil)
+	p.encoder = hpack.NewEncoder(&p.headerBlock)
+
+	start := time.Now()
+	if _, err := io.WriteString(conn, http2Preface); err != nil {
+		return err
+	}
+	if err := writeHTTP2Frame(conn, http2FrameSettings, 0, 0, []byte{0, http2SettingEnablePush, 0, 0, 0, 0}); err != nil {
+		return err
+	}
+	var gotSettings, gotAck bool
+	for !gotSettings || !gotAck {
+		frame, err := readHTTP2Frame(conn)
+		if err != nil {
+			return err
+		}
+		if frame.kind == http2FrameSettings && frame.flags&http2FlagAck != 0 {
+			gotAck = true
+			continue
+		}
+		if frame.kind == http2FrameSettings {
+			gotSettings = true
+		}
+		if err := p.handleConnectionFrame(conn, frame); err != nil {
+			return err
+		}
+	}
+	conn.http2.SettingsExchange = time.Since(start)
+	return nil
+}
+
+func (p *http2Probe) Request(conn *ProbeConn) error {
+	if p.streamID == 0 {
+		p.streamID = 1
+	} else {
+		p.streamID += 2
+	}
+	method, path, body := p.config.Method, p.config.Path, p.config.Body
+	if p.grpc {
+		method, path, body = http.MethodPost, grpcHealthCheckPath, grpcHealthCheckRequest(p.grpcService)
+	}
+	authority := conn.Authority()
+	if host := p.config.Headers.Get("Host"); host != "" {
+		authority = host
+	}
+
+	fields := []hpack.HeaderField{
+		{Name: ":method", Value: method},
+		{Name: ":scheme", Value: p.scheme},
+		{Name: ":authority", Value: authority},
+		{Name: ":path", Value: path},
+	}
+	if p.grpc {
+		fields = append(fields, hpack.HeaderField{Name: "content-type", Value: "application/grpc"},
+			hpack.HeaderField{Name: "te", Value: "trailers"})
+	}
+	fields = append(fields, hpack.HeaderField{Name: "user-agent", Value: "tcpgoon"})
+	for name, values := range p.config.Headers {
+		if strings.EqualFold(name, "Host") {
+			continue
+		}
+		for _, value := range values {
+			fields = append(fields, hpack.HeaderField{Name: strings.ToLower(name), Value: value})
+		}
+	}
+	p.headerBlock.Reset()
+	for _, field := range fields {
+		if err := p.encoder.WriteField(field); err != nil {
+			return err
+		}
+	}
+	block := p.headerBlock.Bytes()
+	if len(block) > http2MaxFrameSize {
+		return errors.New("HTTP/2 request headers do not fit in a frame")
+	}
+
+	flags := byte(http2FlagEndHeaders)
+	if len(body) == 0 {
+		flags |= http2FlagEndStream
+	}
+	if err := writeHTTP2Frame(conn, http2FrameHeaders, flags, p.streamID, block); err != nil {
+		return err
+	}
+	for len(body) > 0 {
+		chunk := body
+		if len(chunk) > http2MaxFrameSize {
+			chunk = chunk[:http2MaxFrameSize]
+		}
+		body = body[len(chunk):]
+		flags = 0
+		if len(body) == 0 {
+			flags = http2FlagEndStream
+		}
+		if err := writeHTTP2Frame(conn, http2FrameData, flags, p.streamID, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate reads the frames of the connection until the stream of the request ends, handling the ones
+// of the connection meanwhile
+func (p *http2Probe) Validate(conn *ProbeConn) error {
+	var headers []hpack.HeaderField
+	var block, body []byte
+	var ended bool
+	for !ended || block != nil {
+		frame, err := readHTTP2Frame(conn)
+		if err != nil {
+			return err
+		}
+		if frame.streamID == 0 {
+			if err := p.handleConnectionFrame(conn, frame); err != nil {
+				return err
+			}
+			continue
+		}
+		if frame.streamID != p.streamID {
+			continue
+		}
+		switch frame.kind {
+		case http2FrameHeaders, http2FrameContinuation:
+			conn.MarkFirstByte()
+			fragment, err := http2FramePayload(frame)
+			if err != nil {
+				return err
+			}
+			block = append(block, fragment...)
+			if frame.flags&http2FlagEndHeaders != 0 {
+				fields, err := p.decoder.DecodeFull(block)
+				if err != nil {
+					return err
+				}
+				headers = append(headers, fields...)
+				block = nil
+			}
+		case http2FrameData:
+			conn.MarkFirstByte()
+			data, err := http2FramePayload(frame)
+			if err != nil {
+				return err
+			}
+			if len(body)+len(data) > maxHTTPBodySize {
+				return errors.New("Response body is bigger than " + strconv.Itoa(maxHTTPBodySize) + " bytes")
+			}
+			body = append(body, data...)
+			if err := p.replenishWindow(conn, frame); err != nil {
+				return err
+			}
+		case http2FrameRSTStream:
+			conn.http2.StreamErrors++
+			code := http2ErrorCode(frame.payload)
+			err := fmt.Errorf("HTTP/2 stream reset by the server with error code %#x", code)
+			if code == http2ErrCodeRefusedStream || code == http2ErrCodeEnhanceYourCalm {
+				return failure{FailureTooManyClients, err}
+			}
+			return failure{FailureUnexpectedResponse, err}
+		}
+		if frame.kind != http2FrameContinuation && frame.flags&http2FlagEndStream != 0 {
+			ended = true
+		}
+	}
+
+	if conn.extractor.Header != "" {
+		conn.identifyBackend(http2HeaderValue(headers, strings.ToLower(conn.extractor.Header)))
+	}
+	status, _ := strconv.Atoi(http2HeaderValue(headers, ":status"))
+	if p.grpc {
+		return validateGRPCHealthCheck(status, headers, body)
+	}
+	if !p.config.validStatus(status) {
+		return failure{FailureUnexpectedResponse, fmt.Errorf("Unexpected response status %d", status)}
+	}
+	if p.config.BodyMatch != nil && !p.config.BodyMatch.Match(body) {
+		return failure{FailureUnexpectedResponse,
+			errors.New("Response body does not match " + p.config.BodyMatch.String())}
+	}
+	return nil
+}
+
+// handleConnectionFrame processes the frames that are not part of the stream of the request, recording
+// the GOAWAY ones and failing if the request is not going to be processed because of them
+func (p *http2Probe) handleConnectionFrame(conn *ProbeConn, frame http2Frame) error {
+	switch frame.kind {
+	case http2FrameSettings:
+		if frame.flags&http2FlagAck == 0 {
+			return writeHTTP2Frame(conn, http2FrameSettings, http2FlagAck, 0, nil)
+		}
+	case http2FramePing:
+		if frame.flags&http2FlagAck == 0 {
+			return writeHTTP2Frame(conn, http2FramePing, http2FlagAck, 0, frame.payload)
+		}
+	case http2FrameGoAway:
+		if len(frame.payload) < 8 {
+			return errors.New("Invalid HTTP/2 GOAWAY frame")
+		}
+		lastStreamID := binary.BigEndian.Uint32(frame.payload) & 0x7fffffff
+		conn.http2.GoAway = true
+		conn.http2.GoAwayCode = binary.BigEndian.Uint32(frame.payload[4:])
+		if p.streamID == 0 || p.streamID > lastStreamID {
+			return fmt.Errorf("HTTP/2 GOAWAY received with error code %#x", conn.http2.GoAwayCode)
+		}
+	}
+	return nil
+}
+
+// replenishWindow gives back to the connection and the stream the flow control window a data frame took,
+// so requests can be repeated on the connection indefinitely
+func (p *http2Probe) replenishWindow(conn *ProbeConn, frame http2Frame) error {
+	if len(frame.payload) == 0 {
+		return nil
+	}
+	increment := make([]byte, 4)
+	binary.BigEndian.PutUint32(increment, uint32(len(frame.payload)))
+	if err := writeHTTP2Frame(conn, http2FrameWindowUpdate, 0, 0, increment); err != nil {
+		return err
+	}
+	if frame.flags&http2FlagEndStream != 0 {
+		return nil
+	}
+	return writeHTTP2Frame(conn, http2FrameWindowUpdate, 0, frame.streamID, increment)
+}
+
+func readHTTP2Frame(conn *ProbeConn) (http2Frame, error) {
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(conn.Reader, header); err != nil {
+		return http2Frame{}, err
+	}
+	length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+	if length > http2MaxFrameSize {
+		return http2Frame{}, failure{FailureUnexpectedResponse,
+			errors.New("HTTP/2 frame is bigger than " + strconv.Itoa(http2MaxFrameSize) + " bytes")}
+	}
+	frame := http2Frame{
+		kind:     header[3],
+		flags:    header[4],
+		streamID: binary.BigEndian.Uint32(header[5:]) & 0x7fffffff,
+		payload:  make([]byte, length),
+	}
+	_, err := io.ReadFull(conn.Reader, frame.payload)
+	return frame, err
+}
+
+func writeHTTP2Frame(conn *ProbeConn, kind, flags byte, streamID uint32, payload []byte) error {
+	frame := []byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), kind, flags, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(frame[5:], streamID)
+	_, err := conn.Write(append(frame, payload...))
+	return err
+}
+
+// http2FramePayload returns the payload of headers and data frames, without their padding and priority
+func http2FramePayload(frame http2Frame) ([]byte, error) {
+	payload := frame.payload
+	if frame.kind == http2FrameContinuation {
+		return payload, nil
+	}
+	var padding int
+	if frame.flags&http2FlagPadded != 0 {
+		if len(payload) == 0 {
+			return nil, errors.New("Invalid HTTP/2 padded frame")
+		}
+		padding = int(payload[0])
+		payload = payload[1:]
+	}
+	if frame.kind == http2FrameHeaders && frame.flags&http2FlagPriority != 0 {
+		if len(payload) < 5 {
+			return nil, errors.New("Invalid HTTP/2 headers frame priority")
+		}
+		payload = payload[5:]
+	}
+	if padding > len(payload) {
+		return nil, errors.New("Invalid HTTP/2 frame padding")
+	}
+	return payload[:len(payload)-padding], nil
+}
+
+func http2ErrorCode(payload []byte) uint32 {
+	if len(payload) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(payload)
+}
+
+// http2HeaderValue returns the last value of a header, so trailers take precedence over headers
+func http2HeaderValue(headers []hpack.HeaderField, name string) string {
+	var value string
+	for _, field := range headers {
+		if field.Name == name {
+			value = field.Value
+		}
+	}
+	return value
+}
+
+// grpcHealthCheckRequest returns a gRPC length prefixed grpc.health.v1.HealthCheckRequest message
+func grpcHealthCheckRequest(service string) []byte {
+	var message []byte
+	if service != "" {
+		message = protowire.AppendTag(message, 1, protowire.BytesType)
+		message = protowire.AppendString(message, service)
+	}
+	prefix := []byte{0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(message)))
+	return append(prefix, message...)
+}
+
+// validateGRPCHealthCheck checks a health check call succeeded, and that the service is serving
+func validateGRPCHealthCheck(status int, headers []hpack.HeaderField, body []byte) error {
+	if status != http.StatusOK {
+		return failure{FailureUnexpectedResponse, fmt.Errorf("Unexpected gRPC response status %d", status)}
+	}
+	if grpcStatus := http2HeaderValue(headers, "grpc-status"); grpcStatus != "0" {
+		return failure{FailureUnexpectedResponse, errors.New("gRPC health check failed with status " +
+			grpcStatus + ": " + http2HeaderValue(headers, "grpc-message"))}
+	}
+	if len(body) < 5 || body[0] != 0 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+		return failure{FailureUnexpectedResponse, errors.New("Invalid gRPC health check response message")}
+	}
+	servingStatus, err := grpcHealthServingStatus(body[5:])
+	if err != nil {
+		return failure{FailureUnexpectedResponse, err}
+	}
+	if servingStatus != grpcHealthServing {
+		return failure{FailureUnexpectedResponse, errors.New("gRPC health check status is " + strconv.Itoa(servingStatus) +
+			" rather than SERVING")}
+	}
+	return nil
+}
+
+// grpcHealthServingStatus returns the status field of a grpc.health.v1.HealthCheckResponse message
+func grpcHealthServingStatus(message []byte) (int, error) {
+	var servingStatus int
+	for len(message) > 0 {
+		number, wireType, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		message = message[n:]
+		if number == 1 && wireType == protowire.VarintType {
+			value, n := protowire.ConsumeVarint(message)
+			if n < 0 {
+				return 0, protowire.ParseError(n)
+			}
+			servingStatus = int(value)
+			message = message[n:]
+			continue
+		}
+		if n = protowire.ConsumeFieldValue(number, wireType, message); n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		message = message[n:]
+	}
+	return servingStatus, nil
+}
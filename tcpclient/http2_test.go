@@ -0,0 +1,252 @@
+package tcpclient
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// serveTLS serves handler over TLS with a net/http server, negotiating h2 via ALPN when http2 is set
+func serveTLS(t *testing.T, port int, handler http.Handler, http2 bool) (stop func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal("Could not start the TLS server", err)
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener.Close()
+	server.Listener = listener
+	server.EnableHTTP2 = http2
+	server.StartTLS()
+	return server.Close
+}
+
+// servingHTTP2 handles connections as an HTTP/2 server with prior knowledge, calling respond on each request
+func servingHTTP2(respond func(conn *ProbeConn, streamID uint32)) func(net.Conn) {
+	return func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		if _, err := io.ReadFull(reader, make([]byte, len(http2Preface))); err != nil {
+			return
+		}
+		probeConn := &ProbeConn{Conn: conn, Reader: reader}
+		writeHTTP2Frame(probeConn, http2FrameSettings, 0, 0, nil)
+		for {
+			frame, err := readHTTP2Frame(probeConn)
+			if err != nil {
+				return
+			}
+			switch {
+			case frame.kind == http2FrameSettings && frame.flags&http2FlagAck == 0:
+				writeHTTP2Frame(probeConn, http2FrameSettings, http2FlagAck, 0, nil)
+			case (frame.kind == http2FrameHeaders || frame.kind == http2FrameData) && frame.flags&http2FlagEndStream != 0:
+				respond(probeConn, frame.streamID)
+			}
+		}
+	}
+}
+
+func respondingStatus(status string) func(*ProbeConn, uint32) {
+	return func(conn *ProbeConn, streamID uint32) {
+		writeHTTP2Frame(conn, http2FrameHeaders, http2FlagEndHeaders|http2FlagEndStream, streamID,
+			encodeHeaders(":status", status))
+	}
+}
+
+// encodeHeaders returns the HPACK encoded header block of pairs of header names and values
+func encodeHeaders(pairs ...string) []byte {
+	var block bytes.Buffer
+	encoder := hpack.NewEncoder(&block)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		encoder.WriteField(hpack.HeaderField{Name: pairs[i], Value: pairs[i+1]})
+	}
+	return block.Bytes()
+}
+
+func resettingStreams(code uint32) func(*ProbeConn, uint32) {
+	return func(conn *ProbeConn, streamID uint32) {
+		payload := make([]byte, 4)
+		binary.BigEndian.PutUint32(payload, code)
+		writeHTTP2Frame(conn, http2FrameRSTStream, 0, streamID, payload)
+	}
+}
+
+func goingAway(code uint32) func(*ProbeConn, uint32) {
+	return func(conn *ProbeConn, streamID uint32) {
+		payload := make([]byte, 8)
+		binary.BigEndian.PutUint32(payload[4:], code)
+		writeHTTP2Frame(conn, http2FrameGoAway, 0, 0, payload)
+	}
+}
+
+// grpcHealth serves grpc.health.v1.Health/Check, replying servingStatus
+func grpcHealth(servingStatus byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.URL.Path != grpcHealthCheckPath || r.Header.Get("Content-Type") != "application/grpc" {
+			http.NotFound(w, r)
+			return
+		}
+		io.Copy(ioutil.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte{0, 0, 0, 0, 2, 0x08, servingStatus})
+		w.Header().Set("Grpc-Status", "0")
+	})
+}
+
+func TestHTTP2Probes(t *testing.T) {
+	const port = 55571
+	var http2ScenariosChecks = []struct {
+		scenarioDescription string
+		protocol            string
+		handler             http.Handler
+		http2               bool
+		handle              func(net.Conn)
+		expectedStatus      ConnectionStatus
+		expectedReason      FailureReason
+		expectedHTTP2       HTTP2Outcome
+	}{
+		{
+			scenarioDescription: "HTTP/2 servers negotiating h2 via ALPN should get connections established",
+			protocol:            "h2",
+			handler:             http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+			http2:               true,
+			expectedStatus:      ConnectionEstablished,
+		},
+		{
+			scenarioDescription: "TLS servers not negotiating h2 should fail",
+			protocol:            "h2",
+			handler:             http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+			expectedStatus:      ConnectionError,
+			expectedReason:      FailureTLS,
+		},
+		{
+			scenarioDescription: "gRPC services serving should get connections established",
+			protocol:            "grpc",
+			handler:             grpcHealth(grpcHealthServing),
+			http2:               true,
+			expectedStatus:      ConnectionEstablished,
+		},
+		{
+			scenarioDescription: "gRPC services not serving should fail as an unexpected response",
+			protocol:            "grpc",
+			handler:             grpcHealth(2),
+			http2:               true,
+			expectedStatus:      ConnectionError,
+			expectedReason:      FailureUnexpectedResponse,
+		},
+		{
+			scenarioDescription: "HTTP/2 servers with prior knowledge should get connections established",
+			protocol:            "h2",
+			handle:              servingHTTP2(respondingStatus("204")),
+			expectedStatus:      ConnectionEstablished,
+		},
+		{
+			scenarioDescription: "HTTP/2 servers refusing streams should fail as too many clients, recording the stream error",
+			protocol:            "h2",
+			handle:              servingHTTP2(resettingStreams(http2ErrCodeRefusedStream)),
+			expectedStatus:      ConnectionError,
+			expectedReason:      FailureTooManyClients,
+			expectedHTTP2:       HTTP2Outcome{StreamErrors: 1},
+		},
+		{
+			scenarioDescription: "HTTP/2 servers going away before processing the request should fail, recording the GOAWAY",
+			protocol:            "h2",
+			handle:              servingHTTP2(goingAway(http2ErrCodeEnhanceYourCalm)),
+			expectedStatus:      ConnectionError,
+			expectedReason:      FailureUnexpectedResponse,
+			expectedHTTP2:       HTTP2Outcome{GoAway: true, GoAwayCode: http2ErrCodeEnhanceYourCalm},
+		},
+	}
+
+	for _, test := range http2ScenariosChecks {
+		opts := DefaultConnectOptions()
+		opts.DialTimeout = time.Second
+		opts.Protocol = test.protocol
+		var stop func()
+		if test.handler != nil {
+			stop = serveTLS(t, port, test.handler, test.http2)
+			opts.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+		} else {
+			stop = serveFake(t, port, test.handle)
+		}
+		connection := probeOnceWithOptions(port, opts)
+		stop()
+
+		if connection.GetConnectionStatus() != test.expectedStatus || connection.GetFailureReason() != test.expectedReason {
+			t.Error(test.scenarioDescription+", and the connection is:", connection)
+			continue
+		}
+		outcome := connection.GetProbeOutcome().HTTP2()
+		if test.expectedStatus == ConnectionEstablished && outcome.SettingsExchange <= 0 {
+			t.Error(test.scenarioDescription + ", reporting the SETTINGS exchange time")
+		}
+		outcome.SettingsExchange = 0
+		if outcome != test.expectedHTTP2 {
+			t.Error(test.scenarioDescription+", and its HTTP/2 outcome is:", outcome)
+		}
+	}
+}
+
+func TestHTTP2ProbeRepeatingRequests(t *testing.T) {
+	const port = 55571
+	stop := serveFake(t, port, servingHTTP2(respondingStatus("200")))
+	opts := DefaultConnectOptions()
+	opts.Protocol = "h2"
+	connection := probeRepeating(port, opts, 50*time.Millisecond, 300*time.Millisecond)
+	stop()
+
+	if connection.GetConnectionStatus() != ConnectionEstablished || connection.GetProbeOutcome().Requests() < 3 {
+		t.Error("HTTP/2 requests should be repeated on new streams of the same connection, and the connection is:",
+			connection, "after", connection.GetProbeOutcome().Requests(), "requests")
+	}
+}
+
+func TestHTTP2ProbeAuthority(t *testing.T) {
+	const port = 55571
+	authorities := make(chan string, 1)
+	stop := serveFake(t, port, func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		if _, err := io.ReadFull(reader, make([]byte, len(http2Preface))); err != nil {
+			return
+		}
+		probeConn := &ProbeConn{Conn: conn, Reader: reader}
+		writeHTTP2Frame(probeConn, http2FrameSettings, 0, 0, nil)
+		decoder := hpack.NewDecoder(4096, nil)
+		for {
+			frame, err := readHTTP2Frame(probeConn)
+			if err != nil {
+				return
+			}
+			switch frame.kind {
+			case http2FrameSettings:
+				if frame.flags&http2FlagAck == 0 {
+					writeHTTP2Frame(probeConn, http2FrameSettings, http2FlagAck, 0, nil)
+				}
+			case http2FrameHeaders:
+				fields, _ := decoder.DecodeFull(frame.payload)
+				authorities <- http2HeaderValue(fields, ":authority")
+				writeHTTP2Frame(probeConn, http2FrameHeaders, http2FlagEndHeaders|http2FlagEndStream, frame.streamID,
+					encodeHeaders(":status", "200"))
+			}
+		}
+	})
+	defer stop()
+
+	connection := probeOnce(port, "h2", time.Second)
+	if connection.GetConnectionStatus() != ConnectionEstablished {
+		t.Fatal("HTTP/2 requests should get connections established, and the connection is:", connection)
+	}
+	if authority := <-authorities; authority != "127.0.0.1:"+strconv.Itoa(port) {
+		t.Error("HTTP/2 requests to non-default ports should announce them in the authority, and it is:", authority)
+	}
+}
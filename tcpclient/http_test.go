@@ -0,0 +1,150 @@
+package tcpclient
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPProbe(t *testing.T) {
+	const port = 55566
+	var requests int32
+	listener, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal("Could not start the HTTP server", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/health":
+			w.Write([]byte("status: ok"))
+		case "/close":
+			w.Header().Set("Connection", "close")
+			w.Write([]byte("status: ok"))
+		default:
+			http.Error(w, "status: unavailable", http.StatusServiceUnavailable)
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	var httpScenariosChecks = []struct {
+		scenarioDescription string
+		path                string
+		bodyMatch           string
+		expectedStatus      ConnectionStatus
+		expectedReason      FailureReason
+	}{
+		{
+			scenarioDescription: "Valid responses should get connections established",
+			path:                "/health",
+			bodyMatch:           "status: ok",
+			expectedStatus:      ConnectionEstablished,
+		},
+		{
+			scenarioDescription: "Responses with an unexpected status should fail as unexpected responses",
+			path:                "/unavailable",
+			expectedStatus:      ConnectionError,
+			expectedReason:      FailureUnexpectedResponse,
+		},
+		{
+			scenarioDescription: "Responses not matching the expected body should fail as unexpected responses",
+			path:                "/health",
+			bodyMatch:           "status: degraded",
+			expectedStatus:      ConnectionError,
+			expectedReason:      FailureUnexpectedResponse,
+		},
+	}
+
+	for _, test := range httpScenariosChecks {
+		opts := DefaultConnectOptions()
+		opts.DialTimeout = time.Second
+		opts.Protocol = "http"
+		opts.HTTP = HTTPProbeConfig{Method: http.MethodGet, Path: test.path}
+		if test.bodyMatch != "" {
+			opts.HTTP.BodyMatch = regexp.MustCompile(test.bodyMatch)
+		}
+		connection := probeOnceWithOptions(port, opts)
+		outcome := connection.GetProbeOutcome()
+		if connection.GetConnectionStatus() != test.expectedStatus || connection.GetFailureReason() != test.expectedReason {
+			t.Error(test.scenarioDescription+", and the connection is:", connection)
+			continue
+		}
+		if test.expectedStatus != ConnectionEstablished {
+			continue
+		}
+		if firstByte, ok := outcome.TimeToFirstByte(); !ok || firstByte > outcome.RequestDuration() {
+			t.Error(test.scenarioDescription+", reporting the time to first byte, and it is:", firstByte, ok)
+		}
+	}
+
+	opts := DefaultConnectOptions()
+	opts.Protocol = "http"
+	opts.HTTP = HTTPProbeConfig{Method: http.MethodGet, Path: "/health"}
+	atomic.StoreInt32(&requests, 0)
+	if connection := probeRepeating(port, opts, 20*time.Millisecond, 200*time.Millisecond); connection.GetConnectionStatus() != ConnectionEstablished ||
+		connection.GetProbeOutcome().Requests() < 3 || int(atomic.LoadInt32(&requests)) < connection.GetProbeOutcome().Requests() ||
+		!repeatedRequestsTimed(connection.GetProbeOutcome()) {
+		t.Error("Requests should be repeated on held connections, and the connection is:", connection,
+			connection.GetProbeOutcome().Requests())
+	}
+
+	opts.HTTP = HTTPProbeConfig{Method: http.MethodGet, Path: "/close"}
+	if connection := probeRepeating(port, opts, 20*time.Millisecond, 200*time.Millisecond); connection.GetConnectionStatus() != ConnectionClosed {
+		t.Error("Connections closed by the server while repeating requests should be reported closed, and the connection is:", connection)
+	}
+}
+
+func TestHTTPProbeIPv6(t *testing.T) {
+	const port = 55566
+	listener, err := net.Listen("tcp", "[::1]:"+strconv.Itoa(port))
+	if err != nil {
+		t.Skip("IPv6 loopback is not available:", err)
+	}
+	hosts := make(chan string, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts <- r.Host
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	opts := DefaultConnectOptions()
+	opts.DialTimeout = time.Second
+	opts.Protocol = "http"
+	connection := probeHostOnce("::1", port, opts)
+	if connection.GetConnectionStatus() != ConnectionEstablished {
+		t.Fatal("Requests against IPv6 targets should get connections established, and the connection is:", connection)
+	}
+	if host := <-hosts; host != "[::1]:"+strconv.Itoa(port) {
+		t.Error("Requests against IPv6 targets should announce them with their port, and the Host is:", host)
+	}
+}
+
+// probeRepeating opens a connection probed as opts describe, repeating its requests every interval while
+// held for hold. It returns the last reported status
+func probeRepeating(port int, opts ConnectOptions, interval time.Duration, hold time.Duration) (connection Connection) {
+	opts.DialTimeout = time.Second
+	opts.RepeatInterval = interval
+	var wg sync.WaitGroup
+	wg.Add(1)
+	statusChannel := make(chan Connection, 100)
+	closeRequest := make(chan bool)
+	go TCPConnectWithOptions(0, "127.0.0.1", port, opts, &wg, statusChannel, closeRequest)
+	time.Sleep(hold)
+	close(closeRequest)
+	wg.Wait()
+	close(statusChannel)
+	for connection = range statusChannel {
+	}
+	return connection
+}
+
+func repeatedRequestsTimed(outcome ProbeOutcome) bool {
+	total, slowest := outcome.RequestsDuration()
+	return total >= slowest && slowest >= outcome.RequestDuration() && total > outcome.RequestDuration()
+}
@@ -0,0 +1,103 @@
+package tcpclient
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/dachad/tcpgoon/debugging"
+)
+
+// IdleResult describes how a connection kept idle went
+type IdleResult struct {
+	// Survived is the longest idle time the connection was checked to still work after
+	Survived time.Duration
+	// Failed is the idle time after which the connection stopped working, 0 if it did not
+	Failed time.Duration
+	// ClosedWhileIdle tells the other end closed the connection while idle, Failed being when, rather
+	// than the connection being found broken when checked
+	ClosedWhileIdle bool
+	// Err is why the connection stopped working, or could not be established
+	Err error
+}
+
+// TCPIdleConnect opens a connection as TCPConnectWithOptions does, and then keeps it idle for each of idleTimes
+// in turn, repeating the requests of its protocol probe after each of them to check it still works. It stops
+// at the first check failing, after the last idle time, or when a close request arrives. The protocol has to
+// be one of RepeatableProtocolNames
+func TCPIdleConnect(host string, port int, opts ConnectOptions, idleTimes []time.Duration,
+	closeRequest <-chan bool) (result IdleResult) {
+	// protocols which requests do not round trip would tell dropped connections still work
+	if err := ValidateRepeatableProtocol(opts.Protocol); err != nil {
+		result.Err = err
+		return result
+	}
+	conn, err := dialUnlessClosed(net.JoinHostPort(host, strconv.Itoa(port)), opts, closeRequest)
+	var connBuf *bufio.Reader
+	if err == nil {
+		conn, connBuf, _, err = prepareConnection(conn, host, opts)
+	}
+	var session *probeSession
+	if err == nil {
+		session, err = runProbe(conn, connBuf, host, port, opts)
+	}
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer conn.Close()
+	closing := make(chan bool)
+	finished := make(chan bool)
+	defer close(finished)
+	go func() {
+		select {
+		case <-closeRequest:
+			close(closing)
+			conn.Close()
+		case <-finished:
+		}
+	}()
+	closed := func() bool {
+		select {
+		case <-closing:
+			result.Err = failure{FailureCancelled, errors.New("Connection closure requested while idle")}
+			return true
+		default:
+			return false
+		}
+	}
+
+	for _, idleTime := range idleTimes {
+		idleSince := time.Now()
+		// as in the hold loop, reading detects the other end closing the connection while idle
+		session.conn.SetReadDeadline(idleSince.Add(idleTime))
+		_, err := session.conn.Reader.Peek(1)
+		if terr, ok := err.(net.Error); !ok || !terr.Timeout() {
+			if closed() {
+				return result
+			}
+			if err == nil {
+				result.Err = errors.New("Unexpected data received while idle")
+				return result
+			}
+			result.Failed = time.Since(idleSince)
+			result.ClosedWhileIdle = true
+			result.Err = err
+			return result
+		}
+		if err := session.repeat(); err != nil {
+			if closed() {
+				return result
+			}
+			result.Failed = idleTime
+			result.Err = err
+			return result
+		}
+		result.Survived = idleTime
+		fmt.Fprintln(debugging.DebugOut, "Connection to", host, "still works after being idle for", idleTime)
+	}
+	return result
+}
@@ -0,0 +1,97 @@
+package tcpclient
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+// echoingWhileActive handles connections sending back every line, until they are idle for longer than
+// idleTimeout. Then, they get closed if close is set, or silently dropped otherwise, as middleboxes do
+func echoingWhileActive(idleTimeout time.Duration, close bool) func(net.Conn) {
+	return func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		lastActive := time.Now()
+		for {
+			if close {
+				conn.SetReadDeadline(time.Now().Add(idleTimeout))
+			}
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if time.Since(lastActive) > idleTimeout {
+				continue
+			}
+			conn.Write([]byte(line))
+			lastActive = time.Now()
+		}
+	}
+}
+
+func TestTCPIdleConnect(t *testing.T) {
+	const port = 55573
+	var idleScenariosChecks = []struct {
+		scenarioDescription     string
+		handle                  func(net.Conn)
+		expectedSurvived        time.Duration
+		expectedFailedMin       time.Duration
+		expectedFailedMax       time.Duration
+		expectedClosedWhileIdle bool
+	}{
+		{
+			scenarioDescription: "Connections silently dropped when idle should fail the first check after the idle timeout",
+			handle:              echoingWhileActive(150*time.Millisecond, false),
+			expectedSurvived:    100 * time.Millisecond,
+			expectedFailedMin:   200 * time.Millisecond,
+			expectedFailedMax:   200 * time.Millisecond,
+		},
+		{
+			scenarioDescription:     "Connections closed when idle should fail when closed, before being checked",
+			handle:                  echoingWhileActive(150*time.Millisecond, true),
+			expectedSurvived:        100 * time.Millisecond,
+			expectedFailedMin:       150 * time.Millisecond,
+			expectedFailedMax:       199 * time.Millisecond,
+			expectedClosedWhileIdle: true,
+		},
+		{
+			scenarioDescription: "Connections never dropped should survive all the idle times",
+			handle:              echoingWhileActive(time.Minute, false),
+			expectedSurvived:    400 * time.Millisecond,
+		},
+	}
+
+	for _, test := range idleScenariosChecks {
+		opts := DefaultConnectOptions()
+		opts.DialTimeout = 300 * time.Millisecond
+		opts.KeepAlive = -1
+		opts.Protocol = "echo"
+		stop := serveFake(t, port, test.handle)
+		result := TCPIdleConnect("127.0.0.1", port, opts,
+			[]time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond},
+			make(chan bool))
+		stop()
+
+		if result.Survived != test.expectedSurvived || result.Failed < test.expectedFailedMin ||
+			result.Failed > test.expectedFailedMax || result.ClosedWhileIdle != test.expectedClosedWhileIdle ||
+			(result.Failed == 0) != (result.Err == nil) {
+			t.Error(test.scenarioDescription+", and it is:", result)
+		}
+	}
+}
+
+func TestTCPIdleConnectProtocols(t *testing.T) {
+	const port = 55573
+	for _, protocol := range []string{ProtocolTCP, "banner", "mysql", "postgres"} {
+		opts := DefaultConnectOptions()
+		opts.DialTimeout = 300 * time.Millisecond
+		opts.Protocol = protocol
+		// no server is needed, as connections are not even dialed
+		result := TCPIdleConnect("127.0.0.1", port, opts, []time.Duration{time.Millisecond}, make(chan bool))
+		if result.Err == nil || result.Err.Error() != ValidateRepeatableProtocol(protocol).Error() {
+			t.Error("Idle connections should not be checked with protocols which requests do not round trip, like",
+				protocol, "and it is:", result)
+		}
+	}
+}
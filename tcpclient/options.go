@@ -0,0 +1,122 @@
+package tcpclient
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"regexp"
+	"time"
+)
+
+// ConnectOptions describes how TCPConnectWithOptions opens and exercises each connection
+type ConnectOptions struct {
+	DialTimeout time.Duration
+	// KeepAlive is the period of the TCP keepalives of the connections. 0 uses the system default, and a
+	// negative one disables them
+	KeepAlive     time.Duration
+	ProxyProtocol ProxyProtocolConfig
+	// TLSConfig, when set, makes connections negotiate TLS right after being established
+	TLSConfig *tls.Config
+	// Payload is sent once the connection is ready (PROXY header sent and TLS negotiated)
+	Payload []byte
+	// Expect, when set, has to match what the server sends back, line by line, for the connection
+	// to be considered established
+	Expect *regexp.Regexp
+	// Protocol the connection is probed with once ready, among ProtocolNames. A connection is not
+	// considered established until its probe completes
+	Protocol string
+	// RepeatInterval, when set, makes the requests of the protocol probe to be repeated with this
+	// interval on held connections (i.e. HTTP keep-alive, or WebSocket pings). Only the protocols among
+	// RepeatableProtocolNames repeat them
+	RepeatInterval time.Duration
+	// HTTP describes the requests of the protocols running over HTTP (http, websocket, h2 and grpc)
+	HTTP HTTPProbeConfig
+	// BannerMatch, when set, has to match the banners of the connections probed with the banner protocol
+	BannerMatch *regexp.Regexp
+	// GRPCHealthService is the service the grpc protocol checks the health of. Empty checks the whole server
+	GRPCHealthService string
+	// Backend tells how to identify the backend serving each connection
+	Backend BackendExtractor
+}
+
+// alpnProtocols the protocols probing connections over TLS negotiate via ALPN
+var alpnProtocols = map[string][]string{
+	"h2":   {"h2"},
+	"grpc": {"h2"},
+}
+
+// DefaultConnectOptions returns the options described by the package level defaults
+func DefaultConnectOptions() ConnectOptions {
+	return ConnectOptions{
+		DialTimeout:   time.Duration(DefaultDialTimeoutInMs) * time.Millisecond,
+		ProxyProtocol: DefaultProxyProtocol,
+		Protocol:      DefaultProtocol,
+		HTTP:          DefaultHTTPProbe,
+	}
+}
+
+// prepareConnection applies to a freshly dialed connection all the steps opts require before
+// considering it established, returning how long the TLS handshake took, if any. The connection
+// is closed when any of them fails
+func prepareConnection(conn net.Conn, host string, opts ConnectOptions) (net.Conn, *bufio.Reader, time.Duration, error) {
+	if err := sendProxyProtocolHeader(conn, opts.ProxyProtocol); err != nil {
+		conn.Close()
+		return nil, nil, 0, err
+	}
+
+	var tlsHandshake time.Duration
+	if opts.TLSConfig != nil {
+		tlsConfig := opts.TLSConfig
+		if tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = host
+		}
+		if alpn := alpnProtocols[opts.Protocol]; alpn != nil && len(tlsConfig.NextProtos) == 0 {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.NextProtos = alpn
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		handshakeStart := time.Now()
+		tlsConn.SetDeadline(handshakeStart.Add(opts.DialTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, nil, 0, failure{FailureTLS, err}
+		}
+		tlsHandshake = time.Since(handshakeStart)
+		tlsConn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+
+	connBuf := bufio.NewReader(conn)
+	if len(opts.Payload) > 0 {
+		if _, err := conn.Write(opts.Payload); err != nil {
+			conn.Close()
+			return nil, nil, 0, err
+		}
+	}
+	if opts.Expect != nil {
+		if err := expectResponse(conn, connBuf, opts.Expect, opts.DialTimeout); err != nil {
+			conn.Close()
+			return nil, nil, 0, err
+		}
+	}
+	return conn, connBuf, tlsHandshake, nil
+}
+
+// expectResponse reads lines from the connection until one of them matches expect, timeout expires
+// or the connection is closed
+func expectResponse(conn net.Conn, connBuf *bufio.Reader, expect *regexp.Regexp, timeout time.Duration) error {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		line, err := connBuf.ReadString('\n')
+		if len(line) > 0 && expect.MatchString(line) {
+			return nil
+		}
+		if err != nil {
+			return failure{FailureUnexpectedResponse,
+				errors.New("Expected response " + expect.String() + " not received: " + err.Error())}
+		}
+	}
+}
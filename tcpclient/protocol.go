@@ -0,0 +1,409 @@
+package tcpclient
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProbePhase is each of the steps a Probe goes through on a connection
+type ProbePhase int
+
+// Phases of a probe, in the order they run
+const (
+	PhaseHandshake ProbePhase = iota + 0
+	PhaseRequest
+	PhaseValidate
+	numberOfProbePhases
+)
+
+// ProbePhases lists all the phases of a probe, in the order they run
+var ProbePhases = []ProbePhase{PhaseHandshake, PhaseRequest, PhaseValidate}
+
+func (p ProbePhase) String() string {
+	switch p {
+	case PhaseHandshake:
+		return "handshake"
+	case PhaseRequest:
+		return "request"
+	case PhaseValidate:
+		return "validate"
+	}
+	return ""
+}
+
+// ProbeConn is the established connection a Probe exercises
+type ProbeConn struct {
+	net.Conn
+	// Reader buffers what the server sends, so reads should go through it
+	Reader *bufio.Reader
+	// Host the connection was opened for, as protocols announcing it expect a name rather than an IP
+	Host string
+	// Port the connection was opened to
+	Port int
+	// firstByteAt is when the response to the current request started arriving, if the probe told
+	firstByteAt time.Time
+	banner      string
+	http2       HTTP2Outcome
+	extractor   BackendExtractor
+	backend     string
+}
+
+// MarkFirstByte records the response to the request has started arriving, so the time to first byte
+// can be reported. Only the first call of each request counts
+func (c *ProbeConn) MarkFirstByte() {
+	if c.firstByteAt.IsZero() {
+		c.firstByteAt = time.Now()
+	}
+}
+
+// Authority returns the host and port the connection was opened for, as HTTP requests announce them: IPv6
+// literals between brackets, and the port left out when it is the default one
+func (c *ProbeConn) Authority() string {
+	defaultPort := 80
+	if _, ok := c.Conn.(*tls.Conn); ok {
+		defaultPort = 443
+	}
+	if c.Port == defaultPort {
+		if strings.Contains(c.Host, ":") {
+			return "[" + c.Host + "]"
+		}
+		return c.Host
+	}
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+// maxBannerLength bounds the banners kept, as they are aggregated across all the connections
+const maxBannerLength = 256
+
+// SetBanner records how the server introduced itself, so the banners of all the connections can be compared
+func (c *ProbeConn) SetBanner(banner string) {
+	if len(banner) > maxBannerLength {
+		banner = banner[:maxBannerLength]
+	}
+	c.banner = banner
+	c.identifyBackend(c.extractor.fromLine(banner))
+}
+
+// Probe checks an application protocol on an established connection. A new Probe is created
+// for each connection, so implementations can keep state between phases
+type Probe interface {
+	// Handshake negotiates whatever the protocol requires before issuing requests
+	Handshake(conn *ProbeConn) error
+	// Request sends the request the service health is checked with
+	Request(conn *ProbeConn) error
+	// Validate reads the response to the request, and checks it is the expected one
+	Validate(conn *ProbeConn) error
+}
+
+// DefaultProtocol is the protocol connections are probed with when not told otherwise
+var DefaultProtocol = ProtocolTCP
+
+// ProtocolTCP just opens the connection, without probing any application protocol
+const ProtocolTCP = "tcp"
+
+// Protocols are the built-in probes, by the name they are selected with. Probes are configured by the
+// options of the connection they are created for
+var Protocols = map[string]func(opts ConnectOptions) Probe{
+	"echo":      func(opts ConnectOptions) Probe { return new(echoProbe) },
+	"http":      func(opts ConnectOptions) Probe { return &httpProbe{config: opts.HTTP} },
+	"redis":     func(opts ConnectOptions) Probe { return new(redisProbe) },
+	"memcached": func(opts ConnectOptions) Probe { return new(memcachedProbe) },
+	"postgres":  func(opts ConnectOptions) Probe { return new(postgresProbe) },
+	"mysql":     func(opts ConnectOptions) Probe { return new(mysqlProbe) },
+	"banner":    func(opts ConnectOptions) Probe { return &bannerProbe{match: opts.BannerMatch} },
+	"websocket": func(opts ConnectOptions) Probe { return &webSocketProbe{config: opts.HTTP} },
+	"h2":        func(opts ConnectOptions) Probe { return &http2Probe{config: opts.HTTP} },
+	"grpc": func(opts ConnectOptions) Probe {
+		return &http2Probe{config: opts.HTTP, grpc: true, grpcService: opts.GRPCHealthService}
+	},
+}
+
+// ProtocolNames returns the names of all the protocols connections can be probed with
+func ProtocolNames() []string {
+	names := []string{ProtocolTCP}
+	for name := range Protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// ValidateProtocol returns an error if protocol is not one of ProtocolNames
+func ValidateProtocol(protocol string) error {
+	if _, ok := Protocols[protocol]; !ok && protocol != ProtocolTCP && protocol != "" {
+		return fmt.Errorf("Protocol %s is not supported. Supported protocols are: %s", protocol,
+			strings.Join(ProtocolNames(), ", "))
+	}
+	return nil
+}
+
+// repeatableProtocols are the protocols which requests make a round trip to the server, so repeating them
+// tells whether held connections still work. The others complete their exchange on the first probe, and
+// repeating them would either do no I/O at all or break the session
+var repeatableProtocols = map[string]bool{
+	"echo":      true,
+	"http":      true,
+	"redis":     true,
+	"memcached": true,
+	"websocket": true,
+	"h2":        true,
+	"grpc":      true,
+}
+
+// RepeatableProtocolNames returns the names of the protocols which requests can be repeated on held connections
+func RepeatableProtocolNames() []string {
+	var names []string
+	for name := range repeatableProtocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateRepeatableProtocol returns an error if protocol is not one of RepeatableProtocolNames
+func ValidateRepeatableProtocol(protocol string) error {
+	if !repeatableProtocols[protocol] {
+		return fmt.Errorf("Requests of protocol %s cannot be repeated on held connections. Protocols which can: %s",
+			protocol, strings.Join(RepeatableProtocolNames(), ", "))
+	}
+	return nil
+}
+
+// ProbeOutcome describes how the probe of a connection went. When requests are repeated on held
+// connections, the timings are the ones of the last request
+type ProbeOutcome struct {
+	// Protocol the connection was probed with. Empty if it was not probed
+	Protocol string
+	// phases keeps how long each phase took, for the phases that completed
+	phases [numberOfProbePhases]time.Duration
+	// completedPhases counts the phases that went fine
+	completedPhases int
+	// firstByte is the time from the request start to the first byte of its response, if known
+	firstByte time.Duration
+	// requests counts the request and validate cycles that went fine, and the time they took
+	requests        int
+	requestsTotal   time.Duration
+	requestsSlowest time.Duration
+	// banner is how the server introduced itself, if the probe told
+	banner string
+	http2  HTTP2Outcome
+	// backend serving the connection, if it could be identified
+	backend string
+}
+
+// NewProbeOutcome describes a probe of protocol that completed as many phases as durations are given
+func NewProbeOutcome(protocol string, phaseDurations ...time.Duration) ProbeOutcome {
+	outcome := ProbeOutcome{Protocol: protocol, completedPhases: len(phaseDurations)}
+	copy(outcome.phases[:], phaseDurations)
+	if outcome.Probed() {
+		outcome.requests = 1
+		outcome.requestsTotal = outcome.RequestDuration()
+		outcome.requestsSlowest = outcome.RequestDuration()
+	}
+	return outcome
+}
+
+// Probed returns true if the connection went through all the phases of its probe
+func (o ProbeOutcome) Probed() bool {
+	return o.Protocol != "" && o.completedPhases == int(numberOfProbePhases)
+}
+
+// PhaseDuration returns how long a phase took, and false if it did not complete
+func (o ProbeOutcome) PhaseDuration(phase ProbePhase) (time.Duration, bool) {
+	if int(phase) >= o.completedPhases {
+		return 0, false
+	}
+	return o.phases[phase], true
+}
+
+// FailedPhase returns the phase the probe failed at, if it did
+func (o ProbeOutcome) FailedPhase() (ProbePhase, bool) {
+	if o.Protocol == "" || o.Probed() {
+		return 0, false
+	}
+	return ProbePhase(o.completedPhases), true
+}
+
+// TimeToFirstByte returns the time from the request start to the first byte of the response, and false
+// if the protocol does not tell
+func (o ProbeOutcome) TimeToFirstByte() (time.Duration, bool) {
+	return o.firstByte, o.firstByte > 0
+}
+
+// RequestDuration returns the time from the request start to the response being validated
+func (o ProbeOutcome) RequestDuration() time.Duration {
+	return o.phases[PhaseRequest] + o.phases[PhaseValidate]
+}
+
+// Requests returns how many requests got a valid response, counting the ones repeated on the held connection
+func (o ProbeOutcome) Requests() int {
+	return o.requests
+}
+
+// RequestsDuration returns the time all the requests with a valid response took, and the slowest of them
+func (o ProbeOutcome) RequestsDuration() (total time.Duration, slowest time.Duration) {
+	return o.requestsTotal, o.requestsSlowest
+}
+
+// Banner returns how the server introduced itself, if the probe told
+func (o ProbeOutcome) Banner() string {
+	return o.banner
+}
+
+// HTTP2 returns how the HTTP/2 connection went, for the protocols running over it
+func (o ProbeOutcome) HTTP2() HTTP2Outcome {
+	return o.http2
+}
+
+// Backend returns the identity of the backend serving the connection, if it could be identified
+func (o ProbeOutcome) Backend() string {
+	return o.backend
+}
+
+// WithBackend returns a copy of the outcome recording backend as the one serving the connection
+func (o ProbeOutcome) WithBackend(backend string) ProbeOutcome {
+	o.backend = backend
+	return o
+}
+
+// WithBanner returns a copy of the outcome recording banner as how the server introduced itself
+func (o ProbeOutcome) WithBanner(banner string) ProbeOutcome {
+	o.banner = banner
+	return o
+}
+
+// WithHTTP2 returns a copy of the outcome recording http2 as how its HTTP/2 connection went
+func (o ProbeOutcome) WithHTTP2(http2 HTTP2Outcome) ProbeOutcome {
+	o.http2 = http2
+	return o
+}
+
+// probeSession keeps the probe of a connection, so its requests can be repeated while the connection is held
+type probeSession struct {
+	probe   Probe
+	conn    *ProbeConn
+	timeout time.Duration
+	outcome ProbeOutcome
+}
+
+// runProbe exercises the protocol of opts on the connection, timing each phase. Each phase is given
+// the dial timeout to complete. The connection is closed when the probe fails. No session is returned
+// if the connection does not need probing
+func runProbe(conn net.Conn, connBuf *bufio.Reader, host string, port int, opts ConnectOptions) (*probeSession, error) {
+	newProbe, ok := Protocols[opts.Protocol]
+	if !ok {
+		return nil, nil
+	}
+	session := &probeSession{
+		probe:   newProbe(opts),
+		conn:    &ProbeConn{Conn: conn, Reader: connBuf, Host: host, Port: port, extractor: opts.Backend},
+		timeout: opts.DialTimeout,
+		outcome: ProbeOutcome{Protocol: opts.Protocol},
+	}
+	err := session.runPhases(ProbePhases)
+	if err != nil {
+		conn.Close()
+	}
+	return session, err
+}
+
+// repeat issues a new request on the connection, and validates its response. The outcome keeps the
+// timings of the last request that went fine
+func (s *probeSession) repeat() error {
+	previous := s.outcome
+	s.outcome.completedPhases = int(PhaseRequest)
+	if err := s.runPhases(ProbePhases[PhaseRequest:]); err != nil {
+		s.outcome = previous
+		// what happened to the connection is kept, as it explains the failure
+		s.outcome.http2 = s.conn.http2
+		return err
+	}
+	return nil
+}
+
+func (s *probeSession) runPhases(phases []ProbePhase) error {
+	phaseFuncs := [numberOfProbePhases]func(*ProbeConn) error{s.probe.Handshake, s.probe.Request, s.probe.Validate}
+	var phasesStart, requestStart time.Time
+	s.conn.firstByteAt = time.Time{}
+	for _, phase := range phases {
+		start := time.Now()
+		if phasesStart.IsZero() {
+			phasesStart = start
+		}
+		if phase == PhaseRequest {
+			requestStart = start
+		}
+		s.conn.SetDeadline(start.Add(s.timeout))
+		err := phaseFuncs[phase](s.conn)
+		s.outcome.phases[phase] = time.Since(start)
+		s.outcome.banner = s.conn.banner
+		s.outcome.http2 = s.conn.http2
+		s.outcome.backend = s.conn.backend
+		if err != nil {
+			return probeFailure(phase, err)
+		}
+		s.outcome.completedPhases++
+	}
+	s.conn.SetDeadline(time.Time{})
+	s.outcome.firstByte = 0
+	if !s.conn.firstByteAt.IsZero() {
+		s.outcome.firstByte = s.conn.firstByteAt.Sub(requestStart)
+		// servers speaking first, like banner ones, answer the connection rather than the request
+		if s.conn.firstByteAt.Before(requestStart) {
+			s.outcome.firstByte = s.conn.firstByteAt.Sub(phasesStart)
+		}
+	}
+	s.outcome.requests++
+	s.outcome.requestsTotal += s.outcome.RequestDuration()
+	if s.outcome.RequestDuration() > s.outcome.requestsSlowest {
+		s.outcome.requestsSlowest = s.outcome.RequestDuration()
+	}
+	return nil
+}
+
+// probeFailure classifies the errors of a probe: responses not validating are unexpected, unless
+// the probe already told why it failed or the server did not answer in time
+func probeFailure(phase ProbePhase, err error) error {
+	err = fmt.Errorf("%s probe phase failed: %w", phase, err)
+	if phase == PhaseValidate {
+		if reason := classifyFailure(err); reason == FailureOther {
+			return failure{FailureUnexpectedResponse, err}
+		}
+	}
+	return err
+}
+
+// echoProbe sends a line and expects the server to send it back, as echo servers do
+type echoProbe struct {
+	line string
+}
+
+func (p *echoProbe) Handshake(conn *ProbeConn) error { return nil }
+
+func (p *echoProbe) Request(conn *ProbeConn) error {
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	p.line = "tcpgoon " + hex.EncodeToString(nonce) + "\n"
+	_, err := conn.Write([]byte(p.line))
+	return err
+}
+
+func (p *echoProbe) Validate(conn *ProbeConn) error {
+	line, err := conn.Reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if line != p.line {
+		return errors.New("Echoed line " + strings.TrimSpace(line) + " does not match the sent one")
+	}
+	return nil
+}
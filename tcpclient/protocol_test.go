@@ -0,0 +1,197 @@
+package tcpclient
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serveFake accepts connections on port, handling each of them with handle, until the returned
+// function is called
+func serveFake(t *testing.T, port int, handle func(net.Conn)) (stop func()) {
+	return serveFakeOn(t, "127.0.0.1:"+strconv.Itoa(port), handle)
+}
+
+// serveFakeOn behaves as serveFake, listening on address
+func serveFakeOn(t *testing.T, address string, handle func(net.Conn)) (stop func()) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal("Could not start the fake server", err)
+	}
+	var handlers sync.WaitGroup
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			handlers.Add(1)
+			go func() {
+				defer handlers.Done()
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return func() {
+		listener.Close()
+		handlers.Wait()
+	}
+}
+
+// probeOnce opens a connection probed with protocol, returning its last reported status
+func probeOnce(port int, protocol string, dialTimeout time.Duration) Connection {
+	opts := DefaultConnectOptions()
+	opts.DialTimeout = dialTimeout
+	opts.Protocol = protocol
+	return probeOnceWithOptions(port, opts)
+}
+
+// probeOnceWithOptions behaves as probeOnce, opening the connection as opts describe
+func probeOnceWithOptions(port int, opts ConnectOptions) Connection {
+	return probeHostOnce("127.0.0.1", port, opts)
+}
+
+// probeHostOnce behaves as probeOnceWithOptions, opening the connection against host
+func probeHostOnce(host string, port int, opts ConnectOptions) Connection {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	statusChannel := make(chan Connection, 3)
+	closeRequest := make(chan bool)
+	go TCPConnectWithOptions(0, host, port, opts, &wg, statusChannel, closeRequest)
+	<-statusChannel // dialing
+	connection := <-statusChannel
+	close(closeRequest)
+	wg.Wait()
+	return connection
+}
+
+func TestEchoProbe(t *testing.T) {
+	const port = 55565
+	var echoScenariosChecks = []struct {
+		scenarioDescription string
+		handle              func(net.Conn)
+		expectedStatus      ConnectionStatus
+		expectedReason      FailureReason
+		expectedFailedPhase ProbePhase
+	}{
+		{
+			scenarioDescription: "Servers echoing the request should get connections established after probing them",
+			handle: func(conn net.Conn) {
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				conn.Write([]byte(line))
+				conn.Read(make([]byte, 1))
+			},
+			expectedStatus: ConnectionEstablished,
+		},
+		{
+			scenarioDescription: "Servers replying something else should fail validation as unexpected responses",
+			handle: func(conn net.Conn) {
+				bufio.NewReader(conn).ReadString('\n')
+				conn.Write([]byte("hello\n"))
+			},
+			expectedStatus:      ConnectionError,
+			expectedReason:      FailureUnexpectedResponse,
+			expectedFailedPhase: PhaseValidate,
+		},
+		{
+			scenarioDescription: "Servers not replying should fail validation by timeout",
+			handle: func(conn net.Conn) {
+				conn.Read(make([]byte, 1024))
+				time.Sleep(300 * time.Millisecond)
+			},
+			expectedStatus:      ConnectionError,
+			expectedReason:      FailureTimeout,
+			expectedFailedPhase: PhaseValidate,
+		},
+	}
+
+	for _, test := range echoScenariosChecks {
+		stop := serveFake(t, port, test.handle)
+		connection := probeOnce(port, "echo", 200*time.Millisecond)
+		stop()
+
+		outcome := connection.GetProbeOutcome()
+		if connection.GetConnectionStatus() != test.expectedStatus || connection.GetFailureReason() != test.expectedReason ||
+			outcome.Protocol != "echo" {
+			t.Error(test.scenarioDescription+", and the connection is:", connection, outcome)
+			continue
+		}
+		if test.expectedStatus == ConnectionEstablished {
+			if !outcome.Probed() {
+				t.Error(test.scenarioDescription+", and its probe did not complete:", outcome)
+			}
+			if validate, ok := outcome.PhaseDuration(PhaseValidate); !ok || validate == 0 {
+				t.Error(test.scenarioDescription+", and its validate phase took:", validate)
+			}
+		} else if phase, failed := outcome.FailedPhase(); !failed || phase != test.expectedFailedPhase {
+			t.Error(test.scenarioDescription+", and the failed phase is:", phase, failed)
+		}
+	}
+}
+
+func TestProbeTimedApartFromConnect(t *testing.T) {
+	const port = 55565
+	const replyDelay = 300 * time.Millisecond
+	stop := serveFake(t, port, func(conn net.Conn) {
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		time.Sleep(replyDelay)
+		conn.Write([]byte(line))
+		conn.Read(make([]byte, 1))
+	})
+	connection := probeOnce(port, "echo", time.Second)
+	stop()
+
+	validate, _ := connection.GetProbeOutcome().PhaseDuration(PhaseValidate)
+	if connection.GetConnectionStatus() != ConnectionEstablished || validate < replyDelay ||
+		connection.GetTCPProcessingDuration() >= replyDelay {
+		t.Error("Connections should time their TCP handshake apart from their probe, and the connection is:",
+			connection, "with a validate phase of", validate)
+	}
+}
+
+func TestValidateProtocol(t *testing.T) {
+	for _, protocol := range ProtocolNames() {
+		if err := ValidateProtocol(protocol); err != nil {
+			t.Error("Protocol", protocol, "should be supported", err)
+		}
+	}
+	if err := ValidateProtocol("gopher"); err == nil {
+		t.Error("Unknown protocols should be rejected")
+	}
+}
+
+func TestValidateRepeatableProtocol(t *testing.T) {
+	var repeatableScenariosChecks = []struct {
+		scenarioDescription string
+		protocol            string
+		expectedRepeatable  bool
+	}{
+		{
+			scenarioDescription: "Protocols which requests round trip to the server should be repeatable",
+			protocol:            "http",
+			expectedRepeatable:  true,
+		},
+		{
+			scenarioDescription: "Protocols which requests open a session should not be repeatable",
+			protocol:            "postgres",
+		},
+		{
+			scenarioDescription: "Protocols which requests do not reach the server should not be repeatable",
+			protocol:            "banner",
+		},
+		{
+			scenarioDescription: "Connections not probed should not be repeatable",
+			protocol:            ProtocolTCP,
+		},
+	}
+
+	for _, test := range repeatableScenariosChecks {
+		if err := ValidateRepeatableProtocol(test.protocol); (err == nil) != test.expectedRepeatable {
+			t.Error(test.scenarioDescription+", and validating it returns:", err)
+		}
+	}
+}
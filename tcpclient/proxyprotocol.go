@@ -0,0 +1,57 @@
+package tcpclient
+
+import (
+	"math/rand"
+	"net"
+
+	"github.com/dachad/tcpgoon/proxyprotocol"
+)
+
+// ProxyProtocolConfig describes the PROXY protocol header to send right after establishing a connection
+type ProxyProtocolConfig struct {
+	// Version 0 disables the header; 1 and 2 select the protocol version
+	Version int
+	// SourceNetwork, when set, replaces the real source address with a random one within this network
+	SourceNetwork *net.IPNet
+}
+
+// DefaultProxyProtocol is applied to all the connections TCPConnect opens
+var DefaultProxyProtocol ProxyProtocolConfig
+
+const firstNonPrivilegedPort = 1024
+
+func (p ProxyProtocolConfig) header(conn net.Conn) proxyprotocol.Header {
+	header := proxyprotocol.Header{
+		Version:     p.Version,
+		Source:      conn.LocalAddr().(*net.TCPAddr),
+		Destination: conn.RemoteAddr().(*net.TCPAddr),
+	}
+	if p.SourceNetwork != nil {
+		header.Source = &net.TCPAddr{
+			IP:   randomIPInNetwork(p.SourceNetwork),
+			Port: firstNonPrivilegedPort + rand.Intn(65536-firstNonPrivilegedPort),
+		}
+	}
+	return header
+}
+
+func randomIPInNetwork(network *net.IPNet) net.IP {
+	ip := make(net.IP, len(network.IP))
+	for i := range ip {
+		ip[i] = network.IP[i]&network.Mask[i] | byte(rand.Intn(256))&^network.Mask[i]
+	}
+	return ip
+}
+
+// sendProxyProtocolHeader writes the PROXY protocol header, if enabled, in the connection
+func sendProxyProtocolHeader(conn net.Conn, config ProxyProtocolConfig) error {
+	if config.Version == 0 {
+		return nil
+	}
+	encodedHeader, err := config.header(conn).Format()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(encodedHeader)
+	return err
+}
@@ -0,0 +1,17 @@
+package tcpclient
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRandomIPInNetwork(t *testing.T) {
+	for _, cidr := range []string{"10.20.0.0/16", "192.168.1.7/32", "2001:db8::/64"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		for i := 0; i < 100; i++ {
+			if ip := randomIPInNetwork(network); !network.Contains(ip) {
+				t.Fatal("Random IP", ip, "does not belong to", network)
+			}
+		}
+	}
+}
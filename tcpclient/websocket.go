@@ -0,0 +1,174 @@
+package tcpclient
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// webSocketAcceptGUID is appended to the key of the upgrade requests to compute the accept header, as RFC 6455 states
+	webSocketAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// maxWebSocketFrameSize bounds the frames read while waiting for the pong frame
+	maxWebSocketFrameSize = 1 << 20
+
+	webSocketOpClose = 0x8
+	webSocketOpPing  = 0x9
+	webSocketOpPong  = 0xa
+)
+
+// webSocketProbe upgrades the connection to a WebSocket during its handshake, using the path and headers of
+// the http protocol, and then sends ping frames expecting their pong frames back
+type webSocketProbe struct {
+	config HTTPProbeConfig
+	ping   []byte
+}
+
+func (p *webSocketProbe) Handshake(conn *ProbeConn) error {
+	req, err := http.NewRequest(http.MethodGet, "http://"+conn.Authority()+p.config.Path, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range p.config.Headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	if host := p.config.Headers.Get("Host"); host != "" {
+		req.Host = host
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req.Header.Set("User-Agent", "tcpgoon")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(conn.Reader, req)
+	if err != nil {
+		return err
+	}
+	conn.identifyBackend(conn.extractor.fromHeader(resp.Header))
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body.Close()
+		return failure{FailureUnexpectedResponse, fmt.Errorf("Unexpected upgrade response status %s", resp.Status)}
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != webSocketAccept(key) {
+		return failure{FailureUnexpectedResponse, errors.New("Upgrade response is not a valid WebSocket handshake")}
+	}
+	return nil
+}
+
+func (p *webSocketProbe) Request(conn *ProbeConn) error {
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	p.ping = []byte("tcpgoon " + hex.EncodeToString(nonce))
+	return writeWebSocketFrame(conn, webSocketOpPing, p.ping)
+}
+
+// Validate waits for the pong frame of the ping sent, answering the pings of the server and skipping any
+// other frame meanwhile
+func (p *webSocketProbe) Validate(conn *ProbeConn) error {
+	for {
+		opcode, payload, err := readWebSocketFrame(conn)
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case webSocketOpPong:
+			if bytes.Equal(payload, p.ping) {
+				return nil
+			}
+		case webSocketOpPing:
+			if err := writeWebSocketFrame(conn, webSocketOpPong, payload); err != nil {
+				return err
+			}
+		case webSocketOpClose:
+			reason := "no status"
+			if len(payload) >= 2 {
+				reason = strconv.Itoa(int(binary.BigEndian.Uint16(payload))) + " " + string(payload[2:])
+			}
+			return errors.New("WebSocket closed by the server: " + reason)
+		}
+	}
+}
+
+// webSocketAccept returns the accept header a server has to reply an upgrade request using key with
+func webSocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + webSocketAcceptGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// writeWebSocketFrame sends a control frame, masked as clients have to
+func writeWebSocketFrame(conn *ProbeConn, opcode byte, payload []byte) error {
+	if len(payload) > 125 {
+		return errors.New("WebSocket control frames payload cannot exceed 125 bytes")
+	}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload)), 0, 0, 0, 0}
+	rand.Read(frame[2:6])
+	for i, b := range payload {
+		frame = append(frame, b^frame[2+i%4])
+	}
+	_, err := conn.Write(frame)
+	return err
+}
+
+// readWebSocketFrame reads the next frame, returning its opcode and its unmasked payload
+func readWebSocketFrame(conn *ProbeConn) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn.Reader, header); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0f
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(conn.Reader, extended); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err := io.ReadFull(conn.Reader, extended); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended)
+	}
+	if length > maxWebSocketFrameSize {
+		return 0, nil, failure{FailureUnexpectedResponse,
+			errors.New("WebSocket frame is bigger than " + strconv.Itoa(maxWebSocketFrameSize) + " bytes")}
+	}
+	var mask []byte
+	if header[1]&0x80 != 0 {
+		mask = make([]byte, 4)
+		if _, err := io.ReadFull(conn.Reader, mask); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(conn.Reader, payload); err != nil {
+		return 0, nil, err
+	}
+	if mask != nil {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
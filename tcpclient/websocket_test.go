@@ -0,0 +1,162 @@
+package tcpclient
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// upgradingWith handles connections replying their upgrade request as upgrade does, and then answering
+// pongs frames up to pongs times before closing the WebSocket
+func upgradingWith(upgrade func(key string) string, pongs int) func(net.Conn) {
+	return func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+		conn.Write([]byte(upgrade(req.Header.Get("Sec-WebSocket-Key"))))
+		probeConn := &ProbeConn{Conn: conn, Reader: reader}
+		for i := 0; i < pongs; i++ {
+			// the server pings too, as clients have to answer it before getting their pong
+			writeWebSocketFrame(probeConn, webSocketOpPing, []byte("server"))
+			for {
+				opcode, payload, err := readWebSocketFrame(probeConn)
+				if err != nil {
+					return
+				}
+				if opcode == webSocketOpPing {
+					writeWebSocketFrame(probeConn, webSocketOpPong, payload)
+					break
+				}
+			}
+		}
+		writeWebSocketFrame(probeConn, webSocketOpClose, []byte{0x03, 0xe9})
+		conn.Read(make([]byte, 1))
+	}
+}
+
+func switchingProtocols(key string) string {
+	return "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + webSocketAccept(key) + "\r\n\r\n"
+}
+
+func TestWebSocketProbe(t *testing.T) {
+	const port = 55570
+	var webSocketScenariosChecks = []struct {
+		scenarioDescription string
+		handle              func(net.Conn)
+		expectedStatus      ConnectionStatus
+		expectedReason      FailureReason
+		expectedFailedPhase ProbePhase
+	}{
+		{
+			scenarioDescription: "Servers upgrading the connection and answering pings should get connections established",
+			handle:              upgradingWith(switchingProtocols, 1),
+			expectedStatus:      ConnectionEstablished,
+		},
+		{
+			scenarioDescription: "Servers rejecting the upgrade should fail the handshake as an unexpected response",
+			handle: upgradingWith(func(string) string {
+				return "HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\n\r\n"
+			}, 0),
+			expectedStatus:      ConnectionError,
+			expectedReason:      FailureUnexpectedResponse,
+			expectedFailedPhase: PhaseHandshake,
+		},
+		{
+			scenarioDescription: "Servers replying a wrong accept header should fail the handshake as an unexpected response",
+			handle: upgradingWith(func(string) string {
+				return switchingProtocols("wrong")
+			}, 0),
+			expectedStatus:      ConnectionError,
+			expectedReason:      FailureUnexpectedResponse,
+			expectedFailedPhase: PhaseHandshake,
+		},
+		{
+			scenarioDescription: "Servers closing the WebSocket instead of answering pings should fail the validation",
+			handle:              upgradingWith(switchingProtocols, 0),
+			expectedStatus:      ConnectionError,
+			expectedReason:      FailureUnexpectedResponse,
+			expectedFailedPhase: PhaseValidate,
+		},
+	}
+
+	for _, test := range webSocketScenariosChecks {
+		stop := serveFake(t, port, test.handle)
+		connection := probeOnce(port, "websocket", time.Second)
+		stop()
+
+		if connection.GetConnectionStatus() != test.expectedStatus || connection.GetFailureReason() != test.expectedReason {
+			t.Error(test.scenarioDescription+", and the connection is:", connection)
+			continue
+		}
+		outcome := connection.GetProbeOutcome()
+		if phase, failed := outcome.FailedPhase(); failed != (test.expectedStatus == ConnectionError) ||
+			(failed && phase != test.expectedFailedPhase) {
+			t.Error(test.scenarioDescription+", and the failed phase is:", phase)
+		}
+		if upgrade, ok := outcome.PhaseDuration(PhaseHandshake); test.expectedStatus == ConnectionEstablished && (!ok || upgrade <= 0) {
+			t.Error(test.scenarioDescription + ", reporting the upgrade latency")
+		}
+	}
+}
+
+func TestWebSocketProbeIPv6(t *testing.T) {
+	const port = 55570
+	if listener, err := net.Listen("tcp", "[::1]:0"); err != nil {
+		t.Skip("IPv6 loopback is not available:", err)
+	} else {
+		listener.Close()
+	}
+	stop := serveFakeOn(t, "[::1]:"+strconv.Itoa(port), upgradingWith(switchingProtocols, 1))
+	opts := DefaultConnectOptions()
+	opts.DialTimeout = time.Second
+	opts.Protocol = "websocket"
+	connection := probeHostOnce("::1", port, opts)
+	stop()
+
+	if connection.GetConnectionStatus() != ConnectionEstablished {
+		t.Error("WebSocket upgrades against IPv6 targets should get connections established, and the connection is:",
+			connection)
+	}
+}
+
+func TestWebSocketProbePings(t *testing.T) {
+	const port = 55570
+	var pingsScenariosChecks = []struct {
+		scenarioDescription string
+		pongs               int
+		expectedStatus      ConnectionStatus
+		expectedRequests    int
+	}{
+		{
+			scenarioDescription: "WebSockets answering all the pings should be alive at closure",
+			pongs:               100,
+			expectedStatus:      ConnectionEstablished,
+		},
+		{
+			scenarioDescription: "WebSockets closed by the server while held should be reported as closed",
+			pongs:               2,
+			expectedStatus:      ConnectionClosed,
+			expectedRequests:    2,
+		},
+	}
+
+	opts := DefaultConnectOptions()
+	opts.Protocol = "websocket"
+	for _, test := range pingsScenariosChecks {
+		stop := serveFake(t, port, upgradingWith(switchingProtocols, test.pongs))
+		connection := probeRepeating(port, opts, 50*time.Millisecond, 400*time.Millisecond)
+		stop()
+
+		requests := connection.GetProbeOutcome().Requests()
+		if connection.GetConnectionStatus() != test.expectedStatus ||
+			(test.expectedRequests > 0 && requests != test.expectedRequests) || requests < 2 {
+			t.Error(test.scenarioDescription+", and the connection is:", connection, "after", requests, "pings")
+		}
+	}
+}
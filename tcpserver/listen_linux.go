@@ -0,0 +1,86 @@
+package tcpserver
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// soReusePort is SO_REUSEPORT, which the syscall package does not define for linux
+const soReusePort = 0xf
+
+// listenTCP opens a TCP listener in all the interfaces. Sockets are created by hand, as the net package
+// does not allow to tune the listen backlog; 0 means the system default (somaxconn)
+func listenTCP(port int, reusePort bool, backlog int) (net.Listener, error) {
+	if !reusePort && backlog == 0 {
+		return net.Listen("tcp", ":"+strconv.Itoa(port))
+	}
+
+	fd, err := newListeningSocket(port, reusePort, backlog)
+	if err != nil {
+		return nil, os.NewSyscallError("listen", err)
+	}
+	file := os.NewFile(uintptr(fd), "tcpgoon-listener-"+strconv.Itoa(port))
+	defer file.Close()
+	return net.FileListener(file)
+}
+
+func newListeningSocket(port int, reusePort bool, backlog int) (fd int, err error) {
+	var sockaddr syscall.Sockaddr = &syscall.SockaddrInet6{Port: port}
+	fd, err = syscall.Socket(syscall.AF_INET6, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, syscall.IPPROTO_TCP)
+	if err == syscall.EAFNOSUPPORT {
+		sockaddr = &syscall.SockaddrInet4{Port: port}
+		fd, err = syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, syscall.IPPROTO_TCP)
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	options := map[int]int{syscall.SO_REUSEADDR: 1}
+	if reusePort {
+		options[soReusePort] = 1
+	}
+	for option, value := range options {
+		if err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, option, value); err != nil {
+			syscall.Close(fd)
+			return -1, err
+		}
+	}
+	if _, ok := sockaddr.(*syscall.SockaddrInet6); ok {
+		// dual stack, as net.Listen does
+		if err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, 0); err != nil {
+			syscall.Close(fd)
+			return -1, err
+		}
+	}
+
+	if backlog == 0 {
+		backlog = systemMaxBacklog()
+	}
+	if err = syscall.Bind(fd, sockaddr); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	if err = syscall.Listen(fd, backlog); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// systemMaxBacklog mimics the net package, which uses somaxconn as the backlog of its listeners.
+// Bigger backlogs are silently truncated by the kernel to this value, too
+func systemMaxBacklog() int {
+	content, err := ioutil.ReadFile("/proc/sys/net/core/somaxconn")
+	if err != nil {
+		return syscall.SOMAXCONN
+	}
+	backlog, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || backlog <= 0 {
+		return syscall.SOMAXCONN
+	}
+	return backlog
+}
@@ -0,0 +1,87 @@
+package tcpserver
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTcpServerReusePortListeners(t *testing.T) {
+	const listenersPerPort = 4
+	const connections = 20
+	dispatcher := &Dispatcher{
+		Handlers:         make(map[string]*Handler),
+		Lock:             sync.RWMutex{},
+		ListenersPerPort: listenersPerPort,
+		Backlog:          16,
+	}
+	if err := dispatcher.Start(context.Background(), []int{8894}); err != nil {
+		t.Fatal("Could not start the TCP server with SO_REUSEPORT listeners", err)
+	}
+	defer dispatcher.Shutdown(context.Background())
+
+	if len(dispatcher.listeners) != listenersPerPort {
+		t.Error("Dispatcher should have opened", listenersPerPort, "listeners, and it has", len(dispatcher.listeners))
+	}
+
+	for i := 0; i < connections; i++ {
+		conn, err := net.Dial("tcp", "127.0.0.1:8894")
+		if err != nil {
+			t.Fatal("Could not connect to TCP server", err)
+		}
+		defer conn.Close()
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	if stats := dispatcher.PortStats()[8894]; stats.AcceptedConnections != connections {
+		t.Error("All the connections should be accounted in the port stats, and they are:", stats)
+	}
+}
+
+func TestTcpServerReusePortListenersMaxConnections(t *testing.T) {
+	const maxConnections = 5
+	dispatcher := &Dispatcher{
+		Handlers:         make(map[string]*Handler),
+		Lock:             sync.RWMutex{},
+		ListenersPerPort: 4,
+		MaxConnections:   maxConnections,
+	}
+	if err := dispatcher.Start(context.Background(), []int{8899}); err != nil {
+		t.Fatal("Could not start the TCP server with SO_REUSEPORT listeners", err)
+	}
+	defer dispatcher.Shutdown(context.Background())
+
+	var dialers sync.WaitGroup
+	for i := 0; i < 4*maxConnections; i++ {
+		dialers.Add(1)
+		go func() {
+			defer dialers.Done()
+			if conn, err := net.Dial("tcp", "127.0.0.1:8899"); err == nil {
+				time.Sleep(200 * time.Millisecond)
+				conn.Close()
+			}
+		}()
+	}
+	dialers.Wait()
+	<-dispatcher.Done()
+
+	if stats := dispatcher.PortStats()[8899]; stats.AcceptedConnections != maxConnections {
+		t.Error("Listeners of the same port should not accept more than the max connections together, and they accepted:",
+			stats.AcceptedConnections)
+	}
+}
+
+func TestListenTCPWithoutReusePort(t *testing.T) {
+	ln, err := listenTCP(8895, false, 16)
+	if err != nil {
+		t.Fatal("Could not open a listener with a custom backlog", err)
+	}
+	defer ln.Close()
+
+	if second, err := listenTCP(8895, false, 16); err == nil {
+		second.Close()
+		t.Error("A second listener without SO_REUSEPORT should not be able to bind the same port")
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package tcpserver
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strconv"
+)
+
+// listenTCP opens a TCP listener in all the interfaces. Multiple listeners per port and backlog
+// tuning are only supported on linux
+func listenTCP(port int, reusePort bool, backlog int) (net.Listener, error) {
+	if reusePort {
+		return nil, errors.New("Multiple listeners per port (SO_REUSEPORT) are only supported on linux")
+	}
+	if backlog != 0 {
+		log.Println("Listen backlog tuning is only supported on linux, using the system default")
+	}
+	return net.Listen("tcp", ":"+strconv.Itoa(port))
+}
@@ -0,0 +1,49 @@
+package tcpserver
+
+import (
+	"bufio"
+	"net"
+	"time"
+
+	"github.com/dachad/tcpgoon/proxyprotocol"
+)
+
+// bufferedConn keeps serving the bytes that were buffered while looking for the PROXY protocol header
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func readProxyProtocolHeader(conn net.Conn) (net.Conn, *proxyprotocol.Header, error) {
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(proxyProtocolReadTimeout))
+	header, err := proxyprotocol.Read(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+	return &bufferedConn{Conn: conn, reader: reader}, header, nil
+}
+
+// countOriginalClient accounts a connection proxied on behalf of ip. Lock must be held by the caller
+func (d *Dispatcher) countOriginalClient(ip string) {
+	if d.originalClients == nil {
+		d.originalClients = make(map[string]int)
+	}
+	d.originalClients[ip]++
+}
+
+// OriginalClients returns how many connections were proxied on behalf of each client IP
+func (d *Dispatcher) OriginalClients() map[string]int {
+	d.Lock.RLock()
+	defer d.Lock.RUnlock()
+	snapshot := make(map[string]int, len(d.originalClients))
+	for ip, connections := range d.originalClients {
+		snapshot[ip] = connections
+	}
+	return snapshot
+}
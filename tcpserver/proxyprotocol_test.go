@@ -0,0 +1,43 @@
+package tcpserver
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTcpServerProxyProtocol(t *testing.T) {
+	dispatcher := &Dispatcher{
+		Handlers:      make(map[string]*Handler),
+		Lock:          sync.RWMutex{},
+		ProxyProtocol: true,
+	}
+	if err := dispatcher.Start(context.Background(), []int{8896}); err != nil {
+		t.Fatal("Could not start the TCP server", err)
+	}
+	defer dispatcher.Shutdown(context.Background())
+
+	for _, payload := range []string{
+		"PROXY TCP4 10.1.2.3 127.0.0.1 40000 8896\r\nhello\n",
+		"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c\x0a\x01\x02\x03\x7f\x00\x00\x01\x9c\x40\x22\xc0",
+		"this is not a PROXY protocol header\n",
+	} {
+		conn, err := net.Dial("tcp", "127.0.0.1:8896")
+		if err != nil {
+			t.Fatal("Could not connect to TCP server", err)
+		}
+		conn.Write([]byte(payload))
+		defer conn.Close()
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	stats := dispatcher.Stats()
+	if stats.ProxiedConnections != 2 || stats.ProxyHeaderErrors != 1 {
+		t.Error("PROXY protocol headers should be accounted in the stats, and they are:", stats)
+	}
+	if clients := dispatcher.OriginalClients(); clients["10.1.2.3"] != 2 {
+		t.Error("Original clients should be reported, and they are:", clients)
+	}
+}
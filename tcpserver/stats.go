@@ -0,0 +1,27 @@
+package tcpserver
+
+import (
+	"fmt"
+)
+
+// Stats summarizes what the dispatcher has been dealing with
+type Stats struct {
+	AcceptedConnections  int
+	ActiveConnections    int
+	TLSHandshakeFailures int
+	ProxiedConnections   int
+	ProxyHeaderErrors    int
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("Accepted: %d, Active: %d, TLS handshake failures: %d, Proxied: %d, PROXY header errors: %d",
+		s.AcceptedConnections, s.ActiveConnections, s.TLSHandshakeFailures, s.ProxiedConnections, s.ProxyHeaderErrors)
+}
+
+func (s *Stats) add(other Stats) {
+	s.AcceptedConnections += other.AcceptedConnections
+	s.ActiveConnections += other.ActiveConnections
+	s.TLSHandshakeFailures += other.TLSHandshakeFailures
+	s.ProxiedConnections += other.ProxiedConnections
+	s.ProxyHeaderErrors += other.ProxyHeaderErrors
+}
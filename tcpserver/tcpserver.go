@@ -2,11 +2,13 @@ package tcpserver
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"log"
 	"net"
-	"strconv"
+	"sort"
 	"sync"
 	"time"
 )
@@ -15,6 +17,8 @@ import (
 type Handler struct {
 	conn   net.Conn
 	closed chan bool
+	// echo sends back every line received
+	echo bool
 }
 
 // Listen : listen connection for incomming data
@@ -24,6 +28,9 @@ func (h *Handler) listen() {
 	for {
 		line, _, err := bf.ReadLine()
 		log.Println(line)
+		if err == nil && h.echo {
+			_, err = h.conn.Write(append(line, '\n'))
+		}
 		if err != nil {
 			if err == io.EOF {
 				log.Println("End connection")
@@ -38,72 +45,327 @@ func (h *Handler) listen() {
 type Dispatcher struct {
 	Handlers map[string]*Handler //`type:"map[ip]*Handler"`
 	Lock     sync.RWMutex
+	// TLSConfig, when set, makes the dispatcher negotiate TLS on every accepted connection
+	TLSConfig *tls.Config
+	// MaxConnections, when different from 0, stops accepting connections once reached
+	MaxConnections int
+	// ListenersPerPort, when bigger than 1, opens that many SO_REUSEPORT listeners on each port,
+	// each of them with its own accepting goroutine
+	ListenersPerPort int
+	// Backlog sets the listen backlog of the sockets. 0 means the system default
+	Backlog int
+	// ProxyProtocol makes the dispatcher expect a PROXY protocol header (v1 or v2) on every connection
+	ProxyProtocol bool
+	// Echo makes handlers send back every line they receive, and disables TCP keepalives, so clients
+	// can check when idle connections stop working
+	Echo            bool
+	stats           map[int]*Stats // per listening port
+	originalClients map[string]int // per IP, as reported by PROXY protocol headers
+
+	listeners         []net.Listener
+	servedConnections int
+	pendingHandlers   int
+	forceClose        bool
+	forciblyClosed    []string
+	done              chan struct{}
+	stopOnce          sync.Once
+	acceptersWaiter   sync.WaitGroup
+	handlersWaiter    sync.WaitGroup
+}
+
+// ShutdownReport describes the state of the handlers when the dispatcher was shut down
+type ShutdownReport struct {
+	// Drained counts the handlers that finished by themselves during the grace period
+	Drained int
+	// StillOpen lists the remote addresses of the handlers the shutdown had to close
+	StillOpen []string
+}
+
+const (
+	tlsHandshakeTimeout      = 10 * time.Second
+	proxyProtocolReadTimeout = 10 * time.Second
+)
+
+// portStats returns the counters of a listening port. Lock must be held by the caller
+func (d *Dispatcher) portStats(port int) *Stats {
+	if d.stats == nil {
+		d.stats = make(map[int]*Stats)
+	}
+	if _, ok := d.stats[port]; !ok {
+		d.stats[port] = new(Stats)
+	}
+	return d.stats[port]
 }
 
-func (d *Dispatcher) addHandler(conn net.Conn) {
+// Stats returns a snapshot of the dispatcher counters, aggregating all listening ports
+func (d *Dispatcher) Stats() (total Stats) {
+	d.Lock.RLock()
+	defer d.Lock.RUnlock()
+	for _, stats := range d.stats {
+		total.add(*stats)
+	}
+	return total
+}
+
+// PortStats returns a snapshot of the dispatcher counters of each listening port
+func (d *Dispatcher) PortStats() map[int]Stats {
+	d.Lock.RLock()
+	defer d.Lock.RUnlock()
+	snapshot := make(map[int]Stats, len(d.stats))
+	for port, stats := range d.stats {
+		snapshot[port] = *stats
+	}
+	return snapshot
+}
+
+func (d *Dispatcher) addHandler(conn net.Conn, port int) {
+	defer func() {
+		d.Lock.Lock()
+		d.pendingHandlers--
+		d.Lock.Unlock()
+		d.handlersWaiter.Done()
+	}()
 	addr := conn.RemoteAddr().String()
-	handler := &Handler{conn, make(chan bool, 1)}
+	handler := &Handler{conn: conn, closed: make(chan bool, 1), echo: d.Echo}
 
+	// handlers get registered before any negotiation, so a shutdown can also interrupt them
 	d.Lock.Lock()
+	if d.forceClose {
+		d.forciblyClosed = append(d.forciblyClosed, addr)
+		d.Lock.Unlock()
+		conn.Close()
+		return
+	}
 	d.Handlers[addr] = handler
 	d.Lock.Unlock()
 
+	if d.ProxyProtocol {
+		proxiedConn, header, err := readProxyProtocolHeader(conn)
+		if err != nil {
+			log.Println("PROXY protocol header from", addr, "could not be read:", err)
+			d.dropHandler(addr, port, conn, func(stats *Stats) { stats.ProxyHeaderErrors++ })
+			return
+		}
+		d.Lock.Lock()
+		handler.conn = proxiedConn
+		if header.Source != nil {
+			d.portStats(port).ProxiedConnections++
+			d.countOriginalClient(header.Source.IP.String())
+		}
+		d.Lock.Unlock()
+		log.Println(addr, "sent", header)
+		conn = proxiedConn
+	}
+
+	if d.TLSConfig != nil {
+		tlsConn, err := d.tlsHandshake(conn)
+		if err != nil {
+			log.Println("TLS handshake with", addr, "failed:", err)
+			d.dropHandler(addr, port, conn, func(stats *Stats) { stats.TLSHandshakeFailures++ })
+			return
+		}
+		d.Lock.Lock()
+		handler.conn = tlsConn
+		d.Lock.Unlock()
+	}
+
+	d.Lock.Lock()
+	d.portStats(port).ActiveConnections++
+	d.Lock.Unlock()
+
 	go handler.listen()
 
 	<-handler.closed // when connection closed, remove handler from handlers
 	d.Lock.Lock()
 	delete(d.Handlers, addr)
+	d.portStats(port).ActiveConnections--
 	d.Lock.Unlock()
 }
 
-// ListenHandlers : start listening on the handler
-func (d *Dispatcher) ListenHandlersComplete(port int, maxconnections int, duration int, end_waiter *sync.WaitGroup) error {
-	if duration != 0 {
-		timer := time.NewTimer(time.Duration(duration) * time.Second)
-		go func() {
-			// https://gobyexample.com/timers
-			<-timer.C
-			fmt.Println("Reached max duration:", duration, "seconds")
-			end_waiter.Done()
-		}()
+// dropHandler closes a connection that failed its negotiation, accounting the failure in the port stats
+func (d *Dispatcher) dropHandler(addr string, port int, conn net.Conn, countFailure func(*Stats)) {
+	conn.Close()
+	d.Lock.Lock()
+	delete(d.Handlers, addr)
+	countFailure(d.portStats(port))
+	d.Lock.Unlock()
+}
+
+func (d *Dispatcher) tlsHandshake(conn net.Conn) (*tls.Conn, error) {
+	tlsConn := tls.Server(conn, d.TLSConfig)
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// Start opens ListenersPerPort listeners per port and accepts connections in the background, until ctx is done,
+// MaxConnections are accepted or Shutdown is called. A dispatcher can only be started once
+func (d *Dispatcher) Start(ctx context.Context, ports []int) error {
+	listenersPerPort := d.ListenersPerPort
+	if listenersPerPort < 1 {
+		listenersPerPort = 1
 	}
 
-	sport := strconv.Itoa(port)
+	listeners := make([]net.Listener, 0, len(ports)*listenersPerPort)
+	listenersPorts := make([]int, 0, len(ports)*listenersPerPort)
+	for _, port := range ports {
+		for i := 0; i < listenersPerPort; i++ {
+			ln, err := listenTCP(port, listenersPerPort > 1, d.Backlog)
+			if err != nil {
+				log.Println(err)
+				for _, opened := range listeners {
+					opened.Close()
+				}
+				return err
+			}
+			listeners = append(listeners, ln)
+			listenersPorts = append(listenersPorts, port)
+		}
+	}
 
-	ln, err := net.Listen("tcp", ":"+sport)
-	if err != nil {
-		log.Println(err)
-		return err
+	d.Lock.Lock()
+	d.listeners = listeners
+	done := d.doneChannel()
+	for _, port := range ports {
+		d.portStats(port)
 	}
+	d.Lock.Unlock()
 
-	defer ln.Close()
+	for i, ln := range listeners {
+		d.acceptersWaiter.Add(1)
+		go d.acceptConnections(ln, listenersPorts[i])
+	}
 
-	served_connections := 0
-	for {
-		if maxconnections != 0 && served_connections == maxconnections {
-			fmt.Println("Reached max number of connections:", maxconnections)
-			end_waiter.Done()
-			return nil
+	go func() {
+		select {
+		case <-ctx.Done():
+			d.stopAccepting()
+		case <-done:
+		}
+	}()
+	return nil
+}
+
+// Done returns a channel that is closed when the dispatcher stops accepting connections
+func (d *Dispatcher) Done() <-chan struct{} {
+	d.Lock.Lock()
+	defer d.Lock.Unlock()
+	return d.doneChannel()
+}
+
+// doneChannel returns the channel closed when the dispatcher stops accepting connections, creating it
+// on first use, so dispatchers never started or failing to start can be waited for and shut down too.
+// Lock must be held by the caller
+func (d *Dispatcher) doneChannel() chan struct{} {
+	if d.done == nil {
+		d.done = make(chan struct{})
+	}
+	return d.done
+}
+
+func (d *Dispatcher) stopAccepting() {
+	d.stopOnce.Do(func() {
+		d.Lock.Lock()
+		defer d.Lock.Unlock()
+		close(d.doneChannel())
+		for _, ln := range d.listeners {
+			ln.Close()
+		}
+	})
+}
+
+// Shutdown stops accepting connections and waits for the active handlers to finish until ctx
+// is done. Handlers still open at that point are closed, and reported back
+func (d *Dispatcher) Shutdown(ctx context.Context) (report ShutdownReport) {
+	d.stopAccepting()
+	d.acceptersWaiter.Wait()
+
+	d.Lock.RLock()
+	openAtShutdown := d.pendingHandlers
+	d.Lock.RUnlock()
+
+	handlersDone := make(chan struct{})
+	go func() {
+		d.handlersWaiter.Wait()
+		close(handlersDone)
+	}()
+
+	select {
+	case <-handlersDone:
+	case <-ctx.Done():
+		d.Lock.Lock()
+		d.forceClose = true
+		for addr, handler := range d.Handlers {
+			d.forciblyClosed = append(d.forciblyClosed, addr)
+			handler.conn.Close()
 		}
+		d.Lock.Unlock()
+		<-handlersDone
+	}
+
+	d.Lock.RLock()
+	report.StillOpen = append(report.StillOpen, d.forciblyClosed...)
+	d.Lock.RUnlock()
+	sort.Strings(report.StillOpen)
+	report.Drained = openAtShutdown - len(report.StillOpen)
+	return report
+}
 
+func (d *Dispatcher) acceptConnections(ln net.Listener, port int) {
+	defer d.acceptersWaiter.Done()
+	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			log.Println(err)
+			select {
+			case <-d.done:
+				return
+			default:
+				log.Println(err)
+				continue
+			}
+		}
+
+		d.Lock.Lock()
+		if d.MaxConnections != 0 && d.servedConnections >= d.MaxConnections {
+			// another listener accepted the last connection allowed meanwhile, and is closing them all
+			d.Lock.Unlock()
+			conn.Close()
 			continue
 		}
-		fmt.Println(conn.RemoteAddr())
+		d.portStats(port).AcceptedConnections++
+		d.servedConnections++
+		d.pendingHandlers++
+		maxReached := d.MaxConnections != 0 && d.servedConnections >= d.MaxConnections
+		d.Lock.Unlock()
 
+		fmt.Println(conn.RemoteAddr())
 		tcpconn := conn.(*net.TCPConn)
-		tcpconn.SetKeepAlive(true)
-		tcpconn.SetKeepAlivePeriod(10 * time.Second)
+		if d.Echo {
+			tcpconn.SetKeepAlive(false)
+		} else {
+			tcpconn.SetKeepAlive(true)
+			tcpconn.SetKeepAlivePeriod(10 * time.Second)
+		}
 
-		go d.addHandler(conn)
+		d.handlersWaiter.Add(1)
+		go d.addHandler(conn, port)
 
-		served_connections++
+		if maxReached {
+			fmt.Println("Reached max number of connections:", d.MaxConnections)
+			d.stopAccepting()
+			return
+		}
 	}
 }
 
+// ListenHandlers : start listening on the handler, blocking until the dispatcher stops accepting
 func (d *Dispatcher) ListenHandlers(port int) error {
-	var fake_waiter sync.WaitGroup
-	return d.ListenHandlersComplete(port, 0, 0, &fake_waiter)
+	if err := d.Start(context.Background(), []int{port}); err != nil {
+		return err
+	}
+	<-d.Done()
+	return nil
 }
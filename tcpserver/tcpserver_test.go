@@ -1,7 +1,10 @@
 package tcpserver
 
 import (
+	"bufio"
+	"context"
 	"net"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
@@ -35,3 +38,169 @@ func TestTcpServer(t *testing.T) {
 	}
 	conn.Close()
 }
+
+func TestTcpServerMultiplePorts(t *testing.T) {
+	ports := []int{8890, 8891}
+	dispatcher := &Dispatcher{
+		Handlers:       make(map[string]*Handler),
+		Lock:           sync.RWMutex{},
+		MaxConnections: len(ports),
+	}
+	if err := dispatcher.Start(context.Background(), ports); err != nil {
+		t.Fatal("Could not start the TCP server", err)
+	}
+	defer dispatcher.Shutdown(context.Background())
+
+	for _, port := range ports {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+		if err != nil {
+			t.Fatal("Could not connect to TCP server port", port, err)
+		}
+		defer conn.Close()
+	}
+
+	select {
+	case <-dispatcher.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("Reaching the max number of connections across all ports should stop the dispatcher")
+	}
+
+	portStats := dispatcher.PortStats()
+	for _, port := range ports {
+		if portStats[port].AcceptedConnections != 1 {
+			t.Error("Port", port, "should have accepted a single connection, and its stats are:", portStats[port])
+		}
+	}
+	if dispatcher.Stats().AcceptedConnections != len(ports) {
+		t.Error("Aggregated stats do not match, and they are:", dispatcher.Stats())
+	}
+}
+
+func TestTcpServerShutdown(t *testing.T) {
+	var shutdownScenariosChecks = []struct {
+		scenarioDescription string
+		port                int
+		clientClosesFirst   bool
+		expectedDrained     int
+		expectedStillOpen   int
+	}{
+		{
+			scenarioDescription: "Connections closed by the client during the grace period should be reported as drained",
+			port:                8892,
+			clientClosesFirst:   true,
+			expectedDrained:     1,
+			expectedStillOpen:   0,
+		},
+		{
+			scenarioDescription: "Connections still open after the grace period should be closed and reported",
+			port:                8893,
+			clientClosesFirst:   false,
+			expectedDrained:     0,
+			expectedStillOpen:   1,
+		},
+	}
+
+	for _, test := range shutdownScenariosChecks {
+		ctx, cancel := context.WithCancel(context.Background())
+		dispatcher := &Dispatcher{
+			Handlers: make(map[string]*Handler),
+			Lock:     sync.RWMutex{},
+		}
+		if err := dispatcher.Start(ctx, []int{test.port}); err != nil {
+			t.Fatal("Could not start the TCP server", err)
+		}
+		conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(test.port))
+		if err != nil {
+			t.Fatal("Could not connect to TCP server", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		cancel()
+		<-dispatcher.Done()
+		if test.clientClosesFirst {
+			time.AfterFunc(100*time.Millisecond, func() { conn.Close() })
+		}
+		graceCtx, graceCancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		report := dispatcher.Shutdown(graceCtx)
+		graceCancel()
+		conn.Close()
+
+		if report.Drained != test.expectedDrained || len(report.StillOpen) != test.expectedStillOpen {
+			t.Error(test.scenarioDescription+", and the report is:", report)
+		}
+		if _, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(test.port)); err == nil {
+			t.Error("Listener should be closed after the shutdown")
+		}
+	}
+}
+
+func TestTcpServerShutdownNotStarted(t *testing.T) {
+	const port = 8898
+	busy, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal("Could not occupy the port", err)
+	}
+	defer busy.Close()
+
+	var notStartedScenariosChecks = []struct {
+		scenarioDescription string
+		start               func(*Dispatcher)
+	}{
+		{
+			scenarioDescription: "Dispatchers never started should be shut down without handlers",
+			start:               func(*Dispatcher) {},
+		},
+		{
+			scenarioDescription: "Dispatchers which failed to start should be shut down without handlers",
+			start: func(dispatcher *Dispatcher) {
+				if err := dispatcher.Start(context.Background(), []int{port}); err == nil {
+					t.Error("Dispatchers should fail to start on ports already in use")
+				}
+			},
+		},
+	}
+
+	for _, test := range notStartedScenariosChecks {
+		dispatcher := &Dispatcher{
+			Handlers: make(map[string]*Handler),
+			Lock:     sync.RWMutex{},
+		}
+		test.start(dispatcher)
+		done := dispatcher.Done()
+		report := dispatcher.Shutdown(context.Background())
+		if report.Drained != 0 || len(report.StillOpen) != 0 {
+			t.Error(test.scenarioDescription+", and the report is:", report)
+		}
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error(test.scenarioDescription + ", and stop accepting connections, but Done was not closed")
+		}
+	}
+}
+
+func TestTcpServerEcho(t *testing.T) {
+	dispatcher := &Dispatcher{
+		Handlers: make(map[string]*Handler),
+		Lock:     sync.RWMutex{},
+		Echo:     true,
+	}
+	if err := dispatcher.Start(context.Background(), []int{8897}); err != nil {
+		t.Fatal("Could not start the TCP server", err)
+	}
+	defer dispatcher.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", "127.0.0.1:8897")
+	if err != nil {
+		t.Fatal("Could not connect to TCP server", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for _, line := range []string{"hello\n", "tcpgoon 0123456789abcdef\n"} {
+		conn.Write([]byte(line))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if echoed, err := reader.ReadString('\n'); echoed != line {
+			t.Error("Echo mode should send back every line received, and it replied:", echoed, err)
+		}
+	}
+}
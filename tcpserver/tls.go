@@ -0,0 +1,109 @@
+package tcpserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// TLSOptions describes how the TLS layer of the server should be configured. When no certificate
+// and key are supplied, a self-signed certificate is generated in memory
+type TLSOptions struct {
+	CertFile          string
+	KeyFile           string
+	ClientCAFile      string
+	RequireClientCert bool
+}
+
+const selfSignedCertificateValidity = 365 * 24 * time.Hour
+
+// NewTLSConfig builds the tls.Config the Dispatcher will use to wrap the accepted connections
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case opts.CertFile != "" && opts.KeyFile != "":
+		cert, err = tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	case opts.CertFile == "" && opts.KeyFile == "":
+		cert, err = generateSelfSignedCertificate()
+	default:
+		err = errors.New("TLS certificate and key must be provided together")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.NoClientCert,
+	}
+
+	if opts.ClientCAFile != "" {
+		pemCAs, err := ioutil.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pemCAs) {
+			return nil, errors.New("No valid certificates found in " + opts.ClientCAFile)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if opts.RequireClientCert {
+		if config.ClientCAs == nil {
+			return nil, errors.New("Requiring client certificates needs a client CA file")
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// generateSelfSignedCertificate creates an ephemeral certificate valid for the loopback addresses
+// and the local hostname, so clients can be tested without managing any PKI
+func generateSelfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	notBefore := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{Organization: []string{"tcpgoon"}, CommonName: "localhost"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(selfSignedCertificateValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{derBytes},
+		PrivateKey:  key,
+	}, nil
+}
@@ -0,0 +1,80 @@
+package tcpserver
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewTLSConfigSelfSigned(t *testing.T) {
+	config, err := NewTLSConfig(TLSOptions{})
+	if err != nil {
+		t.Fatal("Could not generate a self-signed TLS config", err)
+	}
+	if len(config.Certificates) != 1 {
+		t.Error("Self-signed TLS config should hold a single certificate")
+	}
+	if config.ClientAuth != tls.NoClientCert {
+		t.Error("Client certificates should not be requested by default")
+	}
+}
+
+func TestNewTLSConfigInvalidOptions(t *testing.T) {
+	var invalidOptionsScenarios = []struct {
+		scenarioDescription string
+		options             TLSOptions
+	}{
+		{
+			scenarioDescription: "Certificate without key should be rejected",
+			options:             TLSOptions{CertFile: "cert.pem"},
+		},
+		{
+			scenarioDescription: "Requiring client certificates without a CA should be rejected",
+			options:             TLSOptions{RequireClientCert: true},
+		},
+		{
+			scenarioDescription: "Non existing client CA file should be rejected",
+			options:             TLSOptions{ClientCAFile: "/nonexistent/ca.pem"},
+		},
+	}
+
+	for _, test := range invalidOptionsScenarios {
+		if _, err := NewTLSConfig(test.options); err == nil {
+			t.Error(test.scenarioDescription)
+		}
+	}
+}
+
+func TestTLSServerHandshake(t *testing.T) {
+	config, err := NewTLSConfig(TLSOptions{})
+	if err != nil {
+		t.Fatal("Could not generate a self-signed TLS config", err)
+	}
+	dispatcher := &Dispatcher{
+		Handlers:  make(map[string]*Handler),
+		Lock:      sync.RWMutex{},
+		TLSConfig: config,
+	}
+	go dispatcher.ListenHandlers(8889)
+	time.Sleep(500 * time.Millisecond)
+
+	conn, err := tls.Dial("tcp", "127.0.0.1:8889", &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal("Could not establish a TLS connection", err)
+	}
+	conn.Close()
+
+	plainConn, err := net.Dial("tcp", "127.0.0.1:8889")
+	if err != nil {
+		t.Fatal("Could not connect to TLS server", err)
+	}
+	plainConn.Write([]byte("this is not a TLS client hello\n"))
+	plainConn.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	if stats := dispatcher.Stats(); stats.TLSHandshakeFailures != 1 {
+		t.Error("Handshake failures should be recorded, and stats are:", stats)
+	}
+}